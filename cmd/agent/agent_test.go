@@ -1,120 +1,410 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
-	"os/signal"
-	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/dglazkoff/go-metrics/cmd/agent/collector"
+	"github.com/dglazkoff/go-metrics/cmd/agent/config"
+	"github.com/dglazkoff/go-metrics/cmd/agent/queue"
+	serverConfig "github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/router"
+	"github.com/dglazkoff/go-metrics/cmd/server/storage/file"
+	"github.com/dglazkoff/go-metrics/cmd/server/storage/metrics"
 	constants "github.com/dglazkoff/go-metrics/internal/const"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/dglazkoff/go-metrics/internal/models"
-	"github.com/shirou/gopsutil/v4/mem"
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
-func TestParseMetrics(t *testing.T) {
+// fakeCollector - коллектор, который возвращает заданные метрики и ошибку
+type fakeCollector struct {
+	name    string
+	metrics []models.Metrics
+	err     error
+}
+
+func (c *fakeCollector) Name() string {
+	return c.name
+}
+
+func (c *fakeCollector) Collect(_ context.Context) ([]models.Metrics, error) {
+	return c.metrics, c.err
+}
+
+func float64Pointer(v float64) *float64 {
+	return &v
+}
+
+func TestSnapshot_Poll(t *testing.T) {
 	err := logger.Initialize()
-	assert.NoError(t, err)
+	require.NoError(t, err)
+
+	gauges := &fakeCollector{name: "gauges", metrics: []models.Metrics{
+		{MType: constants.MetricTypeGauge, ID: "Alloc", Value: float64Pointer(1)},
+		{MType: constants.MetricTypeGauge, ID: "Alloc", Value: float64Pointer(2), Labels: map[string]string{"pool": "heap"}},
+	}}
+	system := &fakeCollector{name: "system", metrics: []models.Metrics{
+		{MType: constants.MetricTypeGauge, ID: "TotalMemory", Value: float64Pointer(8000000)},
+	}}
+
+	registry, err := collector.NewRegistry(gauges, system)
+	require.NoError(t, err)
+
+	s := newSnapshot()
+	s.poll(context.Background(), registry)
+
+	assert.Equal(t, []models.Metrics{
+		{MType: constants.MetricTypeGauge, ID: "Alloc", Value: float64Pointer(1)},
+		{MType: constants.MetricTypeGauge, ID: "Alloc", Value: float64Pointer(2), Labels: map[string]string{"pool": "heap"}},
+		{MType: constants.MetricTypeGauge, ID: "TotalMemory", Value: float64Pointer(8000000)},
+		{MType: constants.MetricTypeCounter, ID: "PollCount", Delta: int64Pointer(1)},
+	}, s.get())
+
+	// метрика, которую коллектор не смог собрать, сохраняет прежнее значение
+	gauges.metrics = []models.Metrics{{MType: constants.MetricTypeGauge, ID: "Alloc", Value: float64Pointer(3)}}
+	system.metrics, system.err = nil, errors.New("not supported")
+	s.poll(context.Background(), registry)
+
+	assert.Equal(t, []models.Metrics{
+		{MType: constants.MetricTypeGauge, ID: "Alloc", Value: float64Pointer(3)},
+		{MType: constants.MetricTypeGauge, ID: "Alloc", Value: float64Pointer(2), Labels: map[string]string{"pool": "heap"}},
+		{MType: constants.MetricTypeGauge, ID: "TotalMemory", Value: float64Pointer(8000000)},
+		{MType: constants.MetricTypeCounter, ID: "PollCount", Delta: int64Pointer(2)},
+	}, s.get())
+}
+
+func TestWithLabels(t *testing.T) {
+	agentLabels := map[string]string{"host": "agent-1"}
+
+	assert.Equal(t, agentLabels, withLabels(nil, agentLabels))
+	assert.Equal(t,
+		map[string]string{"host": "agent-1", "pool": "heap"},
+		withLabels(map[string]string{"host": "collector", "pool": "heap"}, agentLabels),
+	)
+}
+
+func TestCounterTracker(t *testing.T) {
+	counters := newCounterTracker()
+	s := newSnapshot()
+	s.pollCount = 5
+
+	metrics := s.get()
+	taken := counters.take(metrics)
+	assert.Equal(t, map[string]int64{"PollCount": 5}, taken)
+
+	// пока первая пачка не доставлена, параллельный воркер не отправляет те же приращения
+	s.pollCount = 7
+	metrics = s.get()
+	counters.take(metrics)
+	assert.Contains(t, metrics, models.Metrics{MType: constants.MetricTypeCounter, ID: "PollCount", Delta: int64Pointer(2)})
+
+	// приращения недоставленной пачки уходят со следующей
+	counters.release(taken)
+	metrics = s.get()
+	counters.take(metrics)
+	assert.Contains(t, metrics, models.Metrics{MType: constants.MetricTypeCounter, ID: "PollCount", Delta: int64Pointer(5)})
+}
+
+func TestCounterTracker_OutOfOrderSnapshots(t *testing.T) {
+	counters := newCounterTracker()
+	older := []models.Metrics{{MType: constants.MetricTypeCounter, ID: "PollCount", Delta: int64Pointer(5)}}
+	newer := []models.Metrics{{MType: constants.MetricTypeCounter, ID: "PollCount", Delta: int64Pointer(7)}}
+
+	// воркер с более новым снимком успел раньше, старый снимок не дает отрицательного приращения
+	takenNewer := counters.take(newer)
+	takenOlder := counters.take(older)
+	assert.Equal(t, int64(7), *newer[0].Delta)
+	assert.Equal(t, int64(0), *older[0].Delta)
+
+	// неудачная отправка старого снимка не возвращает то, что отправил новый
+	counters.release(takenOlder)
+	assert.Equal(t, int64(7), counters.reported["PollCount"])
+
+	metrics := []models.Metrics{{MType: constants.MetricTypeCounter, ID: "PollCount", Delta: int64Pointer(9)}}
+	counters.take(metrics)
+	assert.Equal(t, int64(2), *metrics[0].Delta)
+
+	// если не доставлен новый снимок, его приращения уходят со следующей пачкой целиком
+	counters.release(takenNewer)
+	metrics = []models.Metrics{{MType: constants.MetricTypeCounter, ID: "PollCount", Delta: int64Pointer(9)}}
+	counters.take(metrics)
+	assert.Equal(t, int64(7), *metrics[0].Delta)
+	assert.Equal(t, int64(9), counters.reported["PollCount"])
+}
+
+func TestCounterTracker_Concurrent(t *testing.T) {
+	counters := newCounterTracker()
+
+	var (
+		mu    sync.Mutex
+		total int64
+		wg    sync.WaitGroup
+	)
+
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(value int64) {
+			defer wg.Done()
+
+			metrics := []models.Metrics{{MType: constants.MetricTypeCounter, ID: "PollCount", Delta: &value}}
+			taken := counters.take(metrics)
+
+			// каждая третья отправка неудачна
+			if value%3 == 0 {
+				counters.release(taken)
+				return
+			}
+
+			mu.Lock()
+			total += *metrics[0].Delta
+			mu.Unlock()
+		}(int64(i))
+	}
+
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, counters.reported["PollCount"], total)
+	assert.LessOrEqual(t, total, int64(100))
+
+	// снимки приходили в произвольном порядке, но следующая доставка досылает все потерянное
+	metrics := []models.Metrics{{MType: constants.MetricTypeCounter, ID: "PollCount", Delta: int64Pointer(100)}}
+	counters.take(metrics)
+	assert.Equal(t, int64(100), total+*metrics[0].Delta)
+}
+
+func TestUpdateMetrics_CounterIncrements(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	var fail atomic.Bool
+	var reject atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
 
-	floatValue := 42.5
-	var intValue int64 = 5
-	var uintValue uint64 = 10
+		if reject.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
 
-	var uintToFloatValue float64 = 10
+	cfg := &config.Config{RunAddr: strings.TrimPrefix(server.URL, "http://")}
+	counters := newCounterTracker()
+	s := newSnapshot()
+	s.pollCount = 5
+
+	tr, err := newTransport(cfg)
+	require.NoError(t, err)
+	r := &reporter{cfg: cfg, transport: tr, counters: counters}
+
+	// недоставленные приращения не считаются переданными
+	fail.Store(true)
+	updateMetrics(context.Background(), s, r)
+	assert.Equal(t, int64(0), counters.reported["PollCount"])
+
+	// отклоненная сервером пачка не применена, ее приращения тоже возвращаются
+	fail.Store(false)
+	reject.Store(true)
+	updateMetrics(context.Background(), s, r)
+	assert.Equal(t, int64(0), counters.reported["PollCount"])
+
+	reject.Store(false)
+	updateMetrics(context.Background(), s, r)
+	assert.Equal(t, int64(5), counters.reported["PollCount"])
+
+	// с очередью приращения считаются переданными, как только пачка записана на диск
+	q, err := queue.Open(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	r.queue = q
+
+	fail.Store(true)
+	s.pollCount = 8
+	updateMetrics(context.Background(), s, r)
+	assert.Equal(t, int64(8), counters.reported["PollCount"])
+	assert.Equal(t, 1, q.Len())
+
+	var deltas []int64
+	err = q.Drain(func(batch queue.Batch) error {
+		for _, metric := range batch.Metrics {
+			if metric.ID == "PollCount" {
+				deltas = append(deltas, *metric.Delta)
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3}, deltas)
+}
+
+func TestUpdateMetrics_Lookup(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	host, err := os.Hostname()
+	require.NoError(t, err)
+
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
 
 	tests := []struct {
-		name     string
-		gm       GaugeMetrics
-		cm       CounterMetrics
-		expected []models.Metrics
+		name  string
+		args  []string
+		query string
 	}{
-		{
-			name: "basic parse",
-			gm: GaugeMetrics{
-				RandomValue: floatValue,
-				MemStats: runtime.MemStats{
-					TotalAlloc: uintValue,
-				},
-			},
-			cm: CounterMetrics{
-				PollCount: intValue,
-			},
-			expected: []models.Metrics{
-				{MType: constants.MetricTypeGauge, ID: "RandomValue", Value: &floatValue},
-				{MType: constants.MetricTypeGauge, ID: "TotalAlloc", Value: &uintToFloatValue},
-				{MType: constants.MetricTypeCounter, ID: "PollCount", Delta: &intValue},
-			},
-		},
+		// по умолчанию метрики агента отличаются меткой host и находятся запросом с этой меткой
+		{name: "host label", query: "?host=" + url.QueryEscape(host)},
+		// без метки host метрики находятся запросом без меток
+		{name: "no host label", args: []string{"-no-host-label"}},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := parseMetrics(&tt.gm, &tt.cm)
-			assert.Subset(t, result, tt.expected)
+			serverCfg := &serverConfig.Config{}
+			store := metrics.New([]models.Metrics{})
+			server := httptest.NewServer(router.Router(store, file.New(store, serverCfg), serverCfg))
+			defer server.Close()
+
+			os.Args = append([]string{"cmd/agent", "-a", strings.TrimPrefix(server.URL, "http://"), "-c", ""}, tt.args...)
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+			cfg := config.ParseConfig()
+
+			tr, err := newTransport(&cfg)
+			require.NoError(t, err)
+			defer tr.Close()
+			r := &reporter{cfg: &cfg, transport: tr, counters: newCounterTracker()}
+
+			s := newSnapshot()
+			s.poll(context.Background(), testRegistry(t))
+			updateMetrics(context.Background(), s, r)
+
+			for _, path := range []string{"/value/counter/PollCount", "/value/gauge/Alloc"} {
+				res, err := http.Get(server.URL + path + tt.query)
+				require.NoError(t, err)
+				res.Body.Close()
+
+				assert.Equal(t, http.StatusOK, res.StatusCode, path)
+			}
 		})
 	}
 }
 
-type MockMem struct {
-	mock.Mock
+func int64Pointer(v int64) *int64 {
+	return &v
 }
 
-func (m *MockMem) VirtualMemory() (*mem.VirtualMemoryStat, error) {
-	args := m.Called()
-	return args.Get(0).(*mem.VirtualMemoryStat), args.Error(1)
-}
+func testRegistry(t *testing.T) *collector.Registry {
+	registry, err := collector.NewRegistry(collector.NewRuntime(), collector.NewRandom())
+	require.NoError(t, err)
 
-type MockCPU struct {
-	mock.Mock
+	return registry
 }
 
-func (c *MockCPU) Counts(logical bool) (int, error) {
-	args := c.Called(logical)
-	return args.Int(0), args.Error(1)
+func TestRunAgent_Shutdown(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	server, _, received := httpServer(t)
+	cfg := &config.Config{RunAddr: strings.TrimPrefix(server.URL, "http://"), PollInterval: 1, ReportInterval: 1, RateLimit: 2}
+
+	tr, err := newTransport(cfg)
+	require.NoError(t, err)
+	r := &reporter{cfg: cfg, transport: tr, counters: newCounterTracker()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// остановленный агент не ждет следующего тика и отправляет последнюю пачку
+	done := make(chan struct{})
+	go func() {
+		runAgent(ctx, r, testRegistry(t), time.Second)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("agent was not stopped")
+	}
+
+	assert.Positive(t, received.Load())
 }
 
-func TestWriteMetricsOnce(t *testing.T) {
-	gm := &GaugeMetrics{}
-	cm := &CounterMetrics{}
+func TestRunAgent_ShutdownTimeout(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	// сервер не отвечает до конца теста
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	cfg := &config.Config{RunAddr: strings.TrimPrefix(server.URL, "http://"), PollInterval: 1, ReportInterval: 1, RateLimit: 1}
 
-	mockMem := &MockMem{}
-	mockCPU := &MockCPU{}
-	mockMem.On("VirtualMemory").Return(&mem.VirtualMemoryStat{Total: 8000000, Free: 2000000}, nil)
-	mockCPU.On("Counts", false).Return(4, nil)
+	tr, err := newTransport(cfg)
+	require.NoError(t, err)
 
-	writeMetricsOnce(gm, cm, mockMem.VirtualMemory, mockCPU.Counts)
+	q, err := queue.Open(t.TempDir(), 0, 0)
+	require.NoError(t, err)
 
-	assert.Equal(t, 8000000.0, gm.TotalMemory, "TotalMemory should match the mocked value")
-	assert.Equal(t, 2000000.0, gm.FreeMemory, "FreeMemory should match the mocked value")
-	assert.Equal(t, 4.0, gm.CPUutilization1, "CPUutilization1 should match the mocked value")
-	assert.Equal(t, int64(1), cm.PollCount, "PollCount should be incremented")
+	r := &reporter{cfg: cfg, transport: tr, queue: q, counters: newCounterTracker()}
 
-	mockMem.AssertExpectations(t)
-	mockCPU.AssertExpectations(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		runAgent(ctx, r, testRegistry(t), 50*time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("agent did not stop after shutdown timeout")
+	}
+
+	// недоставленная последняя пачка осталась в очереди
+	assert.Equal(t, 1, q.Len())
 }
 
 func TestRunApp_Success(t *testing.T) {
 	err := logger.Initialize()
 	assert.NoError(t, err)
 
+	server, _, received := httpServer(t)
+
 	originalArgs := os.Args
 	defer func() { os.Args = originalArgs }()
 	os.Args = []string{
 		"cmd/agent",
+		"-a", strings.TrimPrefix(server.URL, "http://"),
 		"-p", "10",
 		"-l", "1",
 	}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
-
+	done := make(chan error, 1)
 	go func() {
-		err := runApp()
-		assert.NoError(t, err)
+		done <- runApp()
 	}()
 
 	time.Sleep(100 * time.Millisecond)
@@ -124,7 +414,12 @@ func TestRunApp_Success(t *testing.T) {
 	}
 	process.Signal(syscall.SIGTERM)
 
-	time.Sleep(100 * time.Millisecond)
+	select {
+	case err = <-done:
+		assert.NoError(t, err, "runApp should complete without error")
+	case <-time.After(shutdownTimeout):
+		t.Fatal("runApp was not stopped")
+	}
 
-	assert.NoError(t, err, "runApp should complete without error")
+	assert.Positive(t, received.Load(), "final batch should be sent on shutdown")
 }
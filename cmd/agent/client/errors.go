@@ -0,0 +1,14 @@
+package client
+
+/*
+RejectedError - сервер отклонил пачку: ответил 4xx по HTTP или по gRPC отказал в доступе, не принял подпись или данные.
+Повтор той же пачки не поможет, пока не исправлены настройки агента, но сервер ее не применил,
+поэтому приращения counter из пачки не считаются переданными
+*/
+type RejectedError struct {
+	Status string
+}
+
+func (e *RejectedError) Error() string {
+	return "batch rejected by server: " + e.Status
+}
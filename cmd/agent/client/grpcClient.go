@@ -2,13 +2,39 @@ package client
 
 import (
 	"context"
-	"fmt"
+	"encoding/hex"
+	"time"
 
+	"github.com/dglazkoff/go-metrics/cmd/agent/config"
 	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/dglazkoff/go-metrics/internal/models"
 	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
+	"github.com/dglazkoff/go-metrics/internal/signature"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	// регистрирует проверку здоровья сервера для healthCheckConfig
+	_ "google.golang.org/grpc/health"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
+// KeepaliveTime - период keepalive пингов агента, сервер разрешает пинги не чаще keepalive.EnforcementPolicy.MinTime
+const KeepaliveTime = 30 * time.Second
+
+/*
+serviceConfig - проверка здоровья сервера через сервис grpc.health.v1.Health. Пока сервер отвечает NOT_SERVING,
+соединение не используется и запросы сразу завершаются с Unavailable, а пачки остаются в очереди.
+Проверку здоровья поддерживает балансировщик round_robin, у агента он работает с единственным адресом сервера
+*/
+const serviceConfig = `{"loadBalancingConfig": [{"round_robin": {}}], "healthCheckConfig": {"serviceName": ""}}`
+
 type GRPCMetricsClient struct {
 	client pb.MetricsClient
 }
@@ -17,14 +43,127 @@ func NewMetricsClient(conn pb.MetricsClient) *GRPCMetricsClient {
 	return &GRPCMetricsClient{client: conn}
 }
 
-func (c *GRPCMetricsClient) SendMetricsByGRPC(metrics []models.Metrics) {
+/*
+DialGRPC - соединение с gRPC сервером, одно на все время работы агента.
+Соединение устанавливается при первом запросе и восстанавливается после обрыва с экспоненциальной задержкой,
+keepalive пинги обнаруживают оборванное соединение, пока агент не отправляет метрики, а проверка здоровья - сервер, который не готов принимать метрики
+*/
+func DialGRPC(cfg *config.Config) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+
+	if cfg.IsTLS() {
+		tlsConfig, err := TLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	return grpc.NewClient(cfg.RunAddr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(UnaryInterceptor(cfg.SecretKey, cfg.Token)),
+		grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)),
+		grpc.WithDefaultServiceConfig(serviceConfig),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  time.Second,
+				Multiplier: 1.6,
+				Jitter:     0.2,
+				MaxDelay:   30 * time.Second,
+			},
+			MinConnectTimeout: 5 * time.Second,
+		}),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                KeepaliveTime,
+			Timeout:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	)
+}
+
+/*
+UnaryInterceptor - добавляет к gRPC запросу метаданные x-real-ip, токен агента authorization, если задан token,
+и, если задан secretKey, подпись hashsha256 детерминированно сериализованного запроса вместе с x-timestamp и x-nonce -
+так же, как HTTP клиент передает заголовки X-Real-IP, Authorization, HashSHA256, X-Timestamp и X-Nonce
+*/
+func UnaryInterceptor(secretKey, token string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-real-ip", GetLocalIP())
+
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		}
+
+		if m, ok := req.(proto.Message); ok && secretKey != "" {
+			data, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+
+			if err != nil {
+				return err
+			}
+
+			nonce, err := signature.NewNonce()
+			if err != nil {
+				return err
+			}
+
+			timestamp := signature.Timestamp(time.Now())
+			ctx = metadata.AppendToOutgoingContext(ctx,
+				"hashsha256", hex.EncodeToString(signature.Sign(secretKey, timestamp, nonce, data)),
+				signature.TimestampMetadataKey, timestamp,
+				signature.NonceMetadataKey, nonce,
+			)
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+/*
+SignStreamRequest - подписывает пачку стрима StreamMetrics ключом secretKey. Метаданные у стрима общие на все пачки,
+поэтому подпись, x-timestamp и x-nonce передаются в самой пачке, а подписывается пачка с пустыми полями подписи
+*/
+func SignStreamRequest(secretKey string, req *pb.UpdateMetricsRequest) error {
+	req.Hash, req.Timestamp, req.Nonce = "", "", ""
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	nonce, err := signature.NewNonce()
+	if err != nil {
+		return err
+	}
+
+	timestamp := signature.Timestamp(time.Now())
+	req.Hash = hex.EncodeToString(signature.Sign(secretKey, timestamp, nonce, data))
+	req.Timestamp = timestamp
+	req.Nonce = nonce
+
+	return nil
+}
+
+// SendMetricsByGRPC - отправляет метрики новой пачкой
+func (c *GRPCMetricsClient) SendMetricsByGRPC(metrics []models.Metrics) error {
+	return c.SendBatchByGRPC(context.Background(), NewBatchID(), metrics)
+}
+
+/*
+SendBatchByGRPC - отправляет метрики пачкой batchID.
+Ошибка возвращается, если сервер недоступен или не успел обработать запрос и пачку стоит отправить позже.
+На пачку, отклоненную сервером по другой причине (PermissionDenied, Unauthenticated, InvalidArgument и т.д.), возвращается *RejectedError.
+Запрос, прерванный отменой ctx, считается недоставленным
+*/
+func (c *GRPCMetricsClient) SendBatchByGRPC(ctx context.Context, batchID string, metrics []models.Metrics) error {
 	protoMetrics := make([]*pb.Metric, 0, len(metrics))
 
 	for _, metric := range metrics {
 		if metric.MType == constants.MetricTypeGauge {
 			protoMetrics = append(protoMetrics, &pb.Metric{
-				Id:   metric.ID,
-				Type: pb.Metric_Gauge,
+				Id:     metric.ID,
+				Type:   pb.Metric_Gauge,
+				Labels: metric.Labels,
 				MetricValue: &pb.Metric_Value{
 					Value: *metric.Value,
 				},
@@ -33,8 +172,9 @@ func (c *GRPCMetricsClient) SendMetricsByGRPC(metrics []models.Metrics) {
 
 		if metric.MType == constants.MetricTypeCounter {
 			protoMetrics = append(protoMetrics, &pb.Metric{
-				Id:   metric.ID,
-				Type: pb.Metric_Counter,
+				Id:     metric.ID,
+				Type:   pb.Metric_Counter,
+				Labels: metric.Labels,
 				MetricValue: &pb.Metric_Delta{
 					Delta: *metric.Delta,
 				},
@@ -42,9 +182,30 @@ func (c *GRPCMetricsClient) SendMetricsByGRPC(metrics []models.Metrics) {
 		}
 	}
 
-	res, _ := c.client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+	// по x-batch-id сервер не применяет пачку повторно, если запрос будет доставлен еще раз
+	if batchID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, constants.BatchIDMetadataKey, batchID)
+	}
+
+	res, err := c.client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{
 		Metrics: protoMetrics,
 	})
 
-	fmt.Println(res)
+	if err != nil {
+		logger.Log.Debug("Error on gRPC request: ", err)
+
+		switch status.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled, codes.ResourceExhausted, codes.Aborted, codes.Internal:
+			return err
+		}
+
+		return &RejectedError{Status: status.Code(err).String()}
+	}
+
+	logger.Log.Debug("Metrics sent, accepted: ", res.Accepted, " rejected: ", res.Rejected)
+	for _, rejectErr := range res.Errors {
+		logger.Log.Debug("Metric rejected: ", rejectErr)
+	}
+
+	return nil
 }
@@ -2,6 +2,10 @@ package client
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"net"
 	"testing"
 
@@ -9,11 +13,17 @@ import (
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/dglazkoff/go-metrics/internal/models"
 	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
+	"github.com/dglazkoff/go-metrics/internal/signature"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
 )
 
 type MockMetricsClient struct {
@@ -25,6 +35,21 @@ func (m *MockMetricsClient) UpdateMetrics(ctx context.Context, req *pb.UpdateMet
 	return args.Get(0).(*pb.UpdateMetricsResponse), args.Error(1)
 }
 
+func (m *MockMetricsClient) GetMetric(ctx context.Context, req *pb.GetMetricRequest, opts ...grpc.CallOption) (*pb.Metric, error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).(*pb.Metric), args.Error(1)
+}
+
+func (m *MockMetricsClient) ListMetrics(ctx context.Context, req *pb.ListMetricsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.Metric], error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).(grpc.ServerStreamingClient[pb.Metric]), args.Error(1)
+}
+
+func (m *MockMetricsClient) StreamMetrics(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[pb.UpdateMetricsRequest, pb.UpdateMetricsResponse], error) {
+	args := m.Called(ctx)
+	return args.Get(0).(grpc.ClientStreamingClient[pb.UpdateMetricsRequest, pb.UpdateMetricsResponse]), args.Error(1)
+}
+
 func TestSendMetricsByGRPC(t *testing.T) {
 	err := logger.Initialize()
 	assert.NoError(t, err)
@@ -71,3 +96,67 @@ func TestSendMetricsByGRPC(t *testing.T) {
 
 	mockClient.AssertCalled(t, "UpdateMetrics", mock.Anything, mock.Anything)
 }
+
+func TestSendBatchByGRPC_Rejected(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	tests := []struct {
+		code     codes.Code
+		rejected bool
+	}{
+		{code: codes.Unavailable, rejected: false},
+		{code: codes.PermissionDenied, rejected: true},
+		{code: codes.Unauthenticated, rejected: true},
+		{code: codes.InvalidArgument, rejected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			mockClient := &MockMetricsClient{}
+			mockClient.On("UpdateMetrics", mock.Anything, mock.Anything).Return(&pb.UpdateMetricsResponse{}, status.Error(tt.code, "error"))
+
+			// любая ошибка означает, что пачка не применена, отклоненную пачку повторять без изменения настроек бесполезно
+			err := NewMetricsClient(mockClient).SendBatchByGRPC(context.Background(), "batch-1", []models.Metrics{})
+			require.Error(t, err)
+
+			var rejected *RejectedError
+			assert.Equal(t, tt.rejected, errors.As(err, &rejected))
+		})
+	}
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "PollCount", Type: pb.Metric_Counter, MetricValue: &pb.Metric_Delta{Delta: 1}}}}
+
+	var md metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+
+	err := UnaryInterceptor("secret", "")(context.Background(), "/models.Metrics/UpdateMetrics", req, nil, nil, invoker)
+	require.NoError(t, err)
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	require.NoError(t, err)
+
+	// подписаны время отправки и nonce вместе с запросом
+	require.Len(t, md.Get(signature.TimestampMetadataKey), 1)
+	require.Len(t, md.Get(signature.NonceMetadataKey), 1)
+	assert.NotEmpty(t, md.Get(signature.NonceMetadataKey)[0])
+
+	h := hmac.New(sha256.New, []byte("secret"))
+	h.Write([]byte(md.Get(signature.TimestampMetadataKey)[0] + "\n" + md.Get(signature.NonceMetadataKey)[0] + "\n"))
+	h.Write(data)
+
+	assert.Equal(t, []string{hex.EncodeToString(h.Sum(nil))}, md.Get("hashsha256"))
+	assert.Equal(t, []string{GetLocalIP()}, md.Get("x-real-ip"))
+
+	assert.Empty(t, md.Get("authorization"))
+
+	err = UnaryInterceptor("", "agent-token")(context.Background(), "/models.Metrics/UpdateMetrics", req, nil, nil, invoker)
+	require.NoError(t, err)
+	assert.Empty(t, md.Get("hashsha256"))
+	assert.Equal(t, []string{"Bearer agent-token"}, md.Get("authorization"))
+}
@@ -3,10 +3,8 @@ package client
 import (
 	"bytes"
 	"compress/gzip"
-	"crypto/hmac"
+	"context"
 	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
@@ -14,19 +12,30 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/dglazkoff/go-metrics/cmd/agent/config"
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/envelope"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/dglazkoff/go-metrics/internal/signature"
 	"github.com/go-resty/resty/v2"
 )
 
+/*
+Client - HTTP клиент агента. Соединения с сервером переиспользуются (keep-alive), поэтому один Client
+создается на все время работы агента и может использоваться из нескольких горутин одновременно
+*/
 type Client struct {
 	client         *resty.Client
 	retryIntervals []time.Duration
+	mu             sync.Mutex
+	configured     bool
 }
 
 func NewClient(retryIntervals []time.Duration) *Client {
@@ -34,6 +43,12 @@ func NewClient(retryIntervals []time.Duration) *Client {
 	return &Client{client: client, retryIntervals: retryIntervals}
 }
 
+// Close - закрывает простаивающие keep-alive соединения с сервером
+func (c *Client) Close() error {
+	c.client.GetClient().CloseIdleConnections()
+	return nil
+}
+
 func GetLocalIP() string {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
@@ -49,24 +64,84 @@ func GetLocalIP() string {
 	return ""
 }
 
-func (c *Client) SendMetricsByHTTP(metrics []models.Metrics, cfg *config.Config) {
-	c.client.SetBaseURL("http://" + cfg.RunAddr)
+// SendMetricsByHTTP - отправляет метрики на /updates/ новой пачкой
+func (c *Client) SendMetricsByHTTP(metrics []models.Metrics, cfg *config.Config) error {
+	return c.SendBatchByHTTP(context.Background(), NewBatchID(), metrics, cfg)
+}
+
+/*
+SendBatchByHTTP - отправляет метрики на /updates/ пачкой batchID.
+Ошибка возвращается, если пачку не удалось доставить и ее стоит отправить позже:
+сервер недоступен, ответил 5xx или метрики не удалось подготовить к отправке.
+На пачку, отклоненную сервером с 4xx, возвращается *RejectedError: клиент ее не повторяет, но и доставленной она не считается.
+Отмена ctx прерывает запрос и повторы, пачка считается недоставленной
+*/
+func (c *Client) SendBatchByHTTP(ctx context.Context, batchID string, metrics []models.Metrics, cfg *config.Config) error {
+	if err := c.configure(cfg); err != nil {
+		return err
+	}
+
 	body, err := json.Marshal(metrics)
 
 	if err != nil {
-		return
+		return err
 	}
 
 	encryptedBody, err := EncryptBody(body, cfg)
 
 	if err != nil {
-		c.sendBody(body, cfg)
-		return
+		// ключ задан, но зашифровать не удалось - в режиме strict-security открытым текстом не отправляем
+		if cfg.StrictSecurity && cfg.CryptoKey != "" {
+			logger.Log.Debug("Metrics are not sent, error while encrypting body: ", err)
+			return err
+		}
+
+		return c.sendBody(ctx, body, "", cfg, batchID)
+	}
+
+	// по идентификатору сервер выбирает ключ для расшифровки, без него перебирает все свои ключи
+	return c.sendBody(ctx, encryptedBody, cfg.CryptoKeyID, cfg, batchID)
+}
+
+/*
+configure - настраивает адрес сервера, TLS и токен агента при первой отправке.
+Дальше настройки клиента не меняются, и горутины отправляют метрики через общий пул соединений.
+Если TLS конфигурацию загрузить не удалось, настройка повторится при следующей отправке
+*/
+func (c *Client) configure(cfg *config.Config) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.configured {
+		return nil
+	}
+
+	if cfg.IsTLS() {
+		tlsConfig, err := TLSConfig(cfg)
+
+		// без TLS метрики не отправляем, чтобы не передать их открытым текстом
+		if err != nil {
+			logger.Log.Debug("Error while load tls config: ", err)
+			return err
+		}
+
+		c.client.SetTLSClientConfig(tlsConfig)
+		c.client.SetBaseURL("https://" + cfg.RunAddr)
+	} else {
+		c.client.SetBaseURL("http://" + cfg.RunAddr)
 	}
 
-	c.sendBody(encryptedBody, cfg)
+	// сервер принимает запись метрик только от агентов с токеном из своего файла ключей
+	if cfg.Token != "" {
+		c.client.SetAuthToken(cfg.Token)
+	}
+
+	c.configured = true
+
+	return nil
 }
 
+// EncryptBody - шифрует тело конвертом envelope публичным ключом сервера из cfg.CryptoKey
 func EncryptBody(body []byte, cfg *config.Config) ([]byte, error) {
 	publicKeyPEM, err := os.ReadFile(cfg.CryptoKey)
 
@@ -75,84 +150,138 @@ func EncryptBody(body []byte, cfg *config.Config) ([]byte, error) {
 	}
 
 	publicKeyBlock, _ := pem.Decode(publicKeyPEM)
+	if publicKeyBlock == nil {
+		return nil, errors.New("no PEM data in public key file")
+	}
+
 	publicKey, err := x509.ParsePKCS1PublicKey(publicKeyBlock.Bytes)
 	if err != nil {
 		return nil, err
 	}
 
-	var encryptedBuffer bytes.Buffer
-	segmentSize := 256
-	for i := 0; i < len(body); i += segmentSize {
-		j := i + segmentSize
-		if j > len(body) {
-			j = len(body)
-		}
-		segmentToEncrypt := body[i:j]
+	return envelope.Seal(publicKey, body)
+}
 
-		encryptedSegment, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, segmentToEncrypt)
+// requestSignature - подпись тела и подписанные вместе с ним время отправки и nonce
+type requestSignature struct {
+	hash      []byte
+	timestamp string
+	nonce     string
+}
 
-		if err != nil {
-			return nil, err
-		}
+// signBody - подписывает body ключом secretKey вместе с текущим временем и новым nonce
+func signBody(secretKey string, body []byte) (*requestSignature, error) {
+	nonce, err := signature.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := signature.Timestamp(time.Now())
+
+	return &requestSignature{
+		hash:      signature.Sign(secretKey, timestamp, nonce, body),
+		timestamp: timestamp,
+		nonce:     nonce,
+	}, nil
+}
+
+// NewBatchID - случайный идентификатор пачки метрик, по нему сервер не применяет повторно доставленную пачку
+func NewBatchID() string {
+	id := make([]byte, 16)
 
-		encryptedBuffer.Write(encryptedSegment)
+	if _, err := rand.Read(id); err != nil {
+		logger.Log.Debug("Error while generating batch id: ", err)
+		return ""
 	}
 
-	return encryptedBuffer.Bytes(), nil
+	return hex.EncodeToString(id)
 }
 
-func (c *Client) sendRequest(body interface{}, hash []byte, retryNumber int) {
+/*
+sendRequest - отправляет тело на /updates/. Повторы после сетевой ошибки уходят с тем же X-Batch-ID:
+если первая попытка все-таки дошла до сервера, повтор будет подтвержден без повторного применения.
+Подпись каждой попытки новая, иначе сервер отклонил бы повтор по уже использованному nonce
+*/
+func (c *Client) sendRequest(ctx context.Context, body []byte, keyID string, cfg *config.Config, batchID string, retryNumber int) error {
 	logger.Log.Debug("Do request to /updates/")
-	request := c.client.R().SetBody(body).
+	request := c.client.R().SetContext(ctx).SetBody(body).
 		SetHeader("Content-Encoding", "gzip").
 		SetHeader("Content-Type", "application/json").
 		SetHeader("X-Real-IP", GetLocalIP())
 
-	if hash != nil {
-		request.SetHeader("HashSHA256", hex.EncodeToString(hash))
+	if keyID != "" {
+		request.SetHeader(envelope.KeyIDHeader, keyID)
+	}
+
+	if batchID != "" {
+		request.SetHeader(constants.BatchIDHeader, batchID)
+	}
+
+	if cfg.SecretKey != "" {
+		logger.Log.Debug("Encoding body")
+
+		sig, err := signBody(cfg.SecretKey, body)
+		if err != nil {
+			logger.Log.Debug("Error while signing body: ", err)
+			return err
+		}
+
+		request.SetHeader("HashSHA256", hex.EncodeToString(sig.hash)).
+			SetHeader(signature.TimestampHeader, sig.timestamp).
+			SetHeader(signature.NonceHeader, sig.nonce)
 	}
 
 	res, err := request.Post("/updates/")
 
-	fmt.Println(res.Status())
+	logger.Log.Debug("Response status: ", res.Status())
 
 	if err != nil {
 		logger.Log.Debug("Error on request: ", err)
 
 		var urlErr *url.Error
-		if errors.As(err, &urlErr) {
-			if retryNumber == 3 {
-				return
+		if errors.As(err, &urlErr) && ctx.Err() == nil {
+			if retryNumber >= len(c.retryIntervals) {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.retryIntervals[retryNumber]):
 			}
 
-			time.Sleep(c.retryIntervals[retryNumber])
-			c.sendRequest(body, hash, retryNumber+1)
+			return c.sendRequest(ctx, body, keyID, cfg, batchID, retryNumber+1)
 		}
+
+		return err
+	}
+
+	if res.StatusCode() >= http.StatusInternalServerError {
+		return fmt.Errorf("server responded with %s", res.Status())
 	}
+
+	if res.IsError() {
+		logger.Log.Debug("Metrics rejected by server: ", res.Status())
+		return &RejectedError{Status: res.Status()}
+	}
+
+	return nil
 }
 
-func (c *Client) sendBody(body []byte, cfg *config.Config) {
-	buf := bytes.NewBuffer(body)
+func (c *Client) sendBody(ctx context.Context, body []byte, keyID string, cfg *config.Config, batchID string) error {
+	buf := new(bytes.Buffer)
 	zb := gzip.NewWriter(buf)
 	_, err := zb.Write(body)
 
 	if err != nil {
-		return
+		return err
 	}
 
 	err = zb.Close()
 
 	if err != nil {
-		return
-	}
-
-	var hash []byte
-	if cfg.SecretKey != "" {
-		logger.Log.Debug("Encoding body")
-		h := hmac.New(sha256.New, []byte(cfg.SecretKey))
-		h.Write(buf.Bytes())
-		hash = h.Sum(nil)
+		return err
 	}
 
-	c.sendRequest(buf, hash, 0)
+	return c.sendRequest(ctx, buf.Bytes(), keyID, cfg, batchID, 0)
 }
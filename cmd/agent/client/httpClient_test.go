@@ -2,19 +2,29 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/dglazkoff/go-metrics/cmd/agent/config"
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/envelope"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/dglazkoff/go-metrics/internal/signature"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -72,6 +82,84 @@ func TestClient_SendRequest_RetryLogic(t *testing.T) {
 	assert.Equal(t, 3, info["POST http://localhost:8080/updates/"], "Expected /updates/ to be called three times")
 }
 
+func TestClient_SendRequest_RetryBatchID(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	httpClient := NewClient([]time.Duration{1 * time.Millisecond, 2 * time.Millisecond})
+	httpmock.ActivateNonDefault(httpClient.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	var batchIDs, nonces []string
+	httpmock.RegisterResponder("POST", "http://localhost:8080/updates/",
+		func(req *http.Request) (*http.Response, error) {
+			batchIDs = append(batchIDs, req.Header.Get(constants.BatchIDHeader))
+			nonces = append(nonces, req.Header.Get(signature.NonceHeader))
+
+			if len(batchIDs) < 2 {
+				return nil, errors.New("simulated network error")
+			}
+			return httpmock.NewStringResponse(200, "OK"), nil
+		},
+	)
+
+	cfg := &config.Config{
+		RunAddr:   "localhost:8080",
+		SecretKey: "testkey",
+	}
+	httpClient.SendMetricsByHTTP([]models.Metrics{}, cfg)
+
+	// повтор отправляет ту же пачку, но подписывается заново
+	require.Len(t, batchIDs, 2)
+	assert.NotEmpty(t, batchIDs[0])
+	assert.Equal(t, batchIDs[0], batchIDs[1])
+	assert.NotEqual(t, nonces[0], nonces[1])
+}
+
+func TestClient_SendBatchByHTTP_Delivery(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	httpClient := NewClient([]time.Duration{})
+	httpmock.ActivateNonDefault(httpClient.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	cfg := &config.Config{RunAddr: "localhost:8080"}
+
+	// 5xx - пачку стоит отправить позже
+	httpmock.RegisterResponder("POST", "http://localhost:8080/updates/", httpmock.NewStringResponder(http.StatusInternalServerError, ""))
+	assert.Error(t, httpClient.SendBatchByHTTP(context.Background(), "batch-1", []models.Metrics{}, cfg))
+
+	// пачка, отклоненная сервером, не повторяется, но и доставленной не считается
+	httpmock.RegisterResponder("POST", "http://localhost:8080/updates/", httpmock.NewStringResponder(http.StatusUnauthorized, ""))
+	err = httpClient.SendBatchByHTTP(context.Background(), "batch-1", []models.Metrics{}, cfg)
+	var rejected *RejectedError
+	assert.ErrorAs(t, err, &rejected)
+
+	httpmock.RegisterResponder("POST", "http://localhost:8080/updates/", httpmock.NewErrorResponder(errors.New("connection refused")))
+	assert.Error(t, httpClient.SendBatchByHTTP(context.Background(), "batch-1", []models.Metrics{}, cfg))
+}
+
+func TestClient_SendBatchByHTTP_Canceled(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	httpClient := NewClient([]time.Duration{time.Hour})
+	httpmock.ActivateNonDefault(httpClient.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("POST", "http://localhost:8080/updates/", httpmock.NewErrorResponder(errors.New("connection refused")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// отмена прерывает ожидание повтора
+	start := time.Now()
+	err = httpClient.SendBatchByHTTP(ctx, "batch-1", []models.Metrics{}, &config.Config{RunAddr: "localhost:8080"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
 //
 //func TestClient_SendBody_ErrorHandling(t *testing.T) {
 //	httpmock.ActivateNonDefault(resty.New().GetClient())
@@ -120,6 +208,12 @@ func TestEncryptBody(t *testing.T) {
 			setup:       func() {},
 			expectError: false,
 		},
+		{
+			name:        "body larger than rsa key",
+			body:        bytes.Repeat([]byte("A"), 10000),
+			setup:       func() {},
+			expectError: false,
+		},
 		{
 			name: "error reading public key",
 			body: []byte("test body for encryption"),
@@ -129,9 +223,12 @@ func TestEncryptBody(t *testing.T) {
 			expectError: true,
 		},
 		{
-			name:        "error encrypting segment",
-			body:        bytes.Repeat([]byte("A"), 256),
-			setup:       func() {},
+			name: "error parsing public key",
+			body: []byte("test body for encryption"),
+			setup: func() {
+				cfg.CryptoKey = keyFile.Name()
+				os.WriteFile(keyFile.Name(), []byte("invalid_key_data"), 0644)
+			},
 			expectError: true,
 		},
 	}
@@ -148,7 +245,129 @@ func TestEncryptBody(t *testing.T) {
 				assert.NoError(t, err)
 				assert.NotNil(t, encryptedBody)
 				assert.NotEmpty(t, encryptedBody)
+
+				decryptedBody, err := envelope.Open(privateKey, encryptedBody)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.body, decryptedBody)
 			}
 		})
 	}
 }
+
+func TestClient_SendMetricsByHTTP_KeyID(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	keyPath := filepath.Join(t.TempDir(), "public.pem")
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)})
+	require.NoError(t, os.WriteFile(keyPath, publicKeyPEM, 0600))
+
+	keyIDs := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyIDs <- r.Header.Get(envelope.KeyIDHeader)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		RunAddr:     strings.TrimPrefix(server.URL, "http://"),
+		CryptoKey:   keyPath,
+		CryptoKeyID: "2024-06",
+	}
+
+	httpClient := NewClient([]time.Duration{1 * time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond})
+	httpClient.SendMetricsByHTTP([]models.Metrics{}, cfg)
+	assert.Equal(t, "2024-06", <-keyIDs)
+
+	// без ключа тело не шифруется, и идентификатор ключа не отправляется
+	cfg.CryptoKey = filepath.Join(t.TempDir(), "missing.pem")
+	httpClient.SendMetricsByHTTP([]models.Metrics{}, cfg)
+	assert.Equal(t, "", <-keyIDs)
+}
+
+func TestClient_SendMetricsByHTTP_StrictSecurity(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		RunAddr:   strings.TrimPrefix(server.URL, "http://"),
+		CryptoKey: filepath.Join(t.TempDir(), "missing.pem"),
+	}
+
+	httpClient := NewClient([]time.Duration{1 * time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond})
+
+	// без strict-security тело, которое не удалось зашифровать, уходит открытым текстом
+	httpClient.SendMetricsByHTTP([]models.Metrics{}, cfg)
+	assert.Equal(t, int32(1), requests.Load())
+
+	cfg.StrictSecurity = true
+	httpClient.SendMetricsByHTTP([]models.Metrics{}, cfg)
+	assert.Equal(t, int32(1), requests.Load())
+
+	// ключ шифрования не задан - шифровать нечем, strict-security отправку не блокирует
+	cfg.CryptoKey = ""
+	httpClient.SendMetricsByHTTP([]models.Metrics{}, cfg)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestClient_SendMetricsByHTTP_Token(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	authorization := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization <- r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		RunAddr: strings.TrimPrefix(server.URL, "http://"),
+		Token:   "agent-token",
+	}
+
+	httpClient := NewClient([]time.Duration{1 * time.Millisecond})
+	httpClient.SendMetricsByHTTP([]models.Metrics{}, cfg)
+	assert.Equal(t, "Bearer agent-token", <-authorization)
+}
+
+func TestClient_SendMetricsByHTTP_Signature(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	requests := make(chan *http.Request, 2)
+	bodies := make(chan []byte, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		RunAddr:   strings.TrimPrefix(server.URL, "http://"),
+		SecretKey: "testkey",
+	}
+
+	httpClient := NewClient([]time.Duration{1 * time.Millisecond})
+	httpClient.SendMetricsByHTTP([]models.Metrics{}, cfg)
+	httpClient.SendMetricsByHTTP([]models.Metrics{}, cfg)
+
+	first, body := <-requests, <-bodies
+	timestamp := first.Header.Get(signature.TimestampHeader)
+	nonce := first.Header.Get(signature.NonceHeader)
+	require.NotEmpty(t, timestamp)
+	require.NotEmpty(t, nonce)
+	assert.Equal(t, hex.EncodeToString(signature.Sign("testkey", timestamp, nonce, body)), first.Header.Get("HashSHA256"))
+
+	// каждый запрос - с новым nonce
+	second := <-requests
+	assert.NotEqual(t, nonce, second.Header.Get(signature.NonceHeader))
+}
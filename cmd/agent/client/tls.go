@@ -0,0 +1,46 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/dglazkoff/go-metrics/cmd/agent/config"
+)
+
+/*
+TLSConfig - TLS конфигурация подключения агента к серверу.
+Сертификат сервера проверяется по cfg.TLSCA, а если он не задан - по системным корневым сертификатам.
+Если задан клиентский сертификат, агент предъявляет его серверу (mTLS)
+*/
+func TLSConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.TLSCA != "" {
+		caPEM, err := os.ReadFile(cfg.TLSCA)
+
+		if err != nil {
+			return nil, fmt.Errorf("error while reading ca bundle: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates in ca bundle %s", cfg.TLSCA)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+
+		if err != nil {
+			return nil, fmt.Errorf("error while loading client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
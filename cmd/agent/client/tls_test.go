@@ -0,0 +1,75 @@
+package client
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dglazkoff/go-metrics/cmd/agent/config"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	wrongCA := filepath.Join(dir, "wrong.crt")
+	require.NoError(t, os.WriteFile(wrongCA, []byte("not a certificate"), 0600))
+
+	tlsConfig, err := TLSConfig(&config.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, tlsConfig.RootCAs)
+	assert.Empty(t, tlsConfig.Certificates)
+
+	_, err = TLSConfig(&config.Config{TLSCA: filepath.Join(dir, "not-exists.crt")})
+	assert.Error(t, err)
+
+	_, err = TLSConfig(&config.Config{TLSCA: wrongCA})
+	assert.Error(t, err)
+
+	_, err = TLSConfig(&config.Config{TLSCert: wrongCA, TLSKey: wrongCA})
+	assert.Error(t, err)
+}
+
+func TestClient_SendMetricsByHTTP_TLS(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	var requests atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.TLS != nil && request.URL.Path == "/updates/" {
+			requests.Add(1)
+		}
+	}))
+	defer server.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.crt")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caPath, caPEM, 0600))
+
+	cfg := &config.Config{
+		RunAddr: strings.TrimPrefix(server.URL, "https://"),
+		TLSCA:   caPath,
+	}
+
+	httpClient := NewClient([]time.Duration{1 * time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond})
+	httpClient.SendMetricsByHTTP([]models.Metrics{}, cfg)
+
+	assert.Equal(t, int32(1), requests.Load())
+
+	// без CA сертификат тестового сервера не проходит проверку, метрики не отправляются.
+	// Клиент настраивается при первой отправке, поэтому для другой конфигурации нужен новый клиент
+	cfg.TLSCA = ""
+	cfg.TLSCert = filepath.Join(t.TempDir(), "not-exists.crt")
+	httpClient = NewClient([]time.Duration{1 * time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond})
+	assert.Error(t, httpClient.SendMetricsByHTTP([]models.Metrics{}, cfg))
+
+	assert.Equal(t, int32(1), requests.Load())
+}
@@ -0,0 +1,127 @@
+// collector - источники метрик агента
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+)
+
+/*
+Collector - источник метрик агента. Collect вызывается раз в PollInterval и возвращает текущие значения:
+для gauge - последнее значение, для counter - накопленное значение, приращения с последней отправки агент считает сам.
+Если часть метрик собрать не удалось, Collect возвращает собранные метрики вместе с ошибкой
+*/
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) ([]models.Metrics, error)
+}
+
+// Builtin - встроенные коллекторы агента, новый источник метрик достаточно добавить в этот список
+func Builtin() []Collector {
+	return []Collector{NewRuntime(), NewRandom(), NewSystem()}
+}
+
+// Registry - набор коллекторов, метрики собираются в порядке регистрации
+type Registry struct {
+	collectors []Collector
+}
+
+// NewRegistry - реестр из коллекторов collectors, имена коллекторов не должны повторяться
+func NewRegistry(collectors ...Collector) (*Registry, error) {
+	r := &Registry{}
+
+	for _, c := range collectors {
+		if err := r.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Register - добавляет коллектор в реестр
+func (r *Registry) Register(c Collector) error {
+	for _, registered := range r.collectors {
+		if registered.Name() == c.Name() {
+			return fmt.Errorf("collector %s is already registered", c.Name())
+		}
+	}
+
+	r.collectors = append(r.collectors, c)
+
+	return nil
+}
+
+// Names - имена коллекторов реестра
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		names = append(names, c.Name())
+	}
+
+	return names
+}
+
+/*
+Filter - реестр из включенных коллекторов: enabled - список включенных, пустой список включает все,
+disabled - список выключенных. Неизвестное имя коллектора - ошибка
+*/
+func (r *Registry) Filter(enabled, disabled []string) (*Registry, error) {
+	known := make(map[string]bool, len(r.collectors))
+	for _, c := range r.collectors {
+		known[c.Name()] = true
+	}
+
+	var unknown []string
+	for _, name := range append(append([]string{}, enabled...), disabled...) {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown collectors: %s, available: %s", strings.Join(unknown, ", "), strings.Join(r.Names(), ", "))
+	}
+
+	isEnabled := make(map[string]bool, len(enabled))
+	for _, name := range enabled {
+		isEnabled[name] = true
+	}
+
+	isDisabled := make(map[string]bool, len(disabled))
+	for _, name := range disabled {
+		isDisabled[name] = true
+	}
+
+	filtered := &Registry{}
+	for _, c := range r.collectors {
+		if (len(enabled) == 0 || isEnabled[c.Name()]) && !isDisabled[c.Name()] {
+			filtered.collectors = append(filtered.collectors, c)
+		}
+	}
+
+	return filtered, nil
+}
+
+// Collect - метрики всех коллекторов реестра. Ошибка коллектора логируется, собранные им метрики все равно возвращаются
+func (r *Registry) Collect(ctx context.Context) []models.Metrics {
+	var metrics []models.Metrics
+
+	for _, c := range r.collectors {
+		collected, err := c.Collect(ctx)
+
+		if err != nil {
+			logger.Log.Debug("Error while collecting metrics ", c.Name(), ": ", err)
+		}
+
+		metrics = append(metrics, collected...)
+	}
+
+	return metrics
+}
@@ -0,0 +1,164 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/shirou/gopsutil/v4/mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStatsMetrics(t *testing.T) {
+	var uintToFloatValue float64 = 10
+	gcFraction := 0.5
+
+	result := memStatsMetrics(&runtime.MemStats{TotalAlloc: 10, GCCPUFraction: gcFraction})
+
+	assert.Len(t, result, 27)
+	assert.Subset(t, result, []models.Metrics{
+		{MType: constants.MetricTypeGauge, ID: "TotalAlloc", Value: &uintToFloatValue},
+		{MType: constants.MetricTypeGauge, ID: "GCCPUFraction", Value: &gcFraction},
+	})
+}
+
+type MockMem struct {
+	mock.Mock
+}
+
+func (m *MockMem) VirtualMemory(_ context.Context) (*mem.VirtualMemoryStat, error) {
+	args := m.Called()
+	return args.Get(0).(*mem.VirtualMemoryStat), args.Error(1)
+}
+
+type MockCPU struct {
+	mock.Mock
+}
+
+func (c *MockCPU) Counts(_ context.Context, logical bool) (int, error) {
+	args := c.Called(logical)
+	return args.Int(0), args.Error(1)
+}
+
+func TestSystem_Collect(t *testing.T) {
+	mockMem := &MockMem{}
+	mockCPU := &MockCPU{}
+	mockMem.On("VirtualMemory").Return(&mem.VirtualMemoryStat{Total: 8000000, Free: 2000000}, nil)
+	mockCPU.On("Counts", false).Return(4, nil)
+
+	c := &System{virtualMemory: mockMem.VirtualMemory, cpuCounts: mockCPU.Counts}
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+
+	total, free, cpus := 8000000.0, 2000000.0, 4.0
+	assert.Equal(t, []models.Metrics{
+		{MType: constants.MetricTypeGauge, ID: "TotalMemory", Value: &total},
+		{MType: constants.MetricTypeGauge, ID: "FreeMemory", Value: &free},
+		{MType: constants.MetricTypeGauge, ID: "CPUutilization1", Value: &cpus},
+	}, metrics)
+
+	mockMem.AssertExpectations(t)
+	mockCPU.AssertExpectations(t)
+}
+
+func TestSystem_CollectPartial(t *testing.T) {
+	mockMem := &MockMem{}
+	mockCPU := &MockCPU{}
+	mockMem.On("VirtualMemory").Return((*mem.VirtualMemoryStat)(nil), errors.New("not supported"))
+	mockCPU.On("Counts", false).Return(4, nil)
+
+	c := &System{virtualMemory: mockMem.VirtualMemory, cpuCounts: mockCPU.Counts}
+	metrics, err := c.Collect(context.Background())
+
+	// ошибка памяти не мешает собрать число процессоров
+	assert.Error(t, err)
+	cpus := 4.0
+	assert.Equal(t, []models.Metrics{{MType: constants.MetricTypeGauge, ID: "CPUutilization1", Value: &cpus}}, metrics)
+}
+
+// stubCollector - коллектор, который возвращает заданные метрики и ошибку
+type stubCollector struct {
+	name    string
+	metrics []models.Metrics
+	err     error
+}
+
+func (c *stubCollector) Name() string {
+	return c.name
+}
+
+func (c *stubCollector) Collect(_ context.Context) ([]models.Metrics, error) {
+	return c.metrics, c.err
+}
+
+func gaugeStub(name, id string) *stubCollector {
+	return &stubCollector{name: name, metrics: []models.Metrics{gauge(id, 1)}}
+}
+
+func TestNewRegistry(t *testing.T) {
+	registry, err := NewRegistry(Builtin()...)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"runtime", "random", "system"}, registry.Names())
+
+	_, err = NewRegistry(gaugeStub("a", "A"), gaugeStub("a", "B"))
+	assert.Error(t, err)
+}
+
+func TestRegistry_Filter(t *testing.T) {
+	registry, err := NewRegistry(gaugeStub("a", "A"), gaugeStub("b", "B"), gaugeStub("c", "C"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		enabled  []string
+		disabled []string
+		expected []string
+		wantErr  bool
+	}{
+		{name: "all by default", expected: []string{"a", "b", "c"}},
+		{name: "enabled only", enabled: []string{"c", "a"}, expected: []string{"a", "c"}},
+		{name: "disabled", disabled: []string{"b"}, expected: []string{"a", "c"}},
+		{name: "disabled wins", enabled: []string{"a", "b"}, disabled: []string{"b"}, expected: []string{"a"}},
+		{name: "unknown enabled", enabled: []string{"d"}, wantErr: true},
+		{name: "unknown disabled", disabled: []string{"d"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filtered, err := registry.Filter(tt.enabled, tt.disabled)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, filtered.Names())
+		})
+	}
+}
+
+func TestRegistry_Collect(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	failing := gaugeStub("failing", "Partial")
+	failing.err = errors.New("collect failed")
+
+	registry, err := NewRegistry(gaugeStub("a", "A"), failing, gaugeStub("b", "B"))
+	require.NoError(t, err)
+
+	// ошибка коллектора не отменяет ни его метрики, ни метрики остальных коллекторов
+	var ids []string
+	for _, metric := range registry.Collect(context.Background()) {
+		ids = append(ids, metric.ID)
+	}
+
+	assert.Equal(t, []string{"A", "Partial", "B"}, ids)
+}
@@ -0,0 +1,90 @@
+package collector
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/models"
+)
+
+// Runtime - gauge из runtime.MemStats
+type Runtime struct{}
+
+func NewRuntime() *Runtime {
+	return &Runtime{}
+}
+
+func (c *Runtime) Name() string {
+	return "runtime"
+}
+
+func (c *Runtime) Collect(_ context.Context) ([]models.Metrics, error) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	return memStatsMetrics(&ms), nil
+}
+
+// memStatsMetrics - числовые поля runtime.MemStats в виде gauge
+func memStatsMetrics(ms *runtime.MemStats) []models.Metrics {
+	values := []struct {
+		id    string
+		value float64
+	}{
+		{"Alloc", float64(ms.Alloc)},
+		{"TotalAlloc", float64(ms.TotalAlloc)},
+		{"Sys", float64(ms.Sys)},
+		{"Lookups", float64(ms.Lookups)},
+		{"Mallocs", float64(ms.Mallocs)},
+		{"Frees", float64(ms.Frees)},
+		{"HeapAlloc", float64(ms.HeapAlloc)},
+		{"HeapSys", float64(ms.HeapSys)},
+		{"HeapIdle", float64(ms.HeapIdle)},
+		{"HeapInuse", float64(ms.HeapInuse)},
+		{"HeapReleased", float64(ms.HeapReleased)},
+		{"HeapObjects", float64(ms.HeapObjects)},
+		{"StackInuse", float64(ms.StackInuse)},
+		{"StackSys", float64(ms.StackSys)},
+		{"MSpanInuse", float64(ms.MSpanInuse)},
+		{"MSpanSys", float64(ms.MSpanSys)},
+		{"MCacheInuse", float64(ms.MCacheInuse)},
+		{"MCacheSys", float64(ms.MCacheSys)},
+		{"BuckHashSys", float64(ms.BuckHashSys)},
+		{"GCSys", float64(ms.GCSys)},
+		{"OtherSys", float64(ms.OtherSys)},
+		{"NextGC", float64(ms.NextGC)},
+		{"LastGC", float64(ms.LastGC)},
+		{"PauseTotalNs", float64(ms.PauseTotalNs)},
+		{"NumGC", float64(ms.NumGC)},
+		{"NumForcedGC", float64(ms.NumForcedGC)},
+		{"GCCPUFraction", ms.GCCPUFraction},
+	}
+
+	metrics := make([]models.Metrics, 0, len(values))
+	for _, v := range values {
+		metrics = append(metrics, gauge(v.id, v.value))
+	}
+
+	return metrics
+}
+
+// Random - gauge RandomValue со случайным значением
+type Random struct{}
+
+func NewRandom() *Random {
+	return &Random{}
+}
+
+func (c *Random) Name() string {
+	return "random"
+}
+
+func (c *Random) Collect(_ context.Context) ([]models.Metrics, error) {
+	return []models.Metrics{gauge("RandomValue", rand.Float64())}, nil
+}
+
+func gauge(id string, value float64) models.Metrics {
+	return models.Metrics{ID: id, MType: constants.MetricTypeGauge, Value: &value}
+}
@@ -0,0 +1,40 @@
+package collector
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/mem"
+)
+
+// System - gauge памяти и процессоров системы из gopsutil: TotalMemory, FreeMemory и CPUutilization1
+type System struct {
+	virtualMemory func(ctx context.Context) (*mem.VirtualMemoryStat, error)
+	cpuCounts     func(ctx context.Context, logical bool) (int, error)
+}
+
+func NewSystem() *System {
+	return &System{virtualMemory: mem.VirtualMemoryWithContext, cpuCounts: cpu.CountsWithContext}
+}
+
+func (c *System) Name() string {
+	return "system"
+}
+
+func (c *System) Collect(ctx context.Context) ([]models.Metrics, error) {
+	var metrics []models.Metrics
+
+	v, memErr := c.virtualMemory(ctx)
+	if memErr == nil {
+		metrics = append(metrics, gauge("TotalMemory", float64(v.Total)), gauge("FreeMemory", float64(v.Free)))
+	}
+
+	count, cpuErr := c.cpuCounts(ctx, false)
+	if cpuErr == nil {
+		metrics = append(metrics, gauge("CPUutilization1", float64(count)))
+	}
+
+	return metrics, errors.Join(memErr, cpuErr)
+}
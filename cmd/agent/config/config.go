@@ -3,11 +3,12 @@ package config
 import (
 	"encoding/json"
 	"flag"
-	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
 )
 
 type Config struct {
@@ -17,7 +18,80 @@ type Config struct {
 	SecretKey      string `json:"secret_key"`
 	RateLimit      int    `json:"rate_limit"`
 	CryptoKey      string `json:"crypto_key"`
+	CryptoKeyID    string `json:"crypto_key_id"`
+	StrictSecurity bool   `json:"strict_security"`
 	IsGRPC         bool   `json:"is_grpc"`
+	Token          string `json:"token"`
+	// Instance - имя экземпляра агента, отправляется в метке instance
+	Instance string `json:"instance"`
+	// Labels - метки, которые агент добавляет ко всем своим метрикам
+	Labels map[string]string `json:"labels"`
+	// NoHostLabel - не добавлять метку host с именем хоста. По умолчанию метка добавляется, если host не задан в Labels:
+	// без нее одноименные метрики разных агентов попадают в один ряд на сервере и перезаписывают друг друга
+	NoHostLabel bool `json:"no_host_label"`
+	// TLSCA - CA для проверки сертификата сервера, если задан - агент подключается по TLS
+	TLSCA string `json:"tls_ca"`
+	// TLSCert и TLSKey - клиентский сертификат агента для mTLS
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// QueueDir - каталог очереди неотправленных пачек, если задан - пачки, не доставленные на сервер, отправляются позже
+	QueueDir string `json:"queue_dir"`
+	// QueueMaxSize - максимальный размер очереди в байтах
+	QueueMaxSize int64 `json:"queue_max_size"`
+	// QueueMaxAge - максимальный возраст пачки в очереди в секундах
+	QueueMaxAge int `json:"queue_max_age"`
+	// Collectors - включенные коллекторы метрик, если список пуст - включены все
+	Collectors []string `json:"collectors"`
+	// DisableCollectors - выключенные коллекторы метрик
+	DisableCollectors []string `json:"disable_collectors"`
+}
+
+const (
+	DefaultPollInterval   = 2
+	DefaultReportInterval = 10
+	DefaultRateLimit      = 1
+	DefaultQueueMaxSize   = 10 << 20 // 10 МБ
+	DefaultQueueMaxAge    = 24 * 60 * 60
+)
+
+// IsTLS - подключаться к серверу по TLS, если задан CA или клиентский сертификат
+func (c *Config) IsTLS() bool {
+	return c.TLSCA != "" || c.TLSCert != ""
+}
+
+/*
+parseLabels - разбирает метки из строки вида "key1=value1,key2=value2".
+Пары без знака = или с именем метки не в формате Prometheus пропускаются: сервер отклонил бы пачку с такой меткой
+*/
+func parseLabels(value string) map[string]string {
+	labels := make(map[string]string)
+
+	for _, pair := range strings.Split(value, ",") {
+		key, labelValue, found := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+
+		if !found || !models.ValidLabelName(key) {
+			logger.Log.Debug("Wrong label: ", pair)
+			continue
+		}
+
+		labels[key] = strings.TrimSpace(labelValue)
+	}
+
+	return labels
+}
+
+// parseList - разбирает список из строки вида "value1,value2", пустые значения пропускаются
+func parseList(value string) []string {
+	var list []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
 }
 
 /*
@@ -27,7 +101,7 @@ type Config struct {
 
 func readConfigFile(configFile string, config *Config) {
 	fileConfig := Config{}
-	fmt.Println(configFile)
+	logger.Log.Debug("Reading config file: ", configFile)
 	jsonFileConfig, err := os.ReadFile(configFile)
 
 	if err != nil {
@@ -66,25 +140,114 @@ func readConfigFile(configFile string, config *Config) {
 		config.CryptoKey = fileConfig.CryptoKey
 	}
 
+	if config.CryptoKeyID == "" && fileConfig.CryptoKeyID != "" {
+		config.CryptoKeyID = fileConfig.CryptoKeyID
+	}
+
+	if !config.StrictSecurity && fileConfig.StrictSecurity {
+		config.StrictSecurity = fileConfig.StrictSecurity
+	}
+
 	if !config.IsGRPC && fileConfig.IsGRPC {
 		config.IsGRPC = fileConfig.IsGRPC
 	}
+
+	if config.Token == "" && fileConfig.Token != "" {
+		config.Token = fileConfig.Token
+	}
+
+	if config.Instance == "" && fileConfig.Instance != "" {
+		config.Instance = fileConfig.Instance
+	}
+
+	if !config.NoHostLabel && fileConfig.NoHostLabel {
+		config.NoHostLabel = fileConfig.NoHostLabel
+	}
+
+	if config.TLSCA == "" && fileConfig.TLSCA != "" {
+		config.TLSCA = fileConfig.TLSCA
+	}
+
+	if config.TLSCert == "" && fileConfig.TLSCert != "" {
+		config.TLSCert = fileConfig.TLSCert
+	}
+
+	if config.TLSKey == "" && fileConfig.TLSKey != "" {
+		config.TLSKey = fileConfig.TLSKey
+	}
+
+	if config.QueueDir == "" && fileConfig.QueueDir != "" {
+		config.QueueDir = fileConfig.QueueDir
+	}
+
+	if config.QueueMaxSize == 0 && fileConfig.QueueMaxSize != 0 {
+		config.QueueMaxSize = fileConfig.QueueMaxSize
+	}
+
+	if config.QueueMaxAge == 0 && fileConfig.QueueMaxAge != 0 {
+		config.QueueMaxAge = fileConfig.QueueMaxAge
+	}
+
+	if len(config.Collectors) == 0 && len(fileConfig.Collectors) != 0 {
+		config.Collectors = fileConfig.Collectors
+	}
+
+	if len(config.DisableCollectors) == 0 && len(fileConfig.DisableCollectors) != 0 {
+		config.DisableCollectors = fileConfig.DisableCollectors
+	}
+
+	// метки из файла дополняют метки из флага, но не перезаписывают их
+	for key, value := range fileConfig.Labels {
+		if !models.ValidLabelName(key) {
+			logger.Log.Debug("Wrong label: ", key)
+			continue
+		}
+
+		if _, ok := config.Labels[key]; !ok {
+			config.Labels[key] = value
+		}
+	}
 }
 
 func ParseConfig() Config {
 	config := Config{}
 	var configFile string
+	var labels string
+	var collectors string
+	var disableCollectors string
 
 	flag.StringVar(&config.RunAddr, "a", "", "address of the server")
 	flag.IntVar(&config.ReportInterval, "r", 0, "частота отправки метрик на сервер")
 	flag.IntVar(&config.PollInterval, "p", 0, "частота опроса метрик из пакета runtime")
 	flag.StringVar(&config.SecretKey, "k", "", "ключ для кодирования запроса")
 	flag.StringVar(&config.CryptoKey, "crypto-key", "", "путь до файла с публичным ключом")
+	flag.StringVar(&config.CryptoKeyID, "crypto-key-id", "", "идентификатор ключа сервера, которым шифруются метрики")
+	flag.BoolVar(&config.StrictSecurity, "strict-security", false, "не отправлять метрики открытым текстом, если их не удалось зашифровать")
 	flag.IntVar(&config.RateLimit, "l", 0, "количество одновременно исходящих запросов")
 	flag.BoolVar(&config.IsGRPC, "grpc", false, "отправка метрик через gRPC")
+	flag.StringVar(&config.Token, "token", "", "токен агента для аутентификации на сервере")
+	flag.StringVar(&config.Instance, "instance", "", "имя экземпляра агента")
+	flag.StringVar(&labels, "labels", "", "метки метрик в формате key1=value1,key2=value2")
+	flag.BoolVar(&config.NoHostLabel, "no-host-label", false, "не добавлять к метрикам метку host с именем хоста")
+	flag.StringVar(&config.TLSCA, "tls-ca", "", "путь до CA для проверки сертификата сервера")
+	flag.StringVar(&config.TLSCert, "tls-cert", "", "путь до клиентского сертификата агента (mTLS)")
+	flag.StringVar(&config.TLSKey, "tls-key", "", "путь до приватного ключа клиентского сертификата агента")
+	flag.StringVar(&config.QueueDir, "queue-dir", "", "каталог очереди неотправленных метрик")
+	flag.Int64Var(&config.QueueMaxSize, "queue-max-size", 0, "максимальный размер очереди неотправленных метрик в байтах")
+	flag.IntVar(&config.QueueMaxAge, "queue-max-age", 0, "максимальный возраст неотправленных метрик в очереди в секундах")
+	flag.StringVar(&collectors, "collectors", "", "включенные коллекторы метрик через запятую, по умолчанию все")
+	flag.StringVar(&disableCollectors, "disable-collectors", "", "выключенные коллекторы метрик через запятую")
 	flag.StringVar(&configFile, "c", "cmd/agent/config/config.json", "имя файла конфигурации")
 	flag.Parse()
 
+	config.Labels = make(map[string]string)
+	if labels != "" {
+		config.Labels = parseLabels(labels)
+	}
+
+	config.Collectors = parseList(collectors)
+	config.DisableCollectors = parseList(disableCollectors)
+
 	readConfigFile(configFile, &config)
 
 	if runAddr := os.Getenv("ADDRESS"); runAddr != "" {
@@ -127,5 +290,109 @@ func ParseConfig() Config {
 		config.CryptoKey = cryptoKey
 	}
 
+	if cryptoKeyID := os.Getenv("CRYPTO_KEY_ID"); cryptoKeyID != "" {
+		config.CryptoKeyID = cryptoKeyID
+	}
+
+	if strictSecurity := os.Getenv("STRICT_SECURITY"); strictSecurity != "" {
+		value, err := strconv.ParseBool(strictSecurity)
+
+		if err == nil {
+			config.StrictSecurity = value
+		}
+	}
+
+	if token := os.Getenv("TOKEN"); token != "" {
+		config.Token = token
+	}
+
+	if instance := os.Getenv("INSTANCE"); instance != "" {
+		config.Instance = instance
+	}
+
+	if labelsEnv := os.Getenv("LABELS"); labelsEnv != "" {
+		config.Labels = parseLabels(labelsEnv)
+	}
+
+	if noHostLabel := os.Getenv("NO_HOST_LABEL"); noHostLabel != "" {
+		value, err := strconv.ParseBool(noHostLabel)
+
+		if err == nil {
+			config.NoHostLabel = value
+		}
+	}
+
+	if tlsCA := os.Getenv("TLS_CA"); tlsCA != "" {
+		config.TLSCA = tlsCA
+	}
+
+	if tlsCert := os.Getenv("TLS_CERT"); tlsCert != "" {
+		config.TLSCert = tlsCert
+	}
+
+	if tlsKey := os.Getenv("TLS_KEY"); tlsKey != "" {
+		config.TLSKey = tlsKey
+	}
+
+	if queueDir := os.Getenv("QUEUE_DIR"); queueDir != "" {
+		config.QueueDir = queueDir
+	}
+
+	if queueMaxSize := os.Getenv("QUEUE_MAX_SIZE"); queueMaxSize != "" {
+		value, err := strconv.ParseInt(queueMaxSize, 10, 64)
+
+		if err == nil {
+			config.QueueMaxSize = value
+		}
+	}
+
+	if queueMaxAge := os.Getenv("QUEUE_MAX_AGE"); queueMaxAge != "" {
+		value, err := strconv.Atoi(queueMaxAge)
+
+		if err == nil {
+			config.QueueMaxAge = value
+		}
+	}
+
+	if collectorsEnv := os.Getenv("COLLECTORS"); collectorsEnv != "" {
+		config.Collectors = parseList(collectorsEnv)
+	}
+
+	if disableCollectorsEnv := os.Getenv("DISABLE_COLLECTORS"); disableCollectorsEnv != "" {
+		config.DisableCollectors = parseList(disableCollectorsEnv)
+	}
+
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+
+	if config.ReportInterval <= 0 {
+		config.ReportInterval = DefaultReportInterval
+	}
+
+	if config.RateLimit <= 0 {
+		config.RateLimit = DefaultRateLimit
+	}
+
+	if config.QueueMaxSize == 0 {
+		config.QueueMaxSize = DefaultQueueMaxSize
+	}
+
+	if config.QueueMaxAge == 0 {
+		config.QueueMaxAge = DefaultQueueMaxAge
+	}
+
+	if config.Instance != "" {
+		config.Labels["instance"] = config.Instance
+	}
+
+	if _, ok := config.Labels["host"]; !config.NoHostLabel && !ok {
+		if host, err := os.Hostname(); err == nil {
+			config.Labels["host"] = host
+		} else {
+			logger.Log.Debug("Error while get hostname: ", err)
+		}
+	}
+
 	return config
 }
@@ -193,3 +193,135 @@ func TestParseConfig_File(t *testing.T) {
 	assert.Equal(t, "crypto", cfg.CryptoKey)
 	assert.Equal(t, true, cfg.IsGRPC)
 }
+
+func TestParseConfig_Labels(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	os.Args = []string{
+		"cmd/agent",
+		"-labels", "dc=eu-1, team=core,broken,bad-name=x",
+		"-instance", "agent-1",
+		"-c", "",
+	}
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	cfg := ParseConfig()
+
+	host, err := os.Hostname()
+	require.NoError(t, err)
+
+	assert.Equal(t, "agent-1", cfg.Instance)
+	assert.Equal(t, map[string]string{"dc": "eu-1", "team": "core", "instance": "agent-1", "host": host}, cfg.Labels)
+
+	os.Args = []string{
+		"cmd/agent",
+		"-labels", "dc=eu-1",
+		"-c", "",
+	}
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	oldLabels := os.Getenv("LABELS")
+	err = os.Setenv("LABELS", "host=custom")
+	defer os.Setenv("LABELS", oldLabels)
+	require.NoError(t, err)
+
+	cfg = ParseConfig()
+
+	assert.Equal(t, map[string]string{"host": "custom"}, cfg.Labels)
+
+	// по умолчанию метрики агента отличаются меткой host
+	os.Args = []string{"cmd/agent", "-c", ""}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	err = os.Setenv("LABELS", "")
+	require.NoError(t, err)
+
+	cfg = ParseConfig()
+
+	assert.Equal(t, map[string]string{"host": host}, cfg.Labels)
+
+	// с -no-host-label агент отправляет метрики без меток
+	os.Args = []string{"cmd/agent", "-no-host-label", "-c", ""}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	cfg = ParseConfig()
+
+	assert.True(t, cfg.NoHostLabel)
+	assert.Empty(t, cfg.Labels)
+}
+
+func TestParseConfig_Queue(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	os.Args = []string{
+		"cmd/agent",
+		"-queue-dir", "/var/lib/agent/queue",
+		"-queue-max-age", "600",
+		"-c", "",
+	}
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	cfg := ParseConfig()
+
+	assert.Equal(t, "/var/lib/agent/queue", cfg.QueueDir)
+	assert.Equal(t, 600, cfg.QueueMaxAge)
+	assert.Equal(t, int64(DefaultQueueMaxSize), cfg.QueueMaxSize)
+
+	os.Args = []string{"cmd/agent", "-c", ""}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	t.Setenv("QUEUE_MAX_SIZE", "1024")
+	cfg = ParseConfig()
+
+	assert.Equal(t, "", cfg.QueueDir)
+	assert.Equal(t, int64(1024), cfg.QueueMaxSize)
+	assert.Equal(t, DefaultQueueMaxAge, cfg.QueueMaxAge)
+}
+
+func TestParseConfig_Collectors(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	tmpFile, err := os.CreateTemp("", "config-*.json")
+	require.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write([]byte(`{"collectors": ["runtime"], "disable_collectors": ["random"]}`))
+	require.NoError(t, err)
+
+	err = tmpFile.Close()
+	require.NoError(t, err)
+
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	// флаг имеет приоритет над файлом
+	os.Args = []string{
+		"cmd/agent",
+		"-collectors", "runtime, system,",
+		"-c", tmpFile.Name(),
+	}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	cfg := ParseConfig()
+
+	assert.Equal(t, []string{"runtime", "system"}, cfg.Collectors)
+	assert.Equal(t, []string{"random"}, cfg.DisableCollectors)
+
+	os.Args = []string{"cmd/agent", "-c", ""}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	t.Setenv("DISABLE_COLLECTORS", "system")
+	cfg = ParseConfig()
+
+	assert.Empty(t, cfg.Collectors)
+	assert.Equal(t, []string{"system"}, cfg.DisableCollectors)
+}
@@ -1,177 +1,287 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
-	mathRand "math/rand"
-	"os"
 	"os/signal"
-	"reflect"
-	"runtime"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/dglazkoff/go-metrics/cmd/agent/client"
+	"github.com/dglazkoff/go-metrics/cmd/agent/collector"
 	"github.com/dglazkoff/go-metrics/cmd/agent/config"
+	"github.com/dglazkoff/go-metrics/cmd/agent/queue"
 	constants "github.com/dglazkoff/go-metrics/internal/const"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/dglazkoff/go-metrics/internal/models"
-	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
-	"github.com/shirou/gopsutil/v4/cpu"
-	"github.com/shirou/gopsutil/v4/mem"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
+// shutdownTimeout - сколько ждем завершения отправок при остановке агента
+const shutdownTimeout = 10 * time.Second
+
 var (
 	BuildVersion = "N/A"
 	BuildDate    = "N/A"
 	BuildCommit  = "N/A"
 )
 
-type GaugeMetrics struct {
-	runtime.MemStats
-	RandomValue     float64
-	TotalMemory     float64
-	FreeMemory      float64
-	CPUutilization1 float64
+/*
+snapshot - последние значения метрик, собранные коллекторами, и счетчик опросов PollCount.
+Метрики хранятся в порядке первого появления, метрика, которую коллектор не смог собрать, сохраняет прежнее значение
+*/
+type snapshot struct {
+	mu        sync.Mutex
+	keys      []string
+	metrics   map[string]models.Metrics
+	pollCount int64
 }
 
-type CounterMetrics struct {
-	PollCount int64
+func newSnapshot() *snapshot {
+	return &snapshot{metrics: make(map[string]models.Metrics)}
 }
 
-func updateMetricsWorkerPool(gm *GaugeMetrics, cm *CounterMetrics, cfg *config.Config) {
-	workersChan := make(chan struct{}, cfg.RateLimit)
-	var wg sync.WaitGroup
+// poll - опрашивает коллекторы реестра и обновляет значения метрик
+func (s *snapshot) poll(ctx context.Context, registry *collector.Registry) {
+	// коллекторы опрашиваются без блокировки, чтобы не задерживать отправку
+	metrics := registry.Collect(ctx)
 
-	wg.Add(cfg.RateLimit)
-	go func() {
-		writeMetricsInterval := time.Duration(cfg.ReportInterval) * time.Second
-		defer close(workersChan)
-		for {
-			time.Sleep(writeMetricsInterval)
-			workersChan <- struct{}{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, metric := range metrics {
+		key := metric.MType + ":" + metric.FullName()
+
+		if _, ok := s.metrics[key]; !ok {
+			s.keys = append(s.keys, key)
 		}
-	}()
 
-	for i := 0; i < cfg.RateLimit; i++ {
-		go func() {
-			for range workersChan {
-				updateMetrics(gm, cm, cfg)
-			}
-			wg.Done()
-		}()
+		s.metrics[key] = metric
 	}
 
-	wg.Wait()
-	fmt.Println(2)
+	s.pollCount += 1
 }
 
-func parseMetrics(gm *GaugeMetrics, cm *CounterMetrics) []models.Metrics {
-	var metrics []models.Metrics
-	valuesGm := reflect.ValueOf(*gm)
-	typesGm := valuesGm.Type()
-	for i := 0; i < valuesGm.NumField(); i++ {
-		if typesGm.Field(i).Name == "MemStats" {
-			continue
-		}
-		value := valuesGm.Field(i).Float()
-		metrics = append(metrics, models.Metrics{MType: constants.MetricTypeGauge, ID: typesGm.Field(i).Name, Value: &value})
-	}
+// get - копия текущих метрик вместе с PollCount
+func (s *snapshot) get() []models.Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	valuesGmMemStats := reflect.ValueOf((*gm).MemStats)
-	typesGmMemStats := valuesGmMemStats.Type()
+	metrics := make([]models.Metrics, 0, len(s.keys)+1)
+	for _, key := range s.keys {
+		metrics = append(metrics, s.metrics[key])
+	}
 
-	for i := 0; i < valuesGmMemStats.NumField(); i++ {
-		field := valuesGmMemStats.Field(i)
-		var value float64
+	pollCount := s.pollCount
+	metrics = append(metrics, models.Metrics{MType: constants.MetricTypeCounter, ID: "PollCount", Delta: &pollCount})
 
-		switch field.Kind() {
-		case reflect.Float32, reflect.Float64:
-			value = field.Float()
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			value = float64(field.Uint())
-		default:
-			continue
-		}
+	return metrics
+}
 
-		metrics = append(metrics, models.Metrics{MType: constants.MetricTypeGauge, ID: typesGmMemStats.Field(i).Name, Value: &value})
+// withLabels - метки метрики, дополненные метками агента. При совпадении ключа метка агента важнее метки коллектора
+func withLabels(metricLabels, agentLabels map[string]string) map[string]string {
+	if len(metricLabels) == 0 {
+		return agentLabels
 	}
 
-	valuesCm := reflect.ValueOf(*cm)
-	typesCm := valuesCm.Type()
-	for i := 0; i < valuesCm.NumField(); i++ {
-		delta := valuesCm.Field(i).Int()
-		metrics = append(metrics, models.Metrics{MType: constants.MetricTypeCounter, ID: typesCm.Field(i).Name, Delta: &delta})
+	labels := models.CopyLabels(metricLabels)
+	for key, value := range agentLabels {
+		labels[key] = value
 	}
 
-	return metrics
+	return labels
 }
 
-func updateMetrics(gm *GaugeMetrics, cm *CounterMetrics, cfg *config.Config) {
-	metrics := parseMetrics(gm, cm)
+/*
+counterTracker - запоминает, какая часть значения каждого counter уже передана на сервер.
+Сервер прибавляет полученную delta к counter, поэтому агент отправляет только приращение с последней доставки
+*/
+type counterTracker struct {
+	mu       sync.Mutex
+	reported map[string]int64
+}
 
-	if cfg.IsGRPC {
-		conn, err := grpc.NewClient(cfg.RunAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-		if err != nil {
-			log.Fatal(err)
+func newCounterTracker() *counterTracker {
+	return &counterTracker{reported: make(map[string]int64)}
+}
+
+/*
+take - заменяет значения counter в metrics на приращения с последней отправки и возвращает их.
+Приращения сразу считаются переданными, чтобы параллельный воркер не отправил их еще раз,
+если пачку не удалось доставить - их нужно вернуть через release.
+Значение из более старого снимка, чем уже переданное, дает нулевое приращение: переданное значение только растет
+*/
+func (t *counterTracker) take(metrics []models.Metrics) map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	taken := make(map[string]int64)
+
+	for i, metric := range metrics {
+		if metric.MType != constants.MetricTypeCounter || metric.Delta == nil {
+			continue
 		}
-		defer conn.Close()
-		mc := pb.NewMetricsClient(conn)
 
-		grpcClient := client.NewMetricsClient(mc)
-		grpcClient.SendMetricsByGRPC(metrics)
-		return
+		key := metric.FullName()
+		delta := *metric.Delta - t.reported[key]
+		if delta < 0 {
+			delta = 0
+		}
+
+		t.reported[key] += delta
+		taken[key] += delta
+		metrics[i].Delta = &delta
 	}
 
-	httpClient := client.NewClient([]time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second})
-	httpClient.SendMetricsByHTTP(metrics, cfg)
+	return taken
 }
 
-func writeMetricsOnce(
-	gm *GaugeMetrics,
-	cm *CounterMetrics,
-	memStatProvider func() (*mem.VirtualMemoryStat, error),
-	cpuCountProvider func(bool) (int, error),
-) {
-	var memStats runtime.MemStats
-	v, err := memStatProvider()
+// release - возвращает приращения недоставленной пачки, они будут отправлены со следующей пачкой
+func (t *counterTracker) release(taken map[string]int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	if err != nil {
-		logger.Log.Debug("Error while get memory stats: ", err)
-	} else {
-		gm.TotalMemory = float64(v.Total)
-		gm.FreeMemory = float64(v.Free)
+	for key, delta := range taken {
+		t.reported[key] -= delta
 	}
+}
 
-	c, err := cpuCountProvider(false)
+/*
+updateMetricsWorkerPool - раз в ReportInterval отдает тик одному из RateLimit воркеров, воркер отправляет метрики с sendCtx.
+После отмены ctx новые тики не создаются, функция возвращается, когда воркеры закончат текущие отправки.
+Отправки прерываются только отменой sendCtx
+*/
+func updateMetricsWorkerPool(ctx context.Context, sendCtx context.Context, s *snapshot, r *reporter) {
+	cfg := r.cfg
+	workersChan := make(chan struct{}, cfg.RateLimit)
+	var wg sync.WaitGroup
 
-	if err != nil {
-		logger.Log.Debug("Error while get cpu counts: ", err)
-	} else {
-		gm.CPUutilization1 = float64(c)
+	wg.Add(cfg.RateLimit)
+	for i := 0; i < cfg.RateLimit; i++ {
+		go func() {
+			defer wg.Done()
+
+			for range workersChan {
+				// тики, не разобранные до остановки, не отправляются - последнюю пачку отправит runAgent
+				if ctx.Err() != nil {
+					continue
+				}
+
+				updateMetrics(sendCtx, s, r)
+			}
+		}()
 	}
 
-	runtime.ReadMemStats(&memStats)
-	gm.MemStats = memStats
-	gm.RandomValue = mathRand.Float64()
+	ticker := time.NewTicker(time.Duration(cfg.ReportInterval) * time.Second)
+	defer ticker.Stop()
 
-	cm.PollCount += 1
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+			select {
+			case workersChan <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	close(workersChan)
+	wg.Wait()
 }
 
-func writeMetrics(gm *GaugeMetrics, cm *CounterMetrics, cfg *config.Config) {
-	writeMetricsInterval := time.Duration(cfg.PollInterval) * time.Second
+/*
+updateMetrics - отправляет текущие метрики на сервер, counter - приращениями с последней доставки.
+Если задана очередь q, пачка сначала записывается в нее и отправляется вместе с пачками, не доставленными раньше, по порядку.
+Пока сервер недоступен, пачки остаются в очереди и отправляются при следующем вызове.
+Без очереди приращения недоставленной пачки отправляются со следующей пачкой
+*/
+func updateMetrics(ctx context.Context, s *snapshot, r *reporter) {
+	metrics := s.get()
+
+	send := func(batch queue.Batch) error {
+		return r.transport.send(ctx, batch)
+	}
 
-	ticker := time.NewTicker(writeMetricsInterval)
+	for i := range metrics {
+		metrics[i].Labels = withLabels(metrics[i].Labels, r.cfg.Labels)
+	}
+
+	taken := r.counters.take(metrics)
+	batchID := client.NewBatchID()
+	q := r.queue
+
+	if q == nil {
+		if err := send(queue.Batch{ID: batchID, Metrics: metrics}); err != nil {
+			logger.Log.Debug("Metrics are not delivered, increments are kept: ", err)
+			r.counters.release(taken)
+		}
+		return
+	}
+
+	// записанная в очередь пачка уже не потеряется, ее приращения считаются переданными
+	if err := q.Push(batchID, metrics); err != nil {
+		logger.Log.Debug("Error while queueing metrics: ", err)
+
+		if err = send(queue.Batch{ID: batchID, Metrics: metrics}); err != nil {
+			r.counters.release(taken)
+		}
+		return
+	}
+
+	err := q.Drain(send)
+
+	if err != nil {
+		logger.Log.Debug("Metrics are not delivered, queued batches: ", q.Len(), " error: ", err)
+	}
+}
+
+// writeMetrics - опрашивает коллекторы раз в interval, пока не отменен ctx
+func writeMetrics(ctx context.Context, s *snapshot, registry *collector.Registry, interval time.Duration) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		writeMetricsOnce(gm, cm, mem.VirtualMemory, cpu.Counts)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.poll(ctx, registry)
+		}
 	}
 }
 
+/*
+runAgent - опрашивает и отправляет метрики, пока не отменен ctx. При остановке прекращает опрос,
+дожидается текущих отправок и отправляет последнюю пачку. На отправки после остановки отводится shutdownTimeout,
+затем они прерываются: с очередью недоставленные пачки остаются на диске и будут отправлены после перезапуска
+*/
+func runAgent(ctx context.Context, r *reporter, registry *collector.Registry, shutdownTimeout time.Duration) {
+	sendCtx, cancelSend := context.WithCancel(context.Background())
+	defer cancelSend()
+
+	stop := context.AfterFunc(ctx, func() {
+		logger.Log.Debug("Agent is stopping")
+		time.AfterFunc(shutdownTimeout, cancelSend)
+	})
+	defer stop()
+
+	s := newSnapshot()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		writeMetrics(ctx, s, registry, time.Duration(r.cfg.PollInterval)*time.Second)
+	}()
+
+	updateMetricsWorkerPool(ctx, sendCtx, s, r)
+	wg.Wait()
+
+	updateMetrics(sendCtx, s, r)
+	logger.Log.Debug("Agent is stopped")
+}
+
 func runApp() error {
 	err := logger.Initialize()
 	if err != nil {
@@ -184,23 +294,37 @@ func runApp() error {
 	fmt.Printf("Build date: %s\n", BuildDate)
 	fmt.Printf("Build commit: %s\n", BuildCommit)
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	builtin, err := collector.NewRegistry(collector.Builtin()...)
+	if err != nil {
+		return err
+	}
+
+	registry, err := builtin.Filter(cfg.Collectors, cfg.DisableCollectors)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	defer stop()
+
+	var q *queue.Queue
+	if cfg.QueueDir != "" {
+		q, err = queue.Open(cfg.QueueDir, cfg.QueueMaxSize, time.Duration(cfg.QueueMaxAge)*time.Second)
+		if err != nil {
+			return err
+		}
+	}
 
-	gm := GaugeMetrics{}
-	cm := CounterMetrics{}
+	t, err := newTransport(&cfg)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
 
-	go func() {
-		sig := <-sigs
-		logger.Log.Debug("Signal: ", sig)
-		updateMetrics(&gm, &cm, &cfg)
-		os.Exit(0)
-	}()
+	r := &reporter{cfg: &cfg, transport: t, queue: q, counters: newCounterTracker()}
 
-	go writeMetrics(&gm, &cm, &cfg)
+	runAgent(ctx, r, registry, shutdownTimeout)
 
-	updateMetricsWorkerPool(&gm, &cm, &cfg)
-	fmt.Println("deadd")
 	return nil
 }
 
@@ -0,0 +1,346 @@
+// queue - очередь неотправленных пачек метрик агента на диске
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+)
+
+const batchExt = ".json"
+
+// Batch - пачка метрик в очереди. ID не меняется при повторных отправках, по нему сервер не применяет пачку дважды
+type Batch struct {
+	ID        string           `json:"id"`
+	CreatedAt time.Time        `json:"created_at"`
+	Metrics   []models.Metrics `json:"metrics"`
+}
+
+// entry - пачка, записанная в файл очереди
+type entry struct {
+	seq       uint64
+	size      int64
+	createdAt time.Time
+}
+
+/*
+Queue - очередь пачек метрик, каждая пачка хранится в отдельном файле каталога dir, имя файла - порядковый номер пачки.
+Пачка записывается на диск до отправки и удаляется только после доставки, поэтому переживает перезапуск агента.
+Размер очереди ограничен maxSize байт, возраст пачек - maxAge, нулевое значение снимает ограничение. Вытесняемые пачки не теряют counter:
+их delta прибавляются к новой пачке, а gauge отбрасываются, потому что новая пачка содержит более свежие значения
+*/
+type Queue struct {
+	mu      sync.Mutex
+	sending sync.Mutex
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	entries []entry
+	size    int64
+	nextSeq uint64
+	// inflight - номер отправляемой пачки, она не вытесняется, иначе ее counter могли бы учесться дважды
+	inflight uint64
+	now      func() time.Time
+}
+
+// Open - открывает очередь в каталоге dir, пачки, оставшиеся с прошлого запуска агента, отправляются первыми
+func Open(dir string, maxSize int64, maxAge time.Duration) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Queue{dir: dir, maxSize: maxSize, maxAge: maxAge, nextSeq: 1, now: time.Now}
+
+	for _, file := range files {
+		name := file.Name()
+
+		if file.IsDir() || !strings.HasSuffix(name, batchExt) {
+			// недописанный файл пачки, сама пачка в очередь не попала
+			if strings.HasSuffix(name, ".tmp") {
+				_ = os.Remove(filepath.Join(dir, name))
+			}
+
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, batchExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		batch, size, err := q.read(seq)
+		if err != nil {
+			logger.Log.Debug("Error while reading queued batch, batch is dropped: ", err)
+			_ = os.Remove(q.path(seq))
+			continue
+		}
+
+		q.entries = append(q.entries, entry{seq: seq, size: size, createdAt: batch.CreatedAt})
+		q.size += size
+	}
+
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].seq < q.entries[j].seq })
+
+	if len(q.entries) > 0 {
+		q.nextSeq = q.entries[len(q.entries)-1].seq + 1
+	}
+
+	return q, nil
+}
+
+// Len - количество пачек в очереди
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.entries)
+}
+
+// Size - суммарный размер файлов очереди в байтах
+func (q *Queue) Size() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.size
+}
+
+/*
+Push - записывает метрики в конец очереди пачкой id.
+Перед записью из очереди вытесняются пачки старше maxAge и самые старые пачки, пока новая не поместится в maxSize
+*/
+func (q *Queue) Push(id string, metrics []models.Metrics) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	batch := Batch{ID: id, CreatedAt: now, Metrics: copyMetrics(metrics)}
+
+	for {
+		oldest := q.oldest()
+		if oldest < 0 || q.maxAge <= 0 || now.Sub(q.entries[oldest].createdAt) <= q.maxAge {
+			break
+		}
+
+		q.evict(oldest, &batch)
+	}
+
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	for q.maxSize > 0 && q.size+int64(len(data)) > q.maxSize {
+		oldest := q.oldest()
+		if oldest < 0 {
+			break
+		}
+
+		q.evict(oldest, &batch)
+
+		if data, err = json.Marshal(batch); err != nil {
+			return err
+		}
+	}
+
+	seq := q.nextSeq
+	if err = q.write(seq, data); err != nil {
+		return err
+	}
+
+	q.nextSeq++
+	q.entries = append(q.entries, entry{seq: seq, size: int64(len(data)), createdAt: now})
+	q.size += int64(len(data))
+
+	return nil
+}
+
+/*
+Drain - отправляет пачки по порядку функцией send и удаляет доставленные из очереди.
+Останавливается на первой ошибке send, недоставленная пачка и следующие за ней остаются в очереди.
+Очередь отправляет только одна горутина: если отправка уже идет, Drain сразу возвращается
+*/
+func (q *Queue) Drain(send func(batch Batch) error) error {
+	if !q.sending.TryLock() {
+		return nil
+	}
+	defer q.sending.Unlock()
+
+	for {
+		q.mu.Lock()
+		if len(q.entries) == 0 {
+			q.mu.Unlock()
+			return nil
+		}
+
+		seq := q.entries[0].seq
+		q.inflight = seq
+		q.mu.Unlock()
+
+		batch, _, err := q.read(seq)
+		if err == nil {
+			err = send(batch)
+
+			if err != nil {
+				q.mu.Lock()
+				q.inflight = 0
+				q.mu.Unlock()
+
+				return err
+			}
+		} else {
+			logger.Log.Debug("Error while reading queued batch, batch is dropped: ", err)
+		}
+
+		q.mu.Lock()
+		q.inflight = 0
+		q.remove(0)
+		q.mu.Unlock()
+	}
+}
+
+// oldest - индекс самой старой пачки, которую можно вытеснить, -1 если таких нет
+func (q *Queue) oldest() int {
+	for i, e := range q.entries {
+		if e.seq != q.inflight {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// evict - удаляет пачку с индексом i из очереди, ее counter прибавляются к batch
+func (q *Queue) evict(i int, batch *Batch) {
+	evicted, _, err := q.read(q.entries[i].seq)
+
+	if err != nil {
+		logger.Log.Debug("Error while reading evicted batch: ", err)
+	} else {
+		batch.Metrics = mergeCounters(batch.Metrics, evicted.Metrics)
+	}
+
+	logger.Log.Debug("Queued batch is evicted: ", q.entries[i].seq)
+	q.remove(i)
+}
+
+// remove - удаляет пачку с индексом i из очереди и ее файл
+func (q *Queue) remove(i int) {
+	e := q.entries[i]
+
+	if err := os.Remove(q.path(e.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Log.Debug("Error while removing queued batch: ", err)
+	}
+
+	q.size -= e.size
+	q.entries = append(q.entries[:i], q.entries[i+1:]...)
+}
+
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, batchExt))
+}
+
+func (q *Queue) read(seq uint64) (Batch, int64, error) {
+	var batch Batch
+
+	data, err := os.ReadFile(q.path(seq))
+	if err != nil {
+		return batch, 0, err
+	}
+
+	err = json.Unmarshal(data, &batch)
+
+	return batch, int64(len(data)), err
+}
+
+// write - записывает пачку во временный файл и переименовывает его, чтобы в очереди не оказалось недописанной пачки
+func (q *Queue) write(seq uint64, data []byte) error {
+	tmp := q.path(seq) + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, q.path(seq))
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+
+	return err
+}
+
+/*
+mergeCounters - прибавляет delta counter из evicted к одноименным counter с теми же метками в metrics,
+counter, которых нет в metrics, добавляются в конец
+*/
+func mergeCounters(metrics []models.Metrics, evicted []models.Metrics) []models.Metrics {
+	index := make(map[string]int, len(metrics))
+	for i, metric := range metrics {
+		if metric.MType == constants.MetricTypeCounter && metric.Delta != nil {
+			index[metric.FullName()] = i
+		}
+	}
+
+	for _, metric := range evicted {
+		if metric.MType != constants.MetricTypeCounter || metric.Delta == nil {
+			continue
+		}
+
+		key := metric.FullName()
+
+		if i, ok := index[key]; ok {
+			delta := *metrics[i].Delta + *metric.Delta
+			metrics[i].Delta = &delta
+			continue
+		}
+
+		delta := *metric.Delta
+		index[key] = len(metrics)
+		metrics = append(metrics, models.Metrics{ID: metric.ID, MType: metric.MType, Delta: &delta, Labels: models.CopyLabels(metric.Labels)})
+	}
+
+	return metrics
+}
+
+// copyMetrics - копия метрик, чтобы слияние counter не меняло значения вызывающего
+func copyMetrics(metrics []models.Metrics) []models.Metrics {
+	result := make([]models.Metrics, len(metrics))
+
+	for i, metric := range metrics {
+		result[i] = metric
+
+		if metric.Delta != nil {
+			delta := *metric.Delta
+			result[i].Delta = &delta
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,200 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func counter(id string, delta int64) models.Metrics {
+	return models.Metrics{ID: id, MType: constants.MetricTypeCounter, Delta: &delta}
+}
+
+func gauge(id string, value float64) models.Metrics {
+	return models.Metrics{ID: id, MType: constants.MetricTypeGauge, Value: &value}
+}
+
+// drain - забирает все пачки очереди
+func drain(t *testing.T, q *Queue) []Batch {
+	var batches []Batch
+
+	err := q.Drain(func(batch Batch) error {
+		batches = append(batches, batch)
+		return nil
+	})
+	require.NoError(t, err)
+
+	return batches
+}
+
+func TestQueue_PushDrain(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	q, err := Open(dir, 0, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, q.Push("batch-1", []models.Metrics{counter("PollCount", 1)}))
+	require.NoError(t, q.Push("batch-2", []models.Metrics{counter("PollCount", 2)}))
+
+	// сервер недоступен - пачки остаются в очереди
+	sendErr := errors.New("connection refused")
+	err = q.Drain(func(batch Batch) error { return sendErr })
+	assert.ErrorIs(t, err, sendErr)
+	assert.Equal(t, 2, q.Len())
+
+	// очередь переживает перезапуск агента
+	q, err = Open(dir, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, q.Push("batch-3", []models.Metrics{counter("PollCount", 3)}))
+
+	batches := drain(t, q)
+	require.Len(t, batches, 3)
+	assert.Equal(t, "batch-1", batches[0].ID)
+	assert.Equal(t, "batch-2", batches[1].ID)
+	assert.Equal(t, "batch-3", batches[2].ID)
+
+	assert.Equal(t, 0, q.Len())
+	assert.Equal(t, int64(0), q.Size())
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestQueue_DrainStopsOnError(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	q, err := Open(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	for _, id := range []string{"batch-1", "batch-2", "batch-3"} {
+		require.NoError(t, q.Push(id, []models.Metrics{counter("PollCount", 1)}))
+	}
+
+	var sent []string
+	err = q.Drain(func(batch Batch) error {
+		if batch.ID == "batch-2" {
+			return errors.New("server error")
+		}
+
+		sent = append(sent, batch.ID)
+		return nil
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"batch-1"}, sent)
+
+	// отправка продолжается с недоставленной пачки
+	batches := drain(t, q)
+	require.Len(t, batches, 2)
+	assert.Equal(t, "batch-2", batches[0].ID)
+}
+
+func TestQueue_EvictByAge(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	q, err := Open(t.TempDir(), 0, time.Minute)
+	require.NoError(t, err)
+
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	require.NoError(t, q.Push("batch-1", []models.Metrics{counter("PollCount", 2), counter("Errors", 1), gauge("HeapAlloc", 1)}))
+
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, q.Push("batch-2", []models.Metrics{counter("PollCount", 3), gauge("HeapAlloc", 2)}))
+
+	// устаревшая пачка вытеснена, ее counter учтены в новой
+	batches := drain(t, q)
+	require.Len(t, batches, 1)
+	assert.Equal(t, "batch-2", batches[0].ID)
+	assert.ElementsMatch(t, []models.Metrics{counter("PollCount", 5), gauge("HeapAlloc", 2), counter("Errors", 1)}, batches[0].Metrics)
+}
+
+func TestQueue_EvictBySize(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	metrics := []models.Metrics{counter("PollCount", 1)}
+
+	q, err := Open(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, q.Push("batch-1", metrics))
+
+	// в очередь помещаются только две пачки
+	q.maxSize = 2*q.Size() + 10
+
+	require.NoError(t, q.Push("batch-2", metrics))
+	require.NoError(t, q.Push("batch-3", metrics))
+	assert.Equal(t, 2, q.Len())
+	assert.LessOrEqual(t, q.Size(), q.maxSize)
+
+	var total int64
+	batches := drain(t, q)
+	for _, batch := range batches {
+		for _, metric := range batch.Metrics {
+			total += *metric.Delta
+		}
+	}
+
+	assert.Equal(t, "batch-2", batches[0].ID)
+	assert.Equal(t, int64(3), total)
+}
+
+func TestQueue_InflightNotEvicted(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	q, err := Open(t.TempDir(), 0, time.Minute)
+	require.NoError(t, err)
+
+	now := time.Now()
+	q.now = func() time.Time { return now }
+	require.NoError(t, q.Push("batch-1", []models.Metrics{counter("PollCount", 1)}))
+
+	var pushed []Batch
+	err = q.Drain(func(batch Batch) error {
+		// пока пачка отправляется, она не вытесняется и ее counter не переносятся в новую
+		if batch.ID == "batch-1" {
+			now = now.Add(2 * time.Minute)
+			require.NoError(t, q.Push("batch-2", []models.Metrics{counter("PollCount", 1)}))
+		}
+
+		pushed = append(pushed, batch)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Len(t, pushed, 2)
+	assert.Equal(t, []models.Metrics{counter("PollCount", 1)}, pushed[1].Metrics)
+}
+
+func TestOpen_DropsBrokenFiles(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000007.json.tmp"), []byte("{"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000008.json"), []byte("{"), 0600))
+
+	q, err := Open(dir, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, q.Len())
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
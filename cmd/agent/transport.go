@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/dglazkoff/go-metrics/cmd/agent/client"
+	"github.com/dglazkoff/go-metrics/cmd/agent/config"
+	"github.com/dglazkoff/go-metrics/cmd/agent/queue"
+	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
+	"google.golang.org/grpc"
+)
+
+// retryIntervals - задержки перед повторами HTTP запроса после сетевой ошибки
+var retryIntervals = []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
+
+/*
+transport - соединение агента с сервером, создается один раз при старте и общее для всех воркеров:
+пул keep-alive соединений HTTP клиента или одно соединение gRPC
+*/
+type transport struct {
+	cfg  *config.Config
+	http *client.Client
+	grpc *client.GRPCMetricsClient
+	conn *grpc.ClientConn
+}
+
+func newTransport(cfg *config.Config) (*transport, error) {
+	if !cfg.IsGRPC {
+		return &transport{cfg: cfg, http: client.NewClient(retryIntervals)}, nil
+	}
+
+	conn, err := client.DialGRPC(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transport{cfg: cfg, grpc: client.NewMetricsClient(pb.NewMetricsClient(conn)), conn: conn}, nil
+}
+
+/*
+send - отправляет пачку по gRPC или HTTP, ошибка означает, что сервер пачку не применил и ее стоит отправить позже.
+Пачка, отклоненная сервером (*client.RejectedError), остается в очереди до исправления настроек агента или вытеснения
+*/
+func (t *transport) send(ctx context.Context, batch queue.Batch) error {
+	if t.grpc != nil {
+		return t.grpc.SendBatchByGRPC(ctx, batch.ID, batch.Metrics)
+	}
+
+	return t.http.SendBatchByHTTP(ctx, batch.ID, batch.Metrics, t.cfg)
+}
+
+// Close - закрывает соединение gRPC или keep-alive соединения HTTP клиента
+func (t *transport) Close() error {
+	if t.conn != nil {
+		return t.conn.Close()
+	}
+
+	return t.http.Close()
+}
+
+// reporter - все, что нужно воркерам для отправки метрик: конфигурация, соединение с сервером, очередь и учет counter
+type reporter struct {
+	cfg       *config.Config
+	transport *transport
+	queue     *queue.Queue
+	counters  *counterTracker
+}
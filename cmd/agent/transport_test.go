@@ -0,0 +1,258 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dglazkoff/go-metrics/cmd/agent/config"
+	"github.com/dglazkoff/go-metrics/cmd/agent/queue"
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// metricsServer - gRPC сервер, который только считает полученные пачки
+type metricsServer struct {
+	pb.UnimplementedMetricsServer
+	batches atomic.Int32
+}
+
+func (s *metricsServer) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	s.batches.Add(1)
+	return &pb.UpdateMetricsResponse{Accepted: int32(len(in.Metrics))}, nil
+}
+
+// grpcServer - запускает metricsServer на локальном TCP адресе
+func grpcServer(tb testing.TB) (*metricsServer, string) {
+	ms := &metricsServer{}
+	_, _, addr := serveGRPC(tb, ms, "127.0.0.1:0")
+
+	return ms, addr
+}
+
+// serveGRPC - запускает ms и сервис проверки здоровья на addr
+func serveGRPC(tb testing.TB, ms *metricsServer, addr string) (*grpc.Server, *health.Server, string) {
+	listen, err := net.Listen("tcp", addr)
+	require.NoError(tb, err)
+
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, ms)
+
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(s, hs)
+
+	go func() {
+		_ = s.Serve(listen)
+	}()
+	tb.Cleanup(s.Stop)
+
+	return s, hs, listen.Addr().String()
+}
+
+// httpServer - HTTP сервер, который считает открытые соединения и полученные метрики
+func httpServer(tb testing.TB) (*httptest.Server, *atomic.Int32, *atomic.Int32) {
+	var connections, received atomic.Int32
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var metrics []models.Metrics
+		if err = json.NewDecoder(zr).Decode(&metrics); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		received.Add(int32(len(metrics)))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	tb.Cleanup(server.Close)
+
+	return server, &connections, &received
+}
+
+func testBatch() queue.Batch {
+	delta := int64(1)
+	return queue.Batch{ID: "batch", Metrics: []models.Metrics{{ID: "PollCount", MType: constants.MetricTypeCounter, Delta: &delta}}}
+}
+
+func TestTransport_HTTPKeepAlive(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	server, connections, received := httpServer(t)
+	tr, err := newTransport(&config.Config{RunAddr: strings.TrimPrefix(server.URL, "http://")})
+	require.NoError(t, err)
+	defer tr.Close()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, tr.send(context.Background(), testBatch()))
+	}
+
+	// все пачки ушли по одному соединению, тело сжато gzip
+	assert.Equal(t, int32(1), connections.Load())
+	assert.Equal(t, int32(5), received.Load())
+
+	// воркеры отправляют метрики через общий транспорт одновременно
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, tr.send(context.Background(), testBatch()))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(9), received.Load())
+}
+
+func TestTransport_GRPCSharedConn(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	ms, addr := grpcServer(t)
+	tr, err := newTransport(&config.Config{RunAddr: addr, IsGRPC: true})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, tr.send(context.Background(), testBatch()))
+	}
+
+	assert.Equal(t, int32(3), ms.batches.Load())
+	assert.NoError(t, tr.Close())
+}
+
+func TestTransport_GRPCHealthCheck(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	ms := &metricsServer{}
+	_, hs, addr := serveGRPC(t, ms, "127.0.0.1:0")
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	tr, err := newTransport(&config.Config{RunAddr: addr, IsGRPC: true})
+	require.NoError(t, err)
+	defer tr.Close()
+
+	// сервер не готов принимать метрики - пачка не отправляется
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Error(t, tr.send(ctx, testBatch()))
+	assert.Equal(t, int32(0), ms.batches.Load())
+
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+
+	assert.Eventually(t, func() bool {
+		return tr.send(context.Background(), testBatch()) == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, int32(1), ms.batches.Load())
+}
+
+func TestTransport_GRPCReconnect(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	ms := &metricsServer{}
+	s, _, addr := serveGRPC(t, ms, "127.0.0.1:0")
+
+	tr, err := newTransport(&config.Config{RunAddr: addr, IsGRPC: true})
+	require.NoError(t, err)
+	defer tr.Close()
+
+	require.NoError(t, tr.send(context.Background(), testBatch()))
+
+	// сервер перезапускается на том же адресе, агент восстанавливает соединение сам
+	s.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Error(t, tr.send(ctx, testBatch()))
+
+	serveGRPC(t, ms, addr)
+
+	assert.Eventually(t, func() bool {
+		return tr.send(context.Background(), testBatch()) == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	assert.Equal(t, int32(2), ms.batches.Load())
+}
+
+/*
+BenchmarkTransport - отправка пачки с новым соединением на каждый тик, как раньше, и через общее соединение.
+go test -bench BenchmarkTransport -benchmem ./cmd/agent/
+*/
+func BenchmarkTransport(b *testing.B) {
+	_ = logger.Initialize()
+
+	server, _, _ := httpServer(b)
+	httpCfg := &config.Config{RunAddr: strings.TrimPrefix(server.URL, "http://")}
+
+	_, addr := grpcServer(b)
+	grpcCfg := &config.Config{RunAddr: addr, IsGRPC: true}
+
+	batch := testBatch()
+
+	for _, bm := range []struct {
+		name string
+		cfg  *config.Config
+	}{
+		{name: "http", cfg: httpCfg},
+		{name: "grpc", cfg: grpcCfg},
+	} {
+		b.Run(bm.name+"/per-tick", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tr, err := newTransport(bm.cfg)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				if err = tr.send(context.Background(), batch); err != nil {
+					b.Fatal(err)
+				}
+
+				if err = tr.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(bm.name+"/shared", func(b *testing.B) {
+			tr, err := newTransport(bm.cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer tr.Close()
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err = tr.send(context.Background(), batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
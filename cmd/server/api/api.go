@@ -3,25 +3,73 @@ package api
 
 import (
 	"context"
+	"net/url"
+	"time"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
 	"github.com/dglazkoff/go-metrics/internal/models"
 )
 
 type metric interface {
-	Get(ctx context.Context, name string) (models.Metrics, error)
+	Get(ctx context.Context, mType string, name string, labels map[string]string) (models.Metrics, error)
 	GetAll(ctx context.Context) ([]models.Metrics, error)
 	Update(ctx context.Context, metric models.Metrics) error
 	UpdateList(ctx context.Context, metric []models.Metrics) error
+	History(ctx context.Context, mType string, name string, labels map[string]string, from, to time.Time, step time.Duration) ([]models.MetricPoint, error)
 	PingDB(ctx context.Context) error
 }
 
+type alerts interface {
+	Alerts() []models.Alert
+}
+
 // не делаем экспортируемых полей чтобы скрыть
 type API struct {
 	metricsService metric
+	alertsService  alerts
 	cfg            *config.Config
 }
 
-func NewAPI(m metric, cfg *config.Config) API {
-	return API{metricsService: m, cfg: cfg}
+// Option - необязательная зависимость API
+type Option func(*API)
+
+// WithAlerts - API отдает алерты al на /alerts и на html странице
+func WithAlerts(al alerts) Option {
+	return func(a *API) {
+		a.alertsService = al
+	}
+}
+
+func NewAPI(m metric, cfg *config.Config, opts ...Option) API {
+	a := API{metricsService: m, cfg: cfg}
+	for _, opt := range opts {
+		opt(&a)
+	}
+
+	return a
+}
+
+// labelsFromQuery - метки метрики из параметров запроса, кроме зарезервированных параметров хендлера
+func labelsFromQuery(query url.Values, reserved ...string) map[string]string {
+	labels := make(map[string]string)
+
+	for key, values := range query {
+		if len(values) == 0 {
+			continue
+		}
+
+		isReserved := false
+		for _, r := range reserved {
+			if key == r {
+				isReserved = true
+				break
+			}
+		}
+
+		if !isReserved {
+			labels[key] = values[0]
+		}
+	}
+
+	return models.CopyLabels(labels)
 }
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dglazkoff/go-metrics/internal/logger"
+)
+
+// GetAlerts - хендлер получения активных алертов: pending, firing и недавно resolved
+func (a API) GetAlerts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.alertsService == nil {
+			logger.Log.Debug("Alerting is disabled")
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(a.alertsService.Alerts()); err != nil {
+			logger.Log.Debug("Error while encode alerts: ", err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubAlerts []models.Alert
+
+func (s stubAlerts) Alerts() []models.Alert {
+	return s
+}
+
+func TestAPI_GetAlerts(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	cfg := config.Config{}
+
+	t.Run("alerting disabled", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		NewAPI(new(MockMetricsService), &cfg).GetAlerts()(rec, httptest.NewRequest(http.MethodGet, "/alerts", nil))
+
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	})
+
+	t.Run("active alerts", func(t *testing.T) {
+		activeAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		alerts := stubAlerts{{Rule: "HighHeap", Expr: "gauge HeapAlloc > 1e9", State: constants.AlertStateFiring, Metric: "HeapAlloc", Value: 2e9, ActiveAt: activeAt, FiredAt: &activeAt}}
+
+		rec := httptest.NewRecorder()
+		NewAPI(new(MockMetricsService), &cfg, WithAlerts(alerts)).GetAlerts()(rec, httptest.NewRequest(http.MethodGet, "/alerts", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+		var result []models.Alert
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+		assert.Equal(t, []models.Alert(alerts), result)
+	})
+}
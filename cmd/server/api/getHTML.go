@@ -6,6 +6,7 @@ import (
 
 	"github.com/dglazkoff/go-metrics/cmd/server/html"
 	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
 )
 
 // GetHTML - хендлер получения html страницы с метриками
@@ -19,7 +20,12 @@ func (a API) GetHTML() http.HandlerFunc {
 			logger.Log.Debug("Error while get all metrics: ", err)
 		}
 
-		component := html.Metrics(metrics)
+		var alerts []models.Alert
+		if a.alertsService != nil {
+			alerts = a.alertsService.Alerts()
+		}
+
+		component := html.Metrics(metrics, alerts)
 		component.Render(context.Background(), w)
 
 		w.WriteHeader(http.StatusOK)
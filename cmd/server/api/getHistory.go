@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/go-chi/chi/v5"
+)
+
+// parseTime - разбирает время в формате RFC3339 или unix timestamp в секундах
+func parseTime(value string, defaultValue time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// GetHistory - хендлер получения истории значений метрики.
+// Параметры запроса: from и to (RFC3339 или unix timestamp, по умолчанию последний час), step (например 10s),
+// остальные параметры запроса - метки метрики
+func (a API) GetHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.cfg.IsHistory {
+			logger.Log.Debug("History is disabled")
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+
+		metricType := chi.URLParam(r, "metricType")
+		metricName := chi.URLParam(r, "metricName")
+
+		if metricType != constants.MetricTypeGauge && metricType != constants.MetricTypeCounter {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		query := r.URL.Query()
+
+		to, errTo := parseTime(query.Get("to"), time.Now())
+		from, errFrom := parseTime(query.Get("from"), to.Add(-time.Hour))
+
+		var step time.Duration
+		var errStep error
+		if query.Get("step") != "" {
+			step, errStep = time.ParseDuration(query.Get("step"))
+		}
+
+		if err := errors.Join(errTo, errFrom, errStep); err != nil || step < 0 || from.After(to) {
+			logger.Log.Debug("Wrong history params: ", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		points, err := a.metricsService.History(r.Context(), metricType, metricName, labelsFromQuery(query, "from", "to", "step"), from, to, step)
+
+		if err != nil {
+			logger.Log.Debug("Error while get metric history: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err = json.NewEncoder(w).Encode(points); err != nil {
+			logger.Log.Debug("Error while encode metric history: ", err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/services/service"
+	"github.com/dglazkoff/go-metrics/cmd/server/storage/file"
+	"github.com/dglazkoff/go-metrics/cmd/server/storage/metrics"
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI_GetHistory(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	cfg := config.Config{StoreInterval: 300, IsHistory: true}
+
+	store := metrics.New([]models.Metrics{}, metrics.WithHistory(10))
+	fileStore := file.New(store, &cfg)
+	metricService := service.New(store, fileStore, &cfg)
+	newAPI := NewAPI(metricService, &cfg)
+
+	for _, v := range []float64{1, 3, 5} {
+		value := v
+		require.NoError(t, metricService.Update(context.Background(), models.Metrics{ID: "HeapAlloc", MType: constants.MetricTypeGauge, Value: &value}))
+	}
+
+	delta := int64(2)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, metricService.Update(context.Background(), models.Metrics{ID: "PollCount", MType: constants.MetricTypeCounter, Delta: &delta}))
+	}
+
+	r := chi.NewRouter()
+	r.Get("/history/{metricType}/{metricName}", newAPI.GetHistory())
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+		want   []float64
+	}{
+		{name: "raw gauge points", url: "/history/gauge/HeapAlloc", status: http.StatusOK, want: []float64{1, 3, 5}},
+		{name: "downsampled gauge points", url: "/history/gauge/HeapAlloc?step=1h", status: http.StatusOK, want: []float64{3}},
+		{name: "downsampled counter points", url: "/history/counter/PollCount?step=1h", status: http.StatusOK, want: []float64{6}},
+		{name: "unknown metric", url: "/history/gauge/unknown", status: http.StatusOK, want: []float64{}},
+		{name: "interval without points", url: "/history/gauge/HeapAlloc?from=0&to=60", status: http.StatusOK, want: []float64{}},
+		{name: "wrong type", url: "/history/wrong/HeapAlloc", status: http.StatusBadRequest},
+		{name: "wrong step", url: "/history/gauge/HeapAlloc?step=abc", status: http.StatusBadRequest},
+		{name: "wrong interval", url: "/history/gauge/HeapAlloc?from=2024-01-02T00:00:00Z&to=2024-01-01T00:00:00Z", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ts.Client().Get(ts.URL + tt.url)
+			require.NoError(t, err)
+			defer result.Body.Close()
+
+			assert.Equal(t, tt.status, result.StatusCode)
+
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var points []models.MetricPoint
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&points))
+
+			values := make([]float64, 0, len(points))
+			for _, point := range points {
+				if point.Value != nil {
+					values = append(values, *point.Value)
+				}
+
+				if point.Delta != nil {
+					values = append(values, float64(*point.Delta))
+				}
+			}
+
+			assert.Equal(t, tt.want, values)
+		})
+	}
+
+	t.Run("history disabled", func(t *testing.T) {
+		disabledAPI := NewAPI(metricService, &config.Config{})
+		rec := httptest.NewRecorder()
+
+		disabledAPI.GetHistory().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/history/gauge/HeapAlloc", nil))
+
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	})
+}
+
+func TestParseTime(t *testing.T) {
+	defaultTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	value, err := parseTime("", defaultTime)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultTime, value)
+
+	value, err = parseTime("1704067200", defaultTime)
+	assert.NoError(t, err)
+	assert.True(t, defaultTime.Equal(value))
+
+	value, err = parseTime("2024-01-01T00:00:00Z", time.Time{})
+	assert.NoError(t, err)
+	assert.True(t, defaultTime.Equal(value))
+
+	_, err = parseTime("yesterday", defaultTime)
+	assert.Error(t, err)
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-// GetMetricValueInRequest - хендлер для получения метрики по данным в URLParams
+// GetMetricValueInRequest - хендлер для получения метрики по данным в URLParams, метки передаются параметрами запроса
 func (a API) GetMetricValueInRequest() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		metricType := chi.URLParam(r, "metricType")
@@ -22,7 +22,7 @@ func (a API) GetMetricValueInRequest() http.HandlerFunc {
 			return
 		}
 
-		value, err := a.metricsService.Get(r.Context(), metricName)
+		value, err := a.metricsService.Get(r.Context(), metricType, metricName, labelsFromQuery(r.URL.Query()))
 
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
@@ -66,7 +66,7 @@ func (a API) GetMetricValueInBody() http.HandlerFunc {
 
 		enc := json.NewEncoder(w)
 
-		value, err := a.metricsService.Get(r.Context(), metric.ID)
+		value, err := a.metricsService.Get(r.Context(), metric.MType, metric.ID, metric.Labels)
 
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
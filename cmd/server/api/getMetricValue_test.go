@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strconv"
 	"testing"
 
@@ -108,7 +109,7 @@ func TestAPI_GetMetricValue(t *testing.T) {
 			var resultMetric models.Metrics
 
 			if result.Body == http.NoBody {
-				if (tt.want.metric != models.Metrics{}) {
+				if !reflect.DeepEqual(tt.want.metric, models.Metrics{}) {
 					t.Fatalf("Expected non-empty response body")
 				}
 
@@ -156,7 +157,7 @@ func TestAPI_GetMetricValue(t *testing.T) {
 			assert.Equal(t, tt.want.status, result.StatusCode)
 
 			if result.Body == http.NoBody {
-				if (tt.want.metric != models.Metrics{}) {
+				if !reflect.DeepEqual(tt.want.metric, models.Metrics{}) {
 					t.Errorf("Expected non-empty response body")
 				}
 
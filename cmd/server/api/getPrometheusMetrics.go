@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/security"
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+)
+
+// prometheusContentType - content type текстового формата экспозиции Prometheus
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// rejectedRequestsName - имя семейства со счетчиками отклоненных запросов
+const rejectedRequestsName = "metrics_server_rejected_requests_total"
+
+/*
+prometheusName - приводит имя метрики к виду [a-zA-Z_:][a-zA-Z0-9_:]*,
+недопустимые символы заменяются на "_", имя начинающееся с цифры получает префикс "_"
+*/
+func prometheusName(name string) string {
+	var b strings.Builder
+
+	for i, r := range name {
+		isLetter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || r == ':'
+		isDigit := r >= '0' && r <= '9'
+
+		switch {
+		case isLetter:
+			b.WriteRune(r)
+		case isDigit && i == 0:
+			b.WriteRune('_')
+			b.WriteRune(r)
+		case isDigit:
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	if b.Len() == 0 {
+		return "_"
+	}
+
+	return b.String()
+}
+
+// escapeHelp - экранирует текст для строки # HELP
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// prometheusLabels - метки метрики в виде {name="value",...}, имена меток приводятся к допустимому виду
+func prometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	sanitized := make(map[string]string, len(labels))
+	for key, value := range labels {
+		sanitized[strings.ReplaceAll(prometheusName(key), ":", "_")] = value
+	}
+
+	return "{" + models.LabelsKey(sanitized) + "}"
+}
+
+/*
+GetPrometheusMetrics - хендлер отдачи всех метрик в текстовом формате Prometheus.
+gauge отдаются как gauge, counter - как counter с суффиксом _total,
+поэтому gauge и counter с одинаковым именем не конфликтуют. Метрики с одним именем и разными метками
+попадают в одно семейство
+*/
+func (a API) GetPrometheusMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics, err := a.metricsService.GetAll(r.Context())
+
+		if err != nil {
+			logger.Log.Debug("Error while get all metrics: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		type family struct {
+			name    string
+			help    string
+			mType   string
+			samples []string
+		}
+
+		families := make(map[string]*family)
+
+		for _, metric := range metrics {
+			var f family
+			var value string
+
+			switch {
+			case metric.MType == constants.MetricTypeGauge && metric.Value != nil:
+				f = family{name: prometheusName(metric.ID), help: "gauge metric " + metric.ID, mType: "gauge"}
+				value = strconv.FormatFloat(*metric.Value, 'g', -1, 64)
+			case metric.MType == constants.MetricTypeCounter && metric.Delta != nil:
+				f = family{name: prometheusName(metric.ID) + "_total", help: "counter metric " + metric.ID, mType: "counter"}
+				value = strconv.FormatInt(*metric.Delta, 10)
+			default:
+				continue
+			}
+
+			existing, ok := families[f.name]
+			if !ok {
+				existing = &f
+				families[f.name] = existing
+			}
+
+			// после санитизации имена gauge и counter могут совпасть, Prometheus не допускает разных типов в одном семействе
+			if existing.mType != f.mType {
+				logger.Log.Debug("Duplicate prometheus metric name: ", f.name)
+				continue
+			}
+
+			existing.samples = append(existing.samples, f.name+prometheusLabels(metric.Labels)+" "+value)
+		}
+
+		names := make([]string, 0, len(families))
+		for name := range families {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w.Header().Set("Content-Type", prometheusContentType)
+
+		buf := bufio.NewWriter(w)
+
+		for _, name := range names {
+			f := families[name]
+			sort.Strings(f.samples)
+
+			buf.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+			buf.WriteString("# TYPE " + f.name + " " + f.mType + "\n")
+
+			for _, sample := range f.samples {
+				buf.WriteString(sample + "\n")
+			}
+		}
+
+		// запросы, отклоненные проверками подписи, расшифровки и токена агента, чтобы попытки обхода были видны в мониторинге
+		if rejections := security.Rejections(); len(rejections) > 0 {
+			buf.WriteString("# HELP " + rejectedRequestsName + " requests rejected by security checks\n")
+			buf.WriteString("# TYPE " + rejectedRequestsName + " counter\n")
+
+			for _, rejection := range rejections {
+				buf.WriteString(rejectedRequestsName + prometheusLabels(map[string]string{"reason": rejection.Reason}) + " " + strconv.FormatInt(rejection.Count, 10) + "\n")
+			}
+		}
+
+		if err = buf.Flush(); err != nil {
+			logger.Log.Debug("Error while write prometheus metrics: ", err)
+		}
+	}
+}
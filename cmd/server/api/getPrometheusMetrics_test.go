@@ -0,0 +1,111 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/security"
+	"github.com/dglazkoff/go-metrics/cmd/server/services/service"
+	"github.com/dglazkoff/go-metrics/cmd/server/storage/file"
+	"github.com/dglazkoff/go-metrics/cmd/server/storage/metrics"
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI_GetPrometheusMetrics(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	cfg := config.Config{StoreInterval: 300}
+
+	value := 12.5
+	delta := int64(7)
+
+	store := metrics.New([]models.Metrics{
+		{ID: "HeapAlloc", MType: constants.MetricTypeGauge, Value: &value},
+		{ID: "PollCount", MType: constants.MetricTypeCounter, Delta: &delta},
+		{ID: "1st.metric-name", MType: constants.MetricTypeGauge, Value: &value},
+		{ID: "HeapAlloc", MType: constants.MetricTypeGauge, Value: &value, Labels: map[string]string{"host": "agent-1", "team.name": `a"b`}},
+	})
+	fileStore := file.New(store, &cfg)
+	newAPI := NewAPI(service.New(store, fileStore, &cfg), &cfg)
+
+	ts := httptest.NewServer(newAPI.GetPrometheusMetrics())
+	defer ts.Close()
+
+	result, err := ts.Client().Get(ts.URL)
+	require.NoError(t, err)
+	defer result.Body.Close()
+
+	body, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, prometheusContentType, result.Header.Get("Content-Type"))
+	assert.Equal(t, `# HELP HeapAlloc gauge metric HeapAlloc
+# TYPE HeapAlloc gauge
+HeapAlloc 12.5
+HeapAlloc{host="agent-1",team_name="a\"b"} 12.5
+# HELP PollCount_total counter metric PollCount
+# TYPE PollCount_total counter
+PollCount_total 7
+# HELP _1st_metric_name gauge metric 1st.metric-name
+# TYPE _1st_metric_name gauge
+_1st_metric_name 12.5
+`, string(body))
+}
+
+func TestAPI_GetPrometheusMetrics_Rejections(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	cfg := config.Config{StoreInterval: 300}
+	store := metrics.New(nil)
+	newAPI := NewAPI(service.New(store, file.New(store, &cfg), &cfg), &cfg)
+
+	security.Reject(security.ReasonWrongHash)
+	security.Reject(security.ReasonWrongHash)
+	security.Reject(security.ReasonMissingHash)
+
+	ts := httptest.NewServer(newAPI.GetPrometheusMetrics())
+	defer ts.Close()
+
+	result, err := ts.Client().Get(ts.URL)
+	require.NoError(t, err)
+	defer result.Body.Close()
+
+	body, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, `# HELP metrics_server_rejected_requests_total requests rejected by security checks
+# TYPE metrics_server_rejected_requests_total counter
+metrics_server_rejected_requests_total{reason="missing_hash"} 1
+metrics_server_rejected_requests_total{reason="wrong_hash"} 2
+`, string(body))
+}
+
+func TestPrometheusName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "HeapAlloc", want: "HeapAlloc"},
+		{name: "http:requests_total", want: "http:requests_total"},
+		{name: "cpu.utilization-1", want: "cpu_utilization_1"},
+		{name: "9lives", want: "_9lives"},
+		{name: "память", want: "______"},
+		{name: "", want: "_"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, prometheusName(tt.name))
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/dglazkoff/go-metrics/internal/models"
@@ -17,7 +18,7 @@ type MockMetricsService struct {
 	mock.Mock
 }
 
-func (m *MockMetricsService) Get(ctx context.Context, name string) (models.Metrics, error) {
+func (m *MockMetricsService) Get(ctx context.Context, mType string, name string, labels map[string]string) (models.Metrics, error) {
 	//TODO implement me
 	panic("implement me")
 }
@@ -37,6 +38,11 @@ func (m *MockMetricsService) UpdateList(ctx context.Context, metric []models.Met
 	panic("implement me")
 }
 
+func (m *MockMetricsService) History(ctx context.Context, mType string, name string, labels map[string]string, from, to time.Time, step time.Duration) ([]models.MetricPoint, error) {
+	args := m.Called(ctx, mType, name, labels, from, to, step)
+	return args.Get(0).([]models.MetricPoint), args.Error(1)
+}
+
 func (m *MockMetricsService) PingDB(ctx context.Context) error {
 	args := m.Called(ctx)
 	return args.Error(0)
@@ -11,7 +11,7 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-// UpdateMetricValueInRequest - хендлер обновления метрики, передаваемой в URLParams
+// UpdateMetricValueInRequest - хендлер обновления метрики, передаваемой в URLParams, метки передаются параметрами запроса
 func (a API) UpdateMetricValueInRequest() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		metricType := chi.URLParam(r, "metricType")
@@ -29,7 +29,7 @@ func (a API) UpdateMetricValueInRequest() http.HandlerFunc {
 				return
 			}
 
-			model = models.Metrics{ID: metricName, MType: metricType, Value: &floatValue}
+			model = models.Metrics{ID: metricName, MType: metricType, Value: &floatValue, Labels: labelsFromQuery(r.URL.Query())}
 		}
 
 		if metricType == constants.MetricTypeCounter {
@@ -41,7 +41,7 @@ func (a API) UpdateMetricValueInRequest() http.HandlerFunc {
 				return
 			}
 
-			model = models.Metrics{ID: metricName, MType: metricType, Delta: &intValue}
+			model = models.Metrics{ID: metricName, MType: metricType, Delta: &intValue, Labels: labelsFromQuery(r.URL.Query())}
 		}
 
 		err := a.metricsService.Update(r.Context(), model)
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/dglazkoff/go-metrics/cmd/server/services/service"
+	constants "github.com/dglazkoff/go-metrics/internal/const"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/dglazkoff/go-metrics/internal/models"
 )
@@ -18,7 +20,9 @@ func (a API) UpdateList() http.HandlerFunc {
 			return
 		}
 
-		err := a.metricsService.UpdateList(r.Context(), metrics)
+		// повторная доставка пачки с тем же X-Batch-ID подтверждается, но не применяется еще раз
+		ctx := service.WithBatchID(r.Context(), r.Header.Get(constants.BatchIDHeader))
+		err := a.metricsService.UpdateList(ctx, metrics)
 
 		/*
 			UpdateList использует метод Update. там где я его вызываю тоже возвращаю StatusBadRequest.
@@ -35,11 +35,13 @@ func TestAPI_UpdateMetrics(t *testing.T) {
 	}
 	var deltaValue int64 = 1
 	var deltaResultValue int64 = 2
+	var deltaUpdatedValue int64 = 3
 	var value float64 = 101
 
 	clean := func() {
 		deltaValue = 1
 		deltaResultValue = 2
+		deltaUpdatedValue = 3
 		value = 101
 	}
 
@@ -79,7 +81,7 @@ func TestAPI_UpdateMetrics(t *testing.T) {
 			},
 			want: want{
 				store: []models.Metrics{
-					{ID: "valueCounter", MType: constants.MetricTypeCounter, Delta: &deltaResultValue},
+					{ID: "valueCounter", MType: constants.MetricTypeCounter, Delta: &deltaUpdatedValue},
 					{ID: "valueGauge", MType: constants.MetricTypeGauge, Value: &value},
 				},
 				status: http.StatusOK,
@@ -100,6 +102,19 @@ func TestAPI_UpdateMetrics(t *testing.T) {
 				status: http.StatusOK,
 			},
 		},
+		{
+			// с экранированием только значений обе метрики дали бы один ключ a="x",b="y"
+			name:  "error if label name is not a prometheus name",
+			store: []models.Metrics{},
+			metrics: []models.Metrics{
+				{ID: "valueGauge", MType: constants.MetricTypeGauge, Value: &value, Labels: map[string]string{"a": "x", "b": "y"}},
+				{ID: "valueGauge", MType: constants.MetricTypeGauge, Value: &value, Labels: map[string]string{`a="x",b`: "y"}},
+			},
+			want: want{
+				store:  []models.Metrics{},
+				status: http.StatusBadRequest,
+			},
+		},
 		{
 			name:  "error if wrong metric type",
 			store: []models.Metrics{},
@@ -0,0 +1,276 @@
+// Пакет auth - аутентификация по токенам и проверка ролей reader, writer и admin на маршрутах сервера
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/security"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"gopkg.in/yaml.v3"
+)
+
+type agentKey struct{}
+
+var (
+	errNoKeys        = errors.New("agent keys are not loaded")
+	errMissingToken  = errors.New("missing agent token")
+	errInvalidToken  = errors.New("invalid agent token")
+	errAgentMismatch = errors.New("agent token does not match client certificate")
+	errForbiddenRole = errors.New("token has no required role")
+	errNoAdmin       = errors.New("admin routes are disabled without agent keys")
+)
+
+// Role - роль токена
+type Role string
+
+// Роли токенов
+const (
+	RoleReader Role = "reader" // чтение метрик, если включен cfg.ReadAuth
+	RoleWriter Role = "writer" // запись метрик, роль по умолчанию для токенов без ролей
+	RoleAdmin  Role = "admin"  // профилирование и управление сервером, разрешено все
+)
+
+// AgentToken - токен агента из файла ключей
+type AgentToken struct {
+	ID    string `json:"id" yaml:"id"`
+	Token string `json:"token" yaml:"token"`
+	Roles []Role `json:"roles" yaml:"roles"`
+}
+
+// Principal - владелец токена и его роли
+type Principal struct {
+	ID    string
+	Roles []Role
+}
+
+// Has - есть ли у владельца токена роль role. Администратору разрешено все
+func (p Principal) Has(role Role) bool {
+	for _, r := range p.Roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+type tokensFile struct {
+	Agents []AgentToken `json:"agents" yaml:"agents"`
+}
+
+// WithAgent - контекст с идентификатором агента
+func WithAgent(ctx context.Context, agent string) context.Context {
+	return context.WithValue(ctx, agentKey{}, agent)
+}
+
+// Agent - идентификатор агента, установленный по токену или клиентскому сертификату
+func Agent(ctx context.Context) (string, bool) {
+	agent, ok := ctx.Value(agentKey{}).(string)
+	return agent, ok && agent != ""
+}
+
+// tokenHash - токены хранятся и сравниваются только по хэшу, чтобы время поиска не зависело от совпадающего префикса
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+LoadTokens - читает токены из YAML (расширения .yaml и .yml) или JSON файла вида
+{"agents": [{"id": ..., "token": ..., "roles": ["reader", "writer", "admin"]}]}.
+Токен без ролей - токен агента с ролью writer
+*/
+func LoadTokens(path string) (map[string]Principal, error) {
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while reading agent keys: %w", err)
+	}
+
+	var file tokensFile
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &file)
+	default:
+		err = json.Unmarshal(content, &file)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing agent keys: %w", err)
+	}
+
+	tokens := make(map[string]Principal, len(file.Agents))
+	var errs []error
+
+	for _, agent := range file.Agents {
+		if agent.ID == "" || agent.Token == "" {
+			errs = append(errs, fmt.Errorf("agent id and token are required, got id %q", agent.ID))
+			continue
+		}
+
+		hash := tokenHash(agent.Token)
+		if _, ok := tokens[hash]; ok {
+			errs = append(errs, fmt.Errorf("duplicate token of agent %s", agent.ID))
+			continue
+		}
+
+		roles := agent.Roles
+		if len(roles) == 0 {
+			roles = []Role{RoleWriter}
+		}
+
+		if err = validateRoles(roles); err != nil {
+			errs = append(errs, fmt.Errorf("agent %s: %w", agent.ID, err))
+			continue
+		}
+
+		tokens[hash] = Principal{ID: agent.ID, Roles: roles}
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func validateRoles(roles []Role) error {
+	for _, role := range roles {
+		switch role {
+		case RoleReader, RoleWriter, RoleAdmin:
+		default:
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+
+	return nil
+}
+
+/*
+Auth - проверяет токен и роль на запросах и добавляет идентификатор владельца токена в контекст.
+Если файл ключей не задан, проверка выключена, а маршруты роли admin закрыты: без токенов администратора нет.
+Если файл задан, но не загрузился, запросы с проверкой отклоняются. Чтение метрик проверяется, только если включен cfg.ReadAuth
+*/
+type Auth struct {
+	enabled  bool
+	readAuth bool
+	tokens   map[string]Principal
+	err      error
+}
+
+func Initialize(cfg *config.Config) *Auth {
+	if cfg.AgentKeysFile == "" {
+		return &Auth{}
+	}
+
+	tokens, err := LoadTokens(cfg.AgentKeysFile)
+
+	if err != nil {
+		logger.Log.Debug("Error while loading agent keys: ", err)
+	}
+
+	return &Auth{enabled: true, readAuth: cfg.ReadAuth, tokens: tokens, err: err}
+}
+
+// required - нужно ли проверять токен для роли role, роль admin проверяется всегда
+func (a *Auth) required(role Role) bool {
+	return role == RoleAdmin || a.enabled && (role != RoleReader || a.readAuth)
+}
+
+// bearerToken - токен из значения заголовка Authorization вида "Bearer <token>"
+func bearerToken(value string) string {
+	scheme, token, found := strings.Cut(value, " ")
+
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+/*
+authenticate - идентификатор владельца токена с ролью role.
+Агент, уже определенный по клиентскому сертификату, должен совпасть с владельцем токена
+*/
+func (a *Auth) authenticate(ctx context.Context, token string, role Role) (string, error) {
+	if !a.enabled {
+		security.Reject(security.ReasonForbiddenRole)
+		return "", errNoAdmin
+	}
+
+	if a.err != nil {
+		security.Reject(security.ReasonNoKeys)
+		return "", errNoKeys
+	}
+
+	if token == "" {
+		security.Reject(security.ReasonMissingToken)
+		return "", errMissingToken
+	}
+
+	principal, ok := a.tokens[tokenHash(token)]
+	if !ok {
+		security.Reject(security.ReasonInvalidToken)
+		return "", errInvalidToken
+	}
+
+	if certAgent, ok := Agent(ctx); ok && certAgent != principal.ID {
+		security.Reject(security.ReasonAgentMismatch)
+		return "", errAgentMismatch
+	}
+
+	if !principal.Has(role) {
+		security.Reject(security.ReasonForbiddenRole)
+		return "", errForbiddenRole
+	}
+
+	logger.Log.Debug("Authenticated ", principal.ID, " as ", role)
+
+	return principal.ID, nil
+}
+
+// Authenticate - пропускает запрос записи только с действующим токеном с ролью writer в заголовке Authorization
+func (a *Auth) Authenticate(handler http.HandlerFunc) http.HandlerFunc {
+	return a.Require(RoleWriter, handler)
+}
+
+/*
+Require - пропускает запрос только с действующим токеном с ролью role в заголовке Authorization.
+Без токена или с неизвестным токеном - 401, с токеном без нужной роли - 403
+*/
+func (a *Auth) Require(role Role, handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !a.required(role) {
+			handler.ServeHTTP(writer, request)
+			return
+		}
+
+		agent, err := a.authenticate(request.Context(), bearerToken(request.Header.Get("Authorization")), role)
+
+		switch {
+		case errors.Is(err, errNoKeys):
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		case errors.Is(err, errAgentMismatch), errors.Is(err, errForbiddenRole), errors.Is(err, errNoAdmin):
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		case err != nil:
+			writer.Header().Set("WWW-Authenticate", "Bearer")
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(writer, request.WithContext(WithAgent(request.Context(), agent)))
+	}
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/security"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// writeKeys - записывает файл токенов агентов name в dir
+func writeKeys(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func TestLoadTokens(t *testing.T) {
+	tokens, err := LoadTokens(writeKeys(t, "agents.yaml", "agents:\n  - id: agent-1\n    token: token-1\n  - id: ops\n    token: token-2\n    roles: [reader, admin]\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Principal{
+		tokenHash("token-1"): {ID: "agent-1", Roles: []Role{RoleWriter}},
+		tokenHash("token-2"): {ID: "ops", Roles: []Role{RoleReader, RoleAdmin}},
+	}, tokens)
+
+	tokens, err = LoadTokens(writeKeys(t, "agents.json", `{"agents":[{"id":"agent-1","token":"token-1"}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Principal{tokenHash("token-1"): {ID: "agent-1", Roles: []Role{RoleWriter}}}, tokens)
+
+	_, err = LoadTokens(writeKeys(t, "agents.json", `{"agents":[{"id":"agent-1","token":"token-1","roles":["root"]}]}`))
+	assert.Error(t, err)
+
+	_, err = LoadTokens(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+
+	_, err = LoadTokens(writeKeys(t, "agents.json", `{"agents":`))
+	assert.Error(t, err)
+
+	_, err = LoadTokens(writeKeys(t, "agents.json", `{"agents":[{"id":"agent-1"}]}`))
+	assert.Error(t, err)
+
+	_, err = LoadTokens(writeKeys(t, "agents.json", `{"agents":[{"id":"agent-1","token":"token"},{"id":"agent-2","token":"token"}]}`))
+	assert.Error(t, err)
+}
+
+func TestPrincipal_Has(t *testing.T) {
+	reader := Principal{ID: "grafana", Roles: []Role{RoleReader}}
+	assert.True(t, reader.Has(RoleReader))
+	assert.False(t, reader.Has(RoleWriter))
+	assert.False(t, reader.Has(RoleAdmin))
+
+	admin := Principal{ID: "ops", Roles: []Role{RoleAdmin}}
+	assert.True(t, admin.Has(RoleReader))
+	assert.True(t, admin.Has(RoleWriter))
+	assert.True(t, admin.Has(RoleAdmin))
+}
+
+func TestAuthenticate(t *testing.T) {
+	require.NoError(t, logger.Initialize())
+
+	keys := writeKeys(t, "agents.json", `{"agents":[
+		{"id":"agent-1","token":"token-1"},
+		{"id":"grafana","token":"token-reader","roles":["reader"]},
+		{"id":"ops","token":"token-admin","roles":["admin"]}
+	]}`)
+
+	handler := func(writer http.ResponseWriter, request *http.Request) {
+		agent, _ := Agent(request.Context())
+		_, _ = writer.Write([]byte(agent))
+	}
+
+	tests := []struct {
+		name          string
+		keysFile      string
+		authorization string
+		certAgent     string
+		expectedCode  int
+		expectedBody  string
+		reason        string
+	}{
+		{name: "auth disabled", expectedCode: http.StatusOK},
+		{name: "valid token", keysFile: keys, authorization: "Bearer token-1", expectedCode: http.StatusOK, expectedBody: "agent-1"},
+		{name: "missing token", keysFile: keys, expectedCode: http.StatusUnauthorized, reason: security.ReasonMissingToken},
+		{name: "wrong scheme", keysFile: keys, authorization: "Basic token-1", expectedCode: http.StatusUnauthorized, reason: security.ReasonMissingToken},
+		{name: "invalid token", keysFile: keys, authorization: "Bearer token-2", expectedCode: http.StatusUnauthorized, reason: security.ReasonInvalidToken},
+		{name: "same agent as certificate", keysFile: keys, authorization: "Bearer token-1", certAgent: "agent-1", expectedCode: http.StatusOK, expectedBody: "agent-1"},
+		{name: "other agent certificate", keysFile: keys, authorization: "Bearer token-1", certAgent: "agent-2", expectedCode: http.StatusForbidden, reason: security.ReasonAgentMismatch},
+		{name: "reader token on write", keysFile: keys, authorization: "Bearer token-reader", expectedCode: http.StatusForbidden, reason: security.ReasonForbiddenRole},
+		{name: "admin token on write", keysFile: keys, authorization: "Bearer token-admin", expectedCode: http.StatusOK, expectedBody: "ops"},
+		{name: "keys not loaded", keysFile: filepath.Join(t.TempDir(), "missing.json"), authorization: "Bearer token-1", expectedCode: http.StatusInternalServerError, reason: security.ReasonNoKeys},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := security.Count(tt.reason)
+
+			req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.certAgent != "" {
+				req = req.WithContext(WithAgent(req.Context(), tt.certAgent))
+			}
+
+			rec := httptest.NewRecorder()
+			Initialize(&config.Config{AgentKeysFile: tt.keysFile}).Authenticate(handler)(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			assert.Equal(t, tt.expectedBody, rec.Body.String())
+
+			if tt.reason != "" {
+				assert.Equal(t, before+1, security.Count(tt.reason))
+			}
+
+			if tt.expectedCode == http.StatusUnauthorized {
+				assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	require.NoError(t, logger.Initialize())
+
+	keys := writeKeys(t, "agents.yaml", "agents:\n  - id: agent-1\n    token: token-1\n  - id: grafana\n    token: token-reader\n    roles: [reader]\n  - id: ops\n    token: token-admin\n    roles: [admin]\n")
+
+	handler := func(writer http.ResponseWriter, request *http.Request) {}
+
+	serve := func(a *Auth, role Role, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rec := httptest.NewRecorder()
+		a.Require(role, handler)(rec, req)
+
+		return rec.Code
+	}
+
+	// чтение без cfg.ReadAuth открыто
+	a := Initialize(&config.Config{AgentKeysFile: keys})
+	assert.Equal(t, http.StatusOK, serve(a, RoleReader, ""))
+	assert.Equal(t, http.StatusUnauthorized, serve(a, RoleAdmin, ""))
+	assert.Equal(t, http.StatusForbidden, serve(a, RoleAdmin, "token-1"))
+	assert.Equal(t, http.StatusForbidden, serve(a, RoleAdmin, "token-reader"))
+	assert.Equal(t, http.StatusOK, serve(a, RoleAdmin, "token-admin"))
+
+	a = Initialize(&config.Config{AgentKeysFile: keys, ReadAuth: true})
+	assert.Equal(t, http.StatusUnauthorized, serve(a, RoleReader, ""))
+	assert.Equal(t, http.StatusForbidden, serve(a, RoleReader, "token-1"))
+	assert.Equal(t, http.StatusOK, serve(a, RoleReader, "token-reader"))
+	assert.Equal(t, http.StatusOK, serve(a, RoleReader, "token-admin"))
+
+	// без файла ключей проверки выключены, но маршруты администратора закрыты
+	a = Initialize(&config.Config{ReadAuth: true})
+	assert.Equal(t, http.StatusOK, serve(a, RoleReader, ""))
+	assert.Equal(t, http.StatusOK, serve(a, RoleWriter, ""))
+	assert.Equal(t, http.StatusForbidden, serve(a, RoleAdmin, ""))
+	assert.Equal(t, http.StatusForbidden, serve(a, RoleAdmin, "token-admin"))
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	require.NoError(t, logger.Initialize())
+
+	a := Initialize(&config.Config{AgentKeysFile: writeKeys(t, "agents.json", `{"agents":[{"id":"agent-1","token":"token-1"}]}`)})
+
+	handler := func(ctx context.Context, req any) (any, error) {
+		agent, _ := Agent(ctx)
+		return agent, nil
+	}
+
+	update := &grpc.UnaryServerInfo{FullMethod: pb.Metrics_UpdateMetrics_FullMethodName}
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationMetadataKey, "Bearer "+token))
+	}
+
+	agent, err := a.UnaryInterceptor(withToken("token-1"), nil, update, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", agent)
+
+	_, err = a.UnaryInterceptor(context.Background(), nil, update, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = a.UnaryInterceptor(withToken("token-2"), nil, update, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = a.UnaryInterceptor(WithAgent(withToken("token-1"), "agent-2"), nil, update, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// чтение метрик без cfg.ReadAuth не требует токена
+	get := &grpc.UnaryServerInfo{FullMethod: pb.Metrics_GetMetric_FullMethodName}
+	_, err = a.UnaryInterceptor(context.Background(), nil, get, handler)
+	assert.NoError(t, err)
+
+	a = Initialize(&config.Config{AgentKeysFile: writeKeys(t, "agents.json", `{"agents":[{"id":"agent-1","token":"token-1"},{"id":"grafana","token":"token-reader","roles":["reader"]}]}`), ReadAuth: true})
+
+	_, err = a.UnaryInterceptor(context.Background(), nil, get, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = a.UnaryInterceptor(withToken("token-1"), nil, get, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	agent, err = a.UnaryInterceptor(withToken("token-reader"), nil, get, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "grafana", agent)
+
+	_, err = a.UnaryInterceptor(withToken("token-reader"), nil, update, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	broken := Initialize(&config.Config{AgentKeysFile: filepath.Join(t.TempDir(), "missing.json")})
+	_, err = broken.UnaryInterceptor(withToken("token-1"), nil, update, handler)
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthorizationMetadataKey - ключ метаданных gRPC с токеном агента, аналог заголовка Authorization
+const AuthorizationMetadataKey = "authorization"
+
+// methodRoles - роль, которая нужна для вызова метода gRPC
+var methodRoles = map[string]Role{
+	pb.Metrics_UpdateMetrics_FullMethodName: RoleWriter,
+	pb.Metrics_StreamMetrics_FullMethodName: RoleWriter,
+	pb.Metrics_GetMetric_FullMethodName:     RoleReader,
+	pb.Metrics_ListMetrics_FullMethodName:   RoleReader,
+}
+
+// grpcAuthenticate - контекст с идентификатором владельца токена или ошибка со статусом gRPC
+func (a *Auth) grpcAuthenticate(ctx context.Context, method string) (context.Context, error) {
+	role, ok := methodRoles[method]
+	if !ok || !a.required(role) {
+		return ctx, nil
+	}
+
+	var token string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(AuthorizationMetadataKey); len(values) > 0 {
+		token = bearerToken(values[0])
+	}
+
+	agent, err := a.authenticate(ctx, token, role)
+
+	switch {
+	case errors.Is(err, errNoKeys):
+		return nil, status.Error(codes.Internal, err.Error())
+	case errors.Is(err, errAgentMismatch), errors.Is(err, errForbiddenRole), errors.Is(err, errNoAdmin):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return WithAgent(ctx, agent), nil
+}
+
+// UnaryInterceptor - проверяет токен и роль на unary методах
+func (a *Auth) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.grpcAuthenticate(ctx, info.FullMethod)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+type agentStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *agentStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamInterceptor - проверяет токен и роль на stream методах
+func (a *Auth) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.grpcAuthenticate(ss.Context(), info.FullMethod)
+
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &agentStream{ServerStream: ss, ctx: ctx})
+}
@@ -0,0 +1,197 @@
+package bodyhash
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/security"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
+	"github.com/dglazkoff/go-metrics/internal/signature"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// HashMetadataKey - ключ метаданных gRPC с подписью сообщения, аналог заголовка HashSHA256
+const HashMetadataKey = "hashsha256"
+
+// unsignedMethods - проверка здоровья сервера, которую gRPC клиент агента выполняет сам, без подписи
+var unsignedMethods = map[string]bool{
+	healthpb.Health_Check_FullMethodName: true,
+	healthpb.Health_Watch_FullMethodName: true,
+}
+
+/*
+sign - подпись protobuf сообщения ключом secretKey вместе с timestamp и nonce.
+Сообщение сериализуется детерминированно, иначе map с метками метрики давала бы разный порядок байт у агента и сервера
+*/
+func sign(m proto.Message, secretKey, timestamp, nonce string) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(signature.Sign(secretKey, timestamp, nonce, data)), nil
+}
+
+// first - первое значение метаданных key
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+/*
+verify - сверяет подпись из метаданных с сообщением, временем отправки и nonce, как и в HTTP запрос без подписи пропускается.
+В режиме strict-security запрос без подписи отклоняется с Unauthenticated. Повторный или устаревший запрос - Unauthenticated
+*/
+func (bodyHash *BodyHash) verify(ctx context.Context, req any) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(HashMetadataKey)
+	timestamp := first(md, signature.TimestampMetadataKey)
+	nonce := first(md, signature.NonceMetadataKey)
+
+	if len(values) == 0 || values[0] == "" {
+		if bodyHash.cfg.StrictSecurity {
+			security.Reject(security.ReasonMissingHash)
+			return status.Error(codes.Unauthenticated, "missing hash")
+		}
+
+		return nil
+	}
+
+	m, ok := req.(proto.Message)
+	if !ok {
+		return status.Error(codes.Internal, "request is not a protobuf message")
+	}
+
+	hash, err := sign(m, bodyHash.cfg.SecretKey, timestamp, nonce)
+	if err != nil {
+		return status.Errorf(codes.Internal, "error while serialize request: %v", err)
+	}
+
+	if !hmac.Equal([]byte(hash), []byte(values[0])) {
+		logger.Log.Debug("Wrong hash")
+		security.Reject(security.ReasonWrongHash)
+		return status.Error(codes.InvalidArgument, "wrong hash")
+	}
+
+	logger.Log.Debug("Right hash")
+
+	// у gRPC нет GET, запрос без времени и nonce в режиме strict-security отклоняется для всех методов
+	if err = bodyHash.checkReplay(timestamp, nonce, ""); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return nil
+}
+
+// UnaryInterceptor - проверяет подпись запроса и подписывает ответ в заголовке hashsha256
+func (bodyHash *BodyHash) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if bodyHash.cfg.SecretKey == "" || unsignedMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	if err := bodyHash.verify(ctx, req); err != nil {
+		return nil, err
+	}
+
+	resp, err := handler(ctx, req)
+
+	if m, ok := resp.(proto.Message); ok && err == nil {
+		if hash, signErr := sign(m, bodyHash.cfg.SecretKey, "", ""); signErr == nil {
+			if headerErr := grpc.SetHeader(ctx, metadata.Pairs(HashMetadataKey, hash)); headerErr != nil {
+				logger.Log.Debug("Error while set hash header: ", headerErr)
+			}
+		}
+	}
+
+	return resp, err
+}
+
+/*
+verifyMessage - сверяет подпись пачки стрима StreamMetrics, переданную в самой пачке вместе с временем отправки и nonce.
+Подписывается пачка с пустыми полями подписи. Повторная или устаревшая пачка - Unauthenticated
+*/
+func (bodyHash *BodyHash) verifyMessage(req *pb.UpdateMetricsRequest) error {
+	unsigned := proto.Clone(req).(*pb.UpdateMetricsRequest)
+	unsigned.Hash, unsigned.Timestamp, unsigned.Nonce = "", "", ""
+
+	hash, err := sign(unsigned, bodyHash.cfg.SecretKey, req.Timestamp, req.Nonce)
+	if err != nil {
+		return status.Errorf(codes.Internal, "error while serialize request: %v", err)
+	}
+
+	if !hmac.Equal([]byte(hash), []byte(req.Hash)) {
+		logger.Log.Debug("Wrong stream message hash")
+		security.Reject(security.ReasonWrongHash)
+		return status.Error(codes.InvalidArgument, "wrong hash")
+	}
+
+	if err = bodyHash.checkReplay(req.Timestamp, req.Nonce, ""); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return nil
+}
+
+/*
+hashStream - проверяет подпись каждого сообщения стрима. Пачка StreamMetrics с подписью в самой пачке сверяется с ней,
+иначе подпись из метаданных сверяется с первым сообщением. Следующие сообщения без своей подписи
+в подписанном стриме и в режиме strict-security отклоняются с Unauthenticated
+*/
+type hashStream struct {
+	grpc.ServerStream
+	bodyHash *BodyHash
+	received bool
+	signed   bool
+}
+
+func (s *hashStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	initial := !s.received
+	s.received = true
+
+	if req, ok := m.(*pb.UpdateMetricsRequest); ok && req.Hash != "" {
+		s.signed = true
+		return s.bodyHash.verifyMessage(req)
+	}
+
+	if !initial {
+		if s.signed || s.bodyHash.cfg.StrictSecurity {
+			security.Reject(security.ReasonMissingHash)
+			return status.Error(codes.Unauthenticated, "stream message is not signed")
+		}
+
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(s.Context())
+	s.signed = first(md, HashMetadataKey) != ""
+
+	return s.bodyHash.verify(s.Context(), m)
+}
+
+/*
+StreamInterceptor - проверяет подпись запроса stream вызова.
+Метаданные передаются один раз на весь стрим: для ListMetrics подпись из них сверяется со всем запросом,
+а пачки StreamMetrics подписываются каждая отдельно, см. agent client.SignStreamRequest
+*/
+func (bodyHash *BodyHash) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if bodyHash.cfg.SecretKey == "" || unsignedMethods[info.FullMethod] {
+		return handler(srv, ss)
+	}
+
+	return handler(srv, &hashStream{ServerStream: ss, bodyHash: bodyHash})
+}
@@ -0,0 +1,261 @@
+package bodyhash
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/security"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
+	"github.com/dglazkoff/go-metrics/internal/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// testStream - стрим, который отдает сообщения msgs по очереди, а когда они закончились - msg
+type testStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	msg  *pb.UpdateMetricsRequest
+	msgs []*pb.UpdateMetricsRequest
+}
+
+func (s *testStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testStream) RecvMsg(m any) error {
+	msg := s.msg
+	if len(s.msgs) > 0 {
+		msg, s.msgs = s.msgs[0], s.msgs[1:]
+	}
+
+	proto.Merge(m.(*pb.UpdateMetricsRequest), msg)
+	return nil
+}
+
+// signedBatch - пачка стрима с подписью, временем отправки и nonce в самой пачке
+func signedBatch(t *testing.T, delta int64, nonce string) *pb.UpdateMetricsRequest {
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "PollCount", Type: pb.Metric_Counter, MetricValue: &pb.Metric_Delta{Delta: delta}}}}
+	timestamp := signature.Timestamp(time.Now())
+
+	hash, err := sign(req, "secret", timestamp, nonce)
+	require.NoError(t, err)
+
+	req.Hash, req.Timestamp, req.Nonce = hash, timestamp, nonce
+	return req
+}
+
+func TestBodyHash_UnaryInterceptor(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	bh := Initialize(&config.Config{SecretKey: "secret"})
+
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "HeapAlloc", Type: pb.Metric_Gauge, MetricValue: &pb.Metric_Value{Value: 1}, Labels: map[string]string{"host": "a", "dc": "b", "team": "c"}},
+	}}
+	hash, err := sign(req, "secret", "", "")
+	require.NoError(t, err)
+
+	handler := func(ctx context.Context, req any) (any, error) {
+		return &pb.UpdateMetricsResponse{Accepted: 1}, nil
+	}
+
+	tests := []struct {
+		name string
+		md   metadata.MD
+		code codes.Code
+	}{
+		{name: "right hash", md: metadata.Pairs(HashMetadataKey, hash), code: codes.OK},
+		{name: "without hash", md: metadata.MD{}, code: codes.OK},
+		{name: "wrong hash", md: metadata.Pairs(HashMetadataKey, "wrong"), code: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+
+			_, err := bh.UnaryInterceptor(ctx, req, &grpc.UnaryServerInfo{}, handler)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestBodyHash_StreamInterceptor(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	bh := Initialize(&config.Config{SecretKey: "secret"})
+
+	msg := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "PollCount", Type: pb.Metric_Counter, MetricValue: &pb.Metric_Delta{Delta: 1}}}}
+	hash, err := sign(msg, "secret", "", "")
+	require.NoError(t, err)
+
+	handler := func(srv any, ss grpc.ServerStream) error {
+		return ss.RecvMsg(&pb.UpdateMetricsRequest{})
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(HashMetadataKey, hash))
+	err = bh.StreamInterceptor(nil, &testStream{ctx: ctx, msg: msg}, &grpc.StreamServerInfo{}, handler)
+	assert.NoError(t, err)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(HashMetadataKey, "wrong"))
+	err = bh.StreamInterceptor(nil, &testStream{ctx: ctx, msg: msg}, &grpc.StreamServerInfo{}, handler)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	receiveTwice := func(srv any, ss grpc.ServerStream) error {
+		if err := ss.RecvMsg(&pb.UpdateMetricsRequest{}); err != nil {
+			return err
+		}
+
+		return ss.RecvMsg(&pb.UpdateMetricsRequest{})
+	}
+
+	// подпись из метаданных относится только к первому сообщению, следующее сообщение без своей подписи отклоняется
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(HashMetadataKey, hash))
+	err = bh.StreamInterceptor(nil, &testStream{ctx: ctx, msg: msg}, &grpc.StreamServerInfo{}, receiveTwice)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// стрим без подписи вне режима strict-security принимается целиком, как и запрос без подписи
+	err = bh.StreamInterceptor(nil, &testStream{ctx: context.Background(), msg: msg}, &grpc.StreamServerInfo{}, receiveTwice)
+	assert.NoError(t, err)
+}
+
+func TestBodyHash_StreamSignedBatches(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	bh := Initialize(&config.Config{SecretKey: "secret"})
+
+	tampered := signedBatch(t, 1, "tampered")
+	tampered.Metrics[0].MetricValue = &pb.Metric_Delta{Delta: 100}
+
+	tests := []struct {
+		name string
+		msgs []*pb.UpdateMetricsRequest
+		code codes.Code
+	}{
+		{
+			name: "every batch is signed",
+			msgs: []*pb.UpdateMetricsRequest{signedBatch(t, 1, "first"), signedBatch(t, 2, "second"), signedBatch(t, 3, "third")},
+			code: codes.OK,
+		},
+		{
+			name: "unsigned batch after signed",
+			msgs: []*pb.UpdateMetricsRequest{signedBatch(t, 1, "unsigned-1"), {Metrics: []*pb.Metric{{Id: "PollCount", Type: pb.Metric_Counter, MetricValue: &pb.Metric_Delta{Delta: 1}}}}},
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "tampered batch",
+			msgs: []*pb.UpdateMetricsRequest{signedBatch(t, 1, "tampered-1"), tampered},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "replayed batch",
+			msgs: []*pb.UpdateMetricsRequest{signedBatch(t, 1, "replayed"), signedBatch(t, 1, "replayed")},
+			code: codes.Unauthenticated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiveAll := func(srv any, ss grpc.ServerStream) error {
+				for range tt.msgs {
+					if err := ss.RecvMsg(&pb.UpdateMetricsRequest{}); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			}
+
+			err := bh.StreamInterceptor(nil, &testStream{ctx: context.Background(), msgs: tt.msgs}, &grpc.StreamServerInfo{}, receiveAll)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestBodyHash_Replay(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	bh := Initialize(&config.Config{SecretKey: "secret"})
+
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "PollCount", Type: pb.Metric_Counter, MetricValue: &pb.Metric_Delta{Delta: 1}}}}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return &pb.UpdateMetricsResponse{}, nil
+	}
+
+	timestamp := signature.Timestamp(time.Now())
+	hash, err := sign(req, "secret", timestamp, "nonce")
+	require.NoError(t, err)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		HashMetadataKey, hash,
+		signature.TimestampMetadataKey, timestamp,
+		signature.NonceMetadataKey, "nonce",
+	))
+
+	_, err = bh.UnaryInterceptor(ctx, req, &grpc.UnaryServerInfo{}, handler)
+	assert.NoError(t, err)
+
+	_, err = bh.UnaryInterceptor(ctx, req, &grpc.UnaryServerInfo{}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestBodyHash_StrictSecurity(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "PollCount", Type: pb.Metric_Counter, MetricValue: &pb.Metric_Delta{Delta: 1}}}}
+	unaryHandler := func(ctx context.Context, req any) (any, error) {
+		return &pb.UpdateMetricsResponse{}, nil
+	}
+	streamHandler := func(srv any, ss grpc.ServerStream) error {
+		return ss.RecvMsg(&pb.UpdateMetricsRequest{})
+	}
+
+	// без strict-security запрос без подписи пропускается
+	bh := Initialize(&config.Config{SecretKey: "secret"})
+	_, err = bh.UnaryInterceptor(context.Background(), req, &grpc.UnaryServerInfo{}, unaryHandler)
+	assert.NoError(t, err)
+
+	strict := Initialize(&config.Config{SecretKey: "secret", StrictSecurity: true})
+	before := security.Count(security.ReasonMissingHash)
+
+	_, err = strict.UnaryInterceptor(context.Background(), req, &grpc.UnaryServerInfo{}, unaryHandler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	err = strict.StreamInterceptor(nil, &testStream{ctx: context.Background(), msg: req}, &grpc.StreamServerInfo{}, streamHandler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	assert.Equal(t, before+2, security.Count(security.ReasonMissingHash))
+
+	// подпись без времени и nonce можно повторить, в режиме strict-security она отклоняется
+	hash, err := sign(req, "secret", "", "")
+	require.NoError(t, err)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(HashMetadataKey, hash))
+	_, err = strict.UnaryInterceptor(ctx, req, &grpc.UnaryServerInfo{}, unaryHandler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	timestamp := signature.Timestamp(time.Now())
+	hash, err = sign(req, "secret", timestamp, "nonce")
+	require.NoError(t, err)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		HashMetadataKey, hash,
+		signature.TimestampMetadataKey, timestamp,
+		signature.NonceMetadataKey, "nonce",
+	))
+	_, err = strict.UnaryInterceptor(ctx, req, &grpc.UnaryServerInfo{}, unaryHandler)
+	assert.NoError(t, err)
+}
@@ -6,15 +6,20 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/security"
 	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/signature"
 )
 
 type BodyHash struct {
-	cfg *config.Config
+	cfg    *config.Config
+	nonces *nonceCache
 }
 
 type hashWriter struct {
@@ -48,9 +53,50 @@ func (h *hashWriter) WriteHeader(statusCode int) {
 }
 
 func Initialize(cfg *config.Config) *BodyHash {
-	return &BodyHash{cfg}
+	window := cfg.ReplayWindow
+	if window <= 0 {
+		window = config.DefaultReplayWindow
+	}
+
+	size := cfg.NonceCacheSize
+	if size <= 0 {
+		size = config.DefaultNonceCacheSize
+	}
+
+	return &BodyHash{cfg: cfg, nonces: newNonceCache(time.Duration(window)*time.Second, size)}
+}
+
+/*
+checkReplay - отклоняет повтор подписанного запроса по времени отправки и nonce.
+Запрос, подписанный без времени и nonce, пропускается, но в режиме strict-security, кроме GET и HEAD, отклоняется
+*/
+func (bodyHash *BodyHash) checkReplay(timestamp, nonce, method string) error {
+	if timestamp == "" && nonce == "" {
+		if bodyHash.cfg.StrictSecurity && method != http.MethodGet && method != http.MethodHead {
+			security.Reject(security.ReasonMissingNonce)
+			return errMissingNonce
+		}
+
+		return nil
+	}
+
+	err := bodyHash.nonces.check(timestamp, nonce, time.Now())
+
+	switch {
+	case errors.Is(err, errReplayedNonce):
+		security.Reject(security.ReasonReplayedNonce)
+	case err != nil:
+		security.Reject(security.ReasonStaleRequest)
+	}
+
+	return err
 }
 
+/*
+BodyHash - проверяет подпись тела запроса вместе с X-Timestamp и X-Nonce в заголовке HashSHA256 и подписывает ответ.
+Устаревший или повторный подписанный запрос отклоняется с 401.
+Запрос без подписи пропускается, но в режиме strict-security запросы, кроме GET и HEAD, без подписи отклоняются с 401
+*/
 func (bodyHash *BodyHash) BodyHash(handler http.HandlerFunc) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		if bodyHash.cfg.SecretKey == "" {
@@ -69,20 +115,38 @@ func (bodyHash *BodyHash) BodyHash(handler http.HandlerFunc) http.HandlerFunc {
 			if err != io.EOF {
 				logger.Log.Debug("Error while reading the body: ", err)
 			}
+
+			if bodyHash.cfg.StrictSecurity {
+				security.Reject(security.ReasonUnreadable)
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
 			// в тестах при отправке value не приходит хэш в заголовке
 			// хотя в самом задание не указано то, что value не надо обрабатывать на хэш
 		} else if requestHash != "" {
-			h := hmac.New(sha256.New, []byte(bodyHash.cfg.SecretKey))
-			h.Write(body)
-			hSum := h.Sum(nil)
+			timestamp := request.Header.Get(signature.TimestampHeader)
+			nonce := request.Header.Get(signature.NonceHeader)
+			hSum := signature.Sign(bodyHash.cfg.SecretKey, timestamp, nonce, body)
 
-			if hex.EncodeToString(hSum) != requestHash {
+			if !hmac.Equal([]byte(hex.EncodeToString(hSum)), []byte(requestHash)) {
 				logger.Log.Debug("Wrong hash")
+				security.Reject(security.ReasonWrongHash)
 				writer.WriteHeader(http.StatusBadRequest)
 				return
 			}
 
 			logger.Log.Debug("Right hash")
+
+			// nonce проверяется только после подписи, иначе неподписанными запросами можно было бы заполнить кэш
+			if err = bodyHash.checkReplay(timestamp, nonce, request.Method); err != nil {
+				logger.Log.Debug("Replay check failed: ", err)
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		} else if bodyHash.cfg.StrictSecurity && request.Method != http.MethodGet && request.Method != http.MethodHead {
+			security.Reject(security.ReasonMissingHash)
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
 		}
 
 		hw := newHashWriter(writer, bodyHash.cfg)
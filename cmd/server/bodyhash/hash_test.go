@@ -2,13 +2,18 @@ package bodyhash
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/security"
 	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/signature"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -101,3 +106,147 @@ func TestHashHandle_Response(t *testing.T) {
 	assert.Equal(t, "5a7305380fe3259f1de01206f83366b58b52c9b9616c0555a155eef3927dc2ca", resp.Header.Get("HashSHA256"))
 	assert.Equal(t, []byte("Hidden body"), decodedBody)
 }
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("read error")
+}
+
+func TestHashHandle_StrictSecurity(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		strict       bool
+		method       string
+		hash         string
+		body         io.Reader
+		resultStatus int
+		reason       string
+	}{
+		{name: "missing hash", method: http.MethodPost, body: bytes.NewBufferString("Hidden body"), resultStatus: http.StatusOK},
+		{name: "strict missing hash", strict: true, method: http.MethodPost, body: bytes.NewBufferString("Hidden body"), resultStatus: http.StatusUnauthorized, reason: security.ReasonMissingHash},
+		{name: "strict get without hash", strict: true, method: http.MethodGet, body: http.NoBody, resultStatus: http.StatusOK},
+		{
+			// подпись только тела можно повторить, поэтому в режиме strict-security нужны время и nonce
+			name:         "strict right hash without nonce",
+			strict:       true,
+			method:       http.MethodPost,
+			hash:         "5a7305380fe3259f1de01206f83366b58b52c9b9616c0555a155eef3927dc2ca",
+			body:         bytes.NewBufferString("Hidden body"),
+			resultStatus: http.StatusUnauthorized,
+			reason:       security.ReasonMissingNonce,
+		},
+		{
+			name:         "strict wrong hash",
+			strict:       true,
+			method:       http.MethodPost,
+			hash:         "5a7305380fe3259f1de01206f83366b58b52c9b9616c0555a155eef3927dc2cb",
+			body:         bytes.NewBufferString("Hidden body"),
+			resultStatus: http.StatusBadRequest,
+			reason:       security.ReasonWrongHash,
+		},
+		{name: "unreadable body", method: http.MethodPost, body: errReader{}, resultStatus: http.StatusOK},
+		{name: "strict unreadable body", strict: true, method: http.MethodPost, body: errReader{}, resultStatus: http.StatusBadRequest, reason: security.ReasonUnreadable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bh := Initialize(&config.Config{SecretKey: "secret_123", StrictSecurity: tt.strict})
+
+			handler := bh.BodyHash(func(w http.ResponseWriter, r *http.Request) {
+			})
+
+			req := httptest.NewRequest(tt.method, "/", tt.body)
+			if tt.hash != "" {
+				req.Header.Set("HashSHA256", tt.hash)
+			}
+
+			before := security.Count(tt.reason)
+
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.resultStatus, resp.StatusCode)
+			if tt.reason != "" {
+				assert.Equal(t, before+1, security.Count(tt.reason))
+			}
+		})
+	}
+}
+
+func TestHashHandle_Replay(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	bh := Initialize(&config.Config{SecretKey: "secret_123", ReplayWindow: 60, StrictSecurity: true})
+
+	handler := bh.BodyHash(func(w http.ResponseWriter, r *http.Request) {})
+
+	send := func(timestamp, nonce, hash string) int {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString("Hidden body"))
+		req.Header.Set("HashSHA256", hash)
+		req.Header.Set(signature.TimestampHeader, timestamp)
+		req.Header.Set(signature.NonceHeader, nonce)
+
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		return w.Code
+	}
+
+	sign := func(timestamp, nonce string) string {
+		return hex.EncodeToString(signature.Sign("secret_123", timestamp, nonce, []byte("Hidden body")))
+	}
+
+	now := signature.Timestamp(time.Now())
+	assert.Equal(t, http.StatusOK, send(now, "nonce-1", sign(now, "nonce-1")))
+
+	before := security.Count(security.ReasonReplayedNonce)
+	assert.Equal(t, http.StatusUnauthorized, send(now, "nonce-1", sign(now, "nonce-1")))
+	assert.Equal(t, before+1, security.Count(security.ReasonReplayedNonce))
+
+	// время и nonce подписаны вместе с телом, заменить их в перехваченном запросе нельзя
+	assert.Equal(t, http.StatusBadRequest, send(now, "nonce-2", sign(now, "nonce-1")))
+
+	stale := signature.Timestamp(time.Now().Add(-2 * time.Minute))
+	before = security.Count(security.ReasonStaleRequest)
+	assert.Equal(t, http.StatusUnauthorized, send(stale, "nonce-3", sign(stale, "nonce-3")))
+	assert.Equal(t, before+1, security.Count(security.ReasonStaleRequest))
+
+	future := signature.Timestamp(time.Now().Add(2 * time.Minute))
+	assert.Equal(t, http.StatusUnauthorized, send(future, "nonce-4", sign(future, "nonce-4")))
+}
+
+func TestNonceCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := newNonceCache(time.Minute, 2)
+
+	at := func(offset time.Duration) string {
+		return signature.Timestamp(now.Add(offset))
+	}
+
+	require.NoError(t, cache.check(at(-30*time.Second), "a", now))
+	require.NoError(t, cache.check(at(-20*time.Second), "b", now))
+	assert.ErrorIs(t, cache.check(at(-20*time.Second), "b", now), errReplayedNonce)
+
+	// кэш заполнен: вытесняется a, и запросы не новее a больше не принимаются
+	require.NoError(t, cache.check(at(-10*time.Second), "c", now))
+	assert.Len(t, cache.seen, 2)
+	assert.ErrorIs(t, cache.check(at(-30*time.Second), "a", now), errStaleRequest)
+	assert.ErrorIs(t, cache.check(at(-40*time.Second), "d", now), errStaleRequest)
+	require.NoError(t, cache.check(at(-25*time.Second), "d", now))
+
+	// nonce за пределами окна удаляются
+	later := now.Add(time.Minute)
+	require.NoError(t, cache.check(signature.Timestamp(later), "e", later))
+	assert.Len(t, cache.seen, 1)
+
+	assert.ErrorIs(t, cache.check("not a timestamp", "f", now), errStaleRequest)
+	assert.ErrorIs(t, cache.check(at(0), "", now), errStaleRequest)
+}
@@ -0,0 +1,90 @@
+package bodyhash
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dglazkoff/go-metrics/internal/signature"
+)
+
+var (
+	errMissingNonce  = errors.New("signed request without timestamp and nonce")
+	errStaleRequest  = errors.New("request timestamp is out of replay window")
+	errReplayedNonce = errors.New("request nonce was already used")
+)
+
+type seenNonce struct {
+	nonce     string
+	timestamp time.Time
+}
+
+/*
+nonceCache - nonce подписанных запросов за окно window, не больше size штук.
+Когда кэш заполнен, вытесняется самый старый nonce, а запросы со временем не позже вытесненного
+отклоняются как устаревшие - иначе вытесненный nonce можно было бы повторить
+*/
+type nonceCache struct {
+	mu     sync.Mutex
+	window time.Duration
+	size   int
+	seen   map[string]*list.Element
+	order  *list.List
+	floor  time.Time
+}
+
+func newNonceCache(window time.Duration, size int) *nonceCache {
+	return &nonceCache{
+		window: window,
+		size:   size,
+		seen:   make(map[string]*list.Element, size),
+		order:  list.New(),
+	}
+}
+
+// remove - удаляет самый старый nonce
+func (c *nonceCache) remove() time.Time {
+	oldest := c.order.Remove(c.order.Front()).(seenNonce)
+	delete(c.seen, oldest.nonce)
+
+	return oldest.timestamp
+}
+
+// check - запоминает nonce запроса, отправленного в timestamp, или возвращает ошибку для устаревшего или повторного запроса
+func (c *nonceCache) check(timestamp, nonce string, now time.Time) error {
+	t, err := signature.ParseTimestamp(timestamp)
+	if err != nil || nonce == "" {
+		return errStaleRequest
+	}
+
+	if t.Before(now.Add(-c.window)) || t.After(now.Add(c.window)) {
+		return errStaleRequest
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !t.After(c.floor) {
+		return errStaleRequest
+	}
+
+	if _, ok := c.seen[nonce]; ok {
+		return errReplayedNonce
+	}
+
+	// nonce за пределами окна больше не нужны, запросы с таким временем отклоняются по времени
+	for c.order.Len() > 0 && c.order.Front().Value.(seenNonce).timestamp.Before(now.Add(-c.window)) {
+		c.remove()
+	}
+
+	for c.order.Len() >= c.size {
+		if evicted := c.remove(); evicted.After(c.floor) {
+			c.floor = evicted
+		}
+	}
+
+	c.seen[nonce] = c.order.PushBack(seenNonce{nonce: nonce, timestamp: t})
+
+	return nil
+}
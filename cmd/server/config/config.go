@@ -2,23 +2,125 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/dglazkoff/go-metrics/internal/logger"
 )
 
 type Config struct {
-	RunAddr         string `json:"run_addr"`
-	StoreInterval   int    `json:"store_interval"`
-	FileStoragePath string `json:"file_storage_path"`
-	IsRestore       bool   `json:"is_restore"`
-	DatabaseDSN     string `json:"database_dsn"`
-	SecretKey       string `json:"secret_key"`
-	CryptoKey       string `json:"crypto_key"`
-	TrustedSubnet   string `json:"trusted_subnet"`
-	IsGRPC          bool   `json:"is_grpc"`
+	RunAddr         string   `json:"run_addr"`
+	StoreInterval   int      `json:"store_interval"`
+	FileStoragePath string   `json:"file_storage_path"`
+	IsRestore       bool     `json:"is_restore"`
+	DatabaseDSN     string   `json:"database_dsn"`
+	SecretKey       string   `json:"secret_key"`
+	ReplayWindow    int      `json:"replay_window"`
+	NonceCacheSize  int      `json:"nonce_cache_size"`
+	CryptoKey       string   `json:"crypto_key"`
+	CryptoKeyDir    string   `json:"crypto_key_dir"`
+	CryptoLegacy    bool     `json:"crypto_legacy"`
+	StrictSecurity  bool     `json:"strict_security"`
+	AgentKeysFile   string   `json:"agent_keys_file"`
+	ReadAuth        bool     `json:"read_auth"`
+	TrustedSubnet   string   `json:"trusted_subnet"`
+	TrustedProxies  string   `json:"trusted_proxies"`
+	IsGRPC          bool     `json:"is_grpc"`
+	GRPCAddr        string   `json:"grpc_addr"`
+	IsHistory       bool     `json:"is_history"`
+	HistorySize     int      `json:"history_size"`
+	AlertRulesFile  string   `json:"alert_rules_file"`
+	AlertInterval   int      `json:"alert_interval"`
+	AlertWebhooks   []string `json:"alert_webhooks"`
+	TLSCert         string   `json:"tls_cert"`
+	TLSKey          string   `json:"tls_key"`
+	// TLSClientCA - CA клиентских сертификатов, если задан - агенты обязаны предъявить сертификат (mTLS)
+	TLSClientCA string `json:"tls_client_ca"`
+}
+
+// DefaultHistorySize - количество хранимых в памяти значений на одну метрику в режиме истории
+const DefaultHistorySize = 1000
+
+// DefaultAlertInterval - частота проверки правил алертов в секундах
+const DefaultAlertInterval = 10
+
+// DefaultReplayWindow - в пределах скольких секунд от времени сервера принимается подписанный запрос
+const DefaultReplayWindow = 300
+
+// DefaultNonceCacheSize - сколько nonce подписанных запросов хранится для защиты от повторов
+const DefaultNonceCacheSize = 100000
+
+// splitList - разбирает список значений через запятую, пустые значения пропускаются
+func splitList(value string) []string {
+	var list []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+/*
+ParseNetworks - разбирает список подсетей IPv4 и IPv6 через запятую, например "10.0.0.0/8,fd00::/8".
+Адрес без маски - подсеть из одного адреса
+*/
+func ParseNetworks(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	var errs []error
+
+	for _, item := range splitList(value) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				errs = append(errs, fmt.Errorf("invalid ip address %q", item))
+				continue
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		networks = append(networks, network)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return networks, nil
+}
+
+// Validate - проверяет значения, ошибку в которых иначе заметили бы только на первых запросах
+func (c *Config) Validate() error {
+	if _, err := ParseNetworks(c.TrustedSubnet); err != nil {
+		return fmt.Errorf("invalid trusted subnet: %w", err)
+	}
+
+	if _, err := ParseNetworks(c.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	return nil
 }
 
 func readConfigFile(configFile string, config *Config) {
@@ -61,22 +163,91 @@ func readConfigFile(configFile string, config *Config) {
 		config.SecretKey = fileConfig.SecretKey
 	}
 
+	if config.ReplayWindow == 0 && fileConfig.ReplayWindow != 0 {
+		config.ReplayWindow = fileConfig.ReplayWindow
+	}
+
+	if config.NonceCacheSize == 0 && fileConfig.NonceCacheSize != 0 {
+		config.NonceCacheSize = fileConfig.NonceCacheSize
+	}
+
 	if config.CryptoKey == "" && fileConfig.CryptoKey != "" {
 		config.CryptoKey = fileConfig.CryptoKey
 	}
 
+	if config.CryptoKeyDir == "" && fileConfig.CryptoKeyDir != "" {
+		config.CryptoKeyDir = fileConfig.CryptoKeyDir
+	}
+
+	if !config.CryptoLegacy && fileConfig.CryptoLegacy {
+		config.CryptoLegacy = fileConfig.CryptoLegacy
+	}
+
+	if !config.StrictSecurity && fileConfig.StrictSecurity {
+		config.StrictSecurity = fileConfig.StrictSecurity
+	}
+
+	if config.AgentKeysFile == "" && fileConfig.AgentKeysFile != "" {
+		config.AgentKeysFile = fileConfig.AgentKeysFile
+	}
+
+	if !config.ReadAuth && fileConfig.ReadAuth {
+		config.ReadAuth = fileConfig.ReadAuth
+	}
+
 	if config.TrustedSubnet == "" && fileConfig.TrustedSubnet != "" {
 		config.TrustedSubnet = fileConfig.TrustedSubnet
 	}
 
+	if config.TrustedProxies == "" && fileConfig.TrustedProxies != "" {
+		config.TrustedProxies = fileConfig.TrustedProxies
+	}
+
 	if !config.IsGRPC && fileConfig.IsGRPC {
 		config.IsGRPC = fileConfig.IsGRPC
 	}
+
+	if config.GRPCAddr == "" && fileConfig.GRPCAddr != "" {
+		config.GRPCAddr = fileConfig.GRPCAddr
+	}
+
+	if !config.IsHistory && fileConfig.IsHistory {
+		config.IsHistory = fileConfig.IsHistory
+	}
+
+	if config.HistorySize == 0 && fileConfig.HistorySize != 0 {
+		config.HistorySize = fileConfig.HistorySize
+	}
+
+	if config.AlertRulesFile == "" && fileConfig.AlertRulesFile != "" {
+		config.AlertRulesFile = fileConfig.AlertRulesFile
+	}
+
+	if config.AlertInterval == 0 && fileConfig.AlertInterval != 0 {
+		config.AlertInterval = fileConfig.AlertInterval
+	}
+
+	if len(config.AlertWebhooks) == 0 && len(fileConfig.AlertWebhooks) != 0 {
+		config.AlertWebhooks = fileConfig.AlertWebhooks
+	}
+
+	if config.TLSCert == "" && fileConfig.TLSCert != "" {
+		config.TLSCert = fileConfig.TLSCert
+	}
+
+	if config.TLSKey == "" && fileConfig.TLSKey != "" {
+		config.TLSKey = fileConfig.TLSKey
+	}
+
+	if config.TLSClientCA == "" && fileConfig.TLSClientCA != "" {
+		config.TLSClientCA = fileConfig.TLSClientCA
+	}
 }
 
 func ParseConfig() Config {
 	cfg := Config{}
 	var configFile string
+	var alertWebhooks string
 
 	flag.StringVar(&cfg.RunAddr, "a", "", "address of the server")
 	flag.StringVar(&cfg.FileStoragePath, "f", "", "file path of metrics storage")
@@ -84,12 +255,31 @@ func ParseConfig() Config {
 	flag.BoolVar(&cfg.IsRestore, "r", false, "is restore saved metrics data")
 	flag.StringVar(&cfg.DatabaseDSN, "d", "", "database dsn string")
 	flag.StringVar(&cfg.SecretKey, "k", "", "ключ для кодирования запроса")
+	flag.IntVar(&cfg.ReplayWindow, "replay-window", 0, "в пределах скольких секунд от времени сервера принимается подписанный запрос")
+	flag.IntVar(&cfg.NonceCacheSize, "nonce-cache-size", 0, "сколько nonce подписанных запросов хранится для защиты от повторов")
 	flag.StringVar(&cfg.CryptoKey, "crypto-key", "", "путь до файла с приватным ключом")
-	flag.StringVar(&cfg.TrustedSubnet, "t", "", "строковое представление бесклассовой адресации (CIDR)")
+	flag.StringVar(&cfg.CryptoKeyDir, "crypto-key-dir", "", "директория с приватными ключами *.pem, идентификатор ключа - имя файла")
+	flag.BoolVar(&cfg.CryptoLegacy, "crypto-legacy", false, "принимать тело, зашифрованное старым форматом RSA PKCS1v15")
+	flag.BoolVar(&cfg.StrictSecurity, "strict-security", false, "отклонять запросы без подписи и тела, которые не удалось расшифровать")
+	flag.StringVar(&cfg.AgentKeysFile, "agent-keys", "", "путь до файла с токенами и ролями (YAML или JSON), если задан - запись метрик и профилирование только по токену")
+	flag.BoolVar(&cfg.ReadAuth, "read-auth", false, "чтение метрик только по токену с ролью reader")
+	flag.StringVar(&cfg.TrustedSubnet, "t", "", "доверенные подсети агентов (CIDR IPv4 и IPv6) через запятую")
+	flag.StringVar(&cfg.TrustedProxies, "trusted-proxies", "", "подсети прокси через запятую, только от них принимаются X-Real-IP и X-Forwarded-For")
 	flag.BoolVar(&cfg.IsGRPC, "grpc", false, "отправка метрик через gRPC")
+	flag.StringVar(&cfg.GRPCAddr, "grpc-addr", "", "адрес gRPC сервера, если задан - gRPC работает параллельно с HTTP")
+	flag.BoolVar(&cfg.IsHistory, "history", false, "хранить историю значений метрик")
+	flag.IntVar(&cfg.HistorySize, "history-size", 0, "количество хранимых в памяти значений на одну метрику")
+	flag.StringVar(&cfg.AlertRulesFile, "alert-rules", "", "путь до файла с правилами алертов (YAML или JSON)")
+	flag.IntVar(&cfg.AlertInterval, "alert-interval", 0, "частота проверки правил алертов в секундах")
+	flag.StringVar(&alertWebhooks, "alert-webhooks", "", "адреса webhook для уведомлений об алертах через запятую")
+	flag.StringVar(&cfg.TLSCert, "tls-cert", "", "путь до TLS сертификата сервера")
+	flag.StringVar(&cfg.TLSKey, "tls-key", "", "путь до приватного ключа TLS сертификата сервера")
+	flag.StringVar(&cfg.TLSClientCA, "tls-client-ca", "", "путь до CA для проверки клиентских сертификатов (mTLS)")
 	flag.StringVar(&configFile, "c", "cmd/server/config/config.json", "имя файла конфигурации")
 	flag.Parse()
 
+	cfg.AlertWebhooks = splitList(alertWebhooks)
+
 	readConfigFile(configFile, &cfg)
 
 	if runAddr := os.Getenv("ADDRESS"); runAddr != "" {
@@ -124,13 +314,129 @@ func ParseConfig() Config {
 		cfg.SecretKey = secretKey
 	}
 
+	if replayWindow := os.Getenv("REPLAY_WINDOW"); replayWindow != "" {
+		value, err := strconv.Atoi(replayWindow)
+
+		if err == nil {
+			cfg.ReplayWindow = value
+		}
+	}
+
+	if nonceCacheSize := os.Getenv("NONCE_CACHE_SIZE"); nonceCacheSize != "" {
+		value, err := strconv.Atoi(nonceCacheSize)
+
+		if err == nil {
+			cfg.NonceCacheSize = value
+		}
+	}
+
 	if cryptoKey := os.Getenv("CRYPTO_KEY"); cryptoKey != "" {
 		cfg.CryptoKey = cryptoKey
 	}
 
+	if cryptoKeyDir := os.Getenv("CRYPTO_KEY_DIR"); cryptoKeyDir != "" {
+		cfg.CryptoKeyDir = cryptoKeyDir
+	}
+
+	if cryptoLegacy := os.Getenv("CRYPTO_LEGACY"); cryptoLegacy != "" {
+		value, err := strconv.ParseBool(cryptoLegacy)
+
+		if err == nil {
+			cfg.CryptoLegacy = value
+		}
+	}
+
+	if strictSecurity := os.Getenv("STRICT_SECURITY"); strictSecurity != "" {
+		value, err := strconv.ParseBool(strictSecurity)
+
+		if err == nil {
+			cfg.StrictSecurity = value
+		}
+	}
+
+	if agentKeysFile := os.Getenv("AGENT_KEYS"); agentKeysFile != "" {
+		cfg.AgentKeysFile = agentKeysFile
+	}
+
+	if readAuth := os.Getenv("READ_AUTH"); readAuth != "" {
+		value, err := strconv.ParseBool(readAuth)
+
+		if err == nil {
+			cfg.ReadAuth = value
+		}
+	}
+
 	if trustedSubnet := os.Getenv("TRUSTED_SUBNET"); trustedSubnet != "" {
 		cfg.TrustedSubnet = trustedSubnet
 	}
 
+	if trustedProxies := os.Getenv("TRUSTED_PROXIES"); trustedProxies != "" {
+		cfg.TrustedProxies = trustedProxies
+	}
+
+	if grpcAddr := os.Getenv("GRPC_ADDRESS"); grpcAddr != "" {
+		cfg.GRPCAddr = grpcAddr
+	}
+
+	if isHistory := os.Getenv("HISTORY"); isHistory != "" {
+		value, err := strconv.ParseBool(isHistory)
+
+		if err == nil {
+			cfg.IsHistory = value
+		}
+	}
+
+	if historySize := os.Getenv("HISTORY_SIZE"); historySize != "" {
+		value, err := strconv.Atoi(historySize)
+
+		if err == nil {
+			cfg.HistorySize = value
+		}
+	}
+
+	if alertRulesFile := os.Getenv("ALERT_RULES"); alertRulesFile != "" {
+		cfg.AlertRulesFile = alertRulesFile
+	}
+
+	if alertInterval := os.Getenv("ALERT_INTERVAL"); alertInterval != "" {
+		value, err := strconv.Atoi(alertInterval)
+
+		if err == nil {
+			cfg.AlertInterval = value
+		}
+	}
+
+	if alertWebhooksEnv := os.Getenv("ALERT_WEBHOOKS"); alertWebhooksEnv != "" {
+		cfg.AlertWebhooks = splitList(alertWebhooksEnv)
+	}
+
+	if tlsCert := os.Getenv("TLS_CERT"); tlsCert != "" {
+		cfg.TLSCert = tlsCert
+	}
+
+	if tlsKey := os.Getenv("TLS_KEY"); tlsKey != "" {
+		cfg.TLSKey = tlsKey
+	}
+
+	if tlsClientCA := os.Getenv("TLS_CLIENT_CA"); tlsClientCA != "" {
+		cfg.TLSClientCA = tlsClientCA
+	}
+
+	if cfg.AlertInterval <= 0 {
+		cfg.AlertInterval = DefaultAlertInterval
+	}
+
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = DefaultHistorySize
+	}
+
+	if cfg.ReplayWindow <= 0 {
+		cfg.ReplayWindow = DefaultReplayWindow
+	}
+
+	if cfg.NonceCacheSize <= 0 {
+		cfg.NonceCacheSize = DefaultNonceCacheSize
+	}
+
 	return cfg
 }
@@ -29,6 +29,12 @@ func TestConfig_Flags(t *testing.T) {
 		"-t", "trusted_subnet",
 		"-c", "",
 		"-grpc",
+		"-grpc-addr", ":3200",
+		"-history",
+		"-history-size", "50",
+		"-alert-rules", "rules.yaml",
+		"-alert-interval", "30",
+		"-alert-webhooks", "http://hook-1, http://hook-2",
 	}
 
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
@@ -43,6 +49,12 @@ func TestConfig_Flags(t *testing.T) {
 	assert.Equal(t, "crypto", cfg.CryptoKey)
 	assert.Equal(t, "trusted_subnet", cfg.TrustedSubnet)
 	assert.Equal(t, true, cfg.IsGRPC)
+	assert.Equal(t, ":3200", cfg.GRPCAddr)
+	assert.Equal(t, true, cfg.IsHistory)
+	assert.Equal(t, 50, cfg.HistorySize)
+	assert.Equal(t, "rules.yaml", cfg.AlertRulesFile)
+	assert.Equal(t, 30, cfg.AlertInterval)
+	assert.Equal(t, []string{"http://hook-1", "http://hook-2"}, cfg.AlertWebhooks)
 }
 
 func TestConfig_SimpleEnv(t *testing.T) {
@@ -227,3 +239,31 @@ func TestParseConfig_File(t *testing.T) {
 	assert.Equal(t, "trusted_subnet_number", cfg.TrustedSubnet)
 	assert.Equal(t, true, cfg.IsGRPC)
 }
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks("192.168.31.0/24, fd00::/8,10.0.0.1,::1")
+	require.NoError(t, err)
+	require.Len(t, networks, 4)
+
+	assert.Equal(t, "192.168.31.0/24", networks[0].String())
+	assert.Equal(t, "fd00::/8", networks[1].String())
+	assert.Equal(t, "10.0.0.1/32", networks[2].String())
+	assert.Equal(t, "::1/128", networks[3].String())
+
+	networks, err = ParseNetworks("")
+	require.NoError(t, err)
+	assert.Empty(t, networks)
+
+	_, err = ParseNetworks("192.168.31.0/24,192.168.31,206")
+	assert.Error(t, err)
+
+	_, err = ParseNetworks("10.0.0.0/33")
+	assert.Error(t, err)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&Config{}).Validate())
+	assert.NoError(t, (&Config{TrustedSubnet: "192.168.31.0/24", TrustedProxies: "10.0.0.1"}).Validate())
+	assert.Error(t, (&Config{TrustedSubnet: "trusted_subnet"}).Validate())
+	assert.Error(t, (&Config{TrustedProxies: "proxy"}).Validate())
+}
@@ -4,36 +4,116 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
+	"errors"
 	"io"
 	"net/http"
-	"os"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/security"
+	"github.com/dglazkoff/go-metrics/internal/envelope"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 )
 
 type CryptoBody struct {
-	cfg *config.Config
+	cfg     *config.Config
+	keyring *Keyring
 }
 
+// Initialize - загружает ключи из cfg, при ошибке загрузки тела запросов передаются дальше без расшифровки
 func Initialize(cfg *config.Config) *CryptoBody {
-	return &CryptoBody{cfg}
+	keyring, err := LoadKeyring(cfg)
+
+	if err != nil {
+		logger.Log.Debug("Error while loading private keys: ", err)
+	}
+
+	return InitializeWithKeyring(cfg, keyring)
+}
+
+// InitializeWithKeyring - расшифровка ключами связки keyring, которую можно перечитать без перезапуска сервера
+func InitializeWithKeyring(cfg *config.Config, keyring *Keyring) *CryptoBody {
+	return &CryptoBody{cfg: cfg, keyring: keyring}
+}
+
+// decodeLegacy - расшифровывает старый формат: тело, зашифрованное RSA PKCS1v15 по сегментам размера ключа
+func decodeLegacy(privateKey *rsa.PrivateKey, body []byte) ([]byte, error) {
+	var decryptedBody bytes.Buffer
+	segmentSize := privateKey.Size()
+
+	for i := 0; i < len(body); i += segmentSize {
+		j := i + segmentSize
+		if j > len(body) {
+			j = len(body)
+		}
+		encryptedSegment := body[i:j]
+
+		decryptedSegment, err := rsa.DecryptPKCS1v15(rand.Reader, privateKey, encryptedSegment)
+		if err != nil {
+			return nil, err
+		}
+
+		decryptedBody.Write(decryptedSegment)
+	}
+
+	return decryptedBody.Bytes(), nil
 }
 
+// decrypt - расшифровывает тело первым подходящим ключом
+func decrypt(keys []*rsa.PrivateKey, body []byte, open func(*rsa.PrivateKey, []byte) ([]byte, error)) ([]byte, error) {
+	var errs []error
+
+	for _, privateKey := range keys {
+		decryptedBody, err := open(privateKey, body)
+
+		if err == nil {
+			return decryptedBody, nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// reject - учитывает отклоненный запрос и отвечает статусом statusCode
+func reject(writer http.ResponseWriter, reason string, statusCode int) {
+	security.Reject(reason)
+	writer.WriteHeader(statusCode)
+}
+
+/*
+CryptoDecode - расшифровывает тело запроса, зашифрованное конвертом envelope.
+Ключ выбирается по заголовку X-Key-ID, без заголовка пробуются все ключи связки.
+Поврежденный конверт или неизвестный идентификатор ключа отклоняются с 400. Старый формат PKCS1v15 по сегментам
+расшифровывается, только если включен cfg.CryptoLegacy. Незашифрованное тело передается дальше как есть,
+а в режиме strict-security отклоняется, так же как тело, которое не удалось расшифровать
+*/
 func (cryptoBody *CryptoBody) CryptoDecode(handler http.HandlerFunc) http.HandlerFunc {
+	strict := cryptoBody.cfg.StrictSecurity
+	// шифрование настроено - в режиме strict-security без ключей тело принимать нельзя
+	cryptoEnabled := cryptoBody.cfg.CryptoKey != "" || cryptoBody.cfg.CryptoKeyDir != ""
+
 	return func(writer http.ResponseWriter, request *http.Request) {
-		privateKeyPEM, err := os.ReadFile(cryptoBody.cfg.CryptoKey)
-		if err != nil {
-			logger.Log.Debug("Error reading private key: ", err)
-			handler.ServeHTTP(writer, request)
-			return
+		keys := cryptoBody.keyring.Keys()
+
+		if keyID := request.Header.Get(envelope.KeyIDHeader); keyID != "" {
+			privateKey, ok := cryptoBody.keyring.Key(keyID)
+
+			if !ok {
+				logger.Log.Debug("Unknown key id: ", keyID)
+				reject(writer, security.ReasonUnknownKey, http.StatusBadRequest)
+				return
+			}
+
+			keys = []*rsa.PrivateKey{privateKey}
 		}
-		privateKeyBlock, _ := pem.Decode(privateKeyPEM)
-		privateKey, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
-		if err != nil {
-			logger.Log.Debug("Error parsing private key: ", err)
+
+		if len(keys) == 0 {
+			if strict && cryptoEnabled {
+				reject(writer, security.ReasonNoKeys, http.StatusInternalServerError)
+				return
+			}
+
 			handler.ServeHTTP(writer, request)
 			return
 		}
@@ -42,35 +122,54 @@ func (cryptoBody *CryptoBody) CryptoDecode(handler http.HandlerFunc) http.Handle
 
 		if err != nil {
 			logger.Log.Debug("Error reading request body: ", err)
+
+			if strict {
+				reject(writer, security.ReasonUnreadable, http.StatusBadRequest)
+				return
+			}
+
 			handler.ServeHTTP(writer, request)
 			return
 		}
 
 		defer request.Body.Close()
 
-		var decryptedBody bytes.Buffer
-		segmentSize := privateKey.Size()
+		var decryptedBody []byte
 
-		for i := 0; i < len(body); i += segmentSize {
-			j := i + segmentSize
-			if j > len(body) {
-				j = len(body)
-			}
-			encryptedSegment := body[i:j]
+		switch {
+		case envelope.IsEnvelope(body):
+			decryptedBody, err = decrypt(keys, body, envelope.Open)
 
-			decryptedSegment, err := rsa.DecryptPKCS1v15(rand.Reader, privateKey, encryptedSegment)
 			if err != nil {
-				logger.Log.Debug("Error while decrypting data: ", err)
-				request.Body = io.NopCloser(bytes.NewReader(body))
-				handler.ServeHTTP(writer, request)
+				logger.Log.Debug("Error while decrypting envelope: ", err)
+				reject(writer, security.ReasonUndecryptable, http.StatusBadRequest)
 				return
 			}
 
-			decryptedBody.Write(decryptedSegment)
+			logger.Log.Debug("Successful decryption body")
+		case cryptoBody.cfg.CryptoLegacy:
+			decryptedBody, err = decrypt(keys, body, decodeLegacy)
+
+			if err != nil {
+				logger.Log.Debug("Error while decrypting data: ", err)
+
+				if strict {
+					reject(writer, security.ReasonUndecryptable, http.StatusBadRequest)
+					return
+				}
+
+				decryptedBody = body
+			} else {
+				logger.Log.Debug("Successful decryption legacy body")
+			}
+		case strict:
+			reject(writer, security.ReasonPlaintextBody, http.StatusBadRequest)
+			return
+		default:
+			decryptedBody = body
 		}
 
-		logger.Log.Debug("Successful decryption body")
-		request.Body = io.NopCloser(bytes.NewReader(decryptedBody.Bytes()))
+		request.Body = io.NopCloser(bytes.NewReader(decryptedBody))
 
 		handler.ServeHTTP(writer, request)
 	}
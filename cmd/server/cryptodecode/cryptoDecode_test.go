@@ -6,13 +6,17 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/security"
+	"github.com/dglazkoff/go-metrics/internal/envelope"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -33,46 +37,64 @@ func TestCryptoDecode(t *testing.T) {
 	cryptoBody := Initialize(cfg)
 
 	encryptedData, _ := rsa.EncryptPKCS1v15(rand.Reader, &privateKey.PublicKey, []byte("test body"))
+	envelopeData, err := envelope.Seal(&privateKey.PublicKey, []byte("test body"))
+	require.NoError(t, err)
+
+	tamperedEnvelope := append([]byte(nil), envelopeData...)
+	tamperedEnvelope[len(tamperedEnvelope)-1] ^= 1
 
 	tests := []struct {
-		name     string
-		body     []byte
-		setup    func()
-		expected []byte
+		name       string
+		body       []byte
+		setup      func()
+		expected   []byte
+		statusCode int
 	}{
 		{
-			name:     "successful decryption",
-			body:     encryptedData,
-			setup:    func() {},
-			expected: []byte("test body"),
+			name:       "successful envelope decryption",
+			body:       envelopeData,
+			setup:      func() {},
+			expected:   []byte("test body"),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "tampered envelope",
+			body:       tamperedEnvelope,
+			setup:      func() {},
+			expected:   nil,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "legacy body without legacy mode",
+			body:       encryptedData,
+			setup:      func() {},
+			expected:   encryptedData,
+			statusCode: http.StatusOK,
+		},
+		{
+			name: "successful legacy decryption",
+			body: encryptedData,
+			setup: func() {
+				cryptoBody.cfg.CryptoLegacy = true
+			},
+			expected:   []byte("test body"),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "error decrypting segment",
+			body:       []byte("invalid_encrypted_data"),
+			setup:      func() {},
+			expected:   []byte("invalid_encrypted_data"),
+			statusCode: http.StatusOK,
 		},
 		{
 			name: "error reading private key",
 			body: encryptedData,
 			setup: func() {
-				cryptoBody.cfg.CryptoKey = "invalid_path.pem"
+				cryptoBody = Initialize(&config.Config{CryptoKey: "invalid_path.pem", CryptoLegacy: true})
 			},
-			expected: encryptedData,
-		},
-		//{
-		//	name: "error parsing private key",
-		//	body: encryptedData,
-		//	setup: func() {
-		//		os.WriteFile(keyFile.Name(), []byte("invalid_key_data"), 0644)
-		//	},
-		//	expectError: true,
-		//},
-		//{
-		//	name:        "error reading request body",
-		//	body:        nil,
-		//	setup:       func() {},
-		//	expectError: true,
-		//},
-		{
-			name:     "error decrypting segment",
-			body:     []byte("invalid_encrypted_data"),
-			setup:    func() {},
-			expected: []byte("invalid_encrypted_data"),
+			expected:   encryptedData,
+			statusCode: http.StatusOK,
 		},
 	}
 
@@ -89,7 +111,193 @@ func TestCryptoDecode(t *testing.T) {
 
 			cryptoBody.CryptoDecode(handler).ServeHTTP(rec, req)
 
+			assert.Equal(t, tt.statusCode, rec.Code)
 			assert.Equal(t, tt.expected, rec.Body.Bytes())
 		})
 	}
 }
+
+func TestCryptoDecode_KeyID(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	oldKey := writeKey(t, filepath.Join(dir, "2024-01.pem"), false)
+	newKey := writeKey(t, filepath.Join(dir, "2024-06.pem"), false)
+
+	cfg := &config.Config{CryptoKeyDir: dir}
+	keyring, err := LoadKeyring(cfg)
+	require.NoError(t, err)
+
+	cryptoBody := InitializeWithKeyring(cfg, keyring)
+
+	sealed := func(key *rsa.PrivateKey) []byte {
+		data, err := envelope.Seal(&key.PublicKey, []byte("test body"))
+		require.NoError(t, err)
+		return data
+	}
+
+	tests := []struct {
+		name       string
+		keyID      string
+		body       []byte
+		statusCode int
+	}{
+		{name: "new key by id", keyID: "2024-06", body: sealed(newKey), statusCode: http.StatusOK},
+		{name: "old key by id", keyID: "2024-01", body: sealed(oldKey), statusCode: http.StatusOK},
+		{name: "without key id", body: sealed(oldKey), statusCode: http.StatusOK},
+		{name: "wrong key id", keyID: "2024-01", body: sealed(newKey), statusCode: http.StatusBadRequest},
+		{name: "unknown key id", keyID: "2023-12", body: sealed(newKey), statusCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			if tt.keyID != "" {
+				req.Header.Set(envelope.KeyIDHeader, tt.keyID)
+			}
+			rec := httptest.NewRecorder()
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				w.Write(body)
+			})
+
+			cryptoBody.CryptoDecode(handler).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.statusCode, rec.Code)
+			if tt.statusCode == http.StatusOK {
+				assert.Equal(t, "test body", rec.Body.String())
+			}
+		})
+	}
+
+	// ключ, добавленный после старта, доступен после перечитывания связки
+	rotatedKey := writeKey(t, filepath.Join(dir, "2024-12.pem"), false)
+	require.NoError(t, keyring.Reload())
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(sealed(rotatedKey)))
+	req.Header.Set(envelope.KeyIDHeader, "2024-12")
+	rec := httptest.NewRecorder()
+
+	cryptoBody.CryptoDecode(func(w http.ResponseWriter, r *http.Request) {}).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("read error")
+}
+
+func TestCryptoDecode_StrictSecurity(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	privateKey := writeKey(t, filepath.Join(dir, "2024-06.pem"), false)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	legacyData, err := rsa.EncryptPKCS1v15(rand.Reader, &privateKey.PublicKey, []byte("test body"))
+	require.NoError(t, err)
+	legacyOtherKey, err := rsa.EncryptPKCS1v15(rand.Reader, &otherKey.PublicKey, []byte("test body"))
+	require.NoError(t, err)
+	envelopeData, err := envelope.Seal(&privateKey.PublicKey, []byte("test body"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		cfg        *config.Config
+		body       io.Reader
+		statusCode int
+		reason     string
+	}{
+		{
+			name:       "envelope",
+			cfg:        &config.Config{CryptoKeyDir: dir, StrictSecurity: true},
+			body:       bytes.NewReader(envelopeData),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "plaintext body",
+			cfg:        &config.Config{CryptoKeyDir: dir},
+			body:       bytes.NewReader([]byte("test body")),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "strict plaintext body",
+			cfg:        &config.Config{CryptoKeyDir: dir, StrictSecurity: true},
+			body:       bytes.NewReader([]byte("test body")),
+			statusCode: http.StatusBadRequest,
+			reason:     security.ReasonPlaintextBody,
+		},
+		{
+			name:       "strict legacy body",
+			cfg:        &config.Config{CryptoKeyDir: dir, CryptoLegacy: true, StrictSecurity: true},
+			body:       bytes.NewReader(legacyData),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "legacy body with unknown key",
+			cfg:        &config.Config{CryptoKeyDir: dir, CryptoLegacy: true},
+			body:       bytes.NewReader(legacyOtherKey),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "strict legacy body with unknown key",
+			cfg:        &config.Config{CryptoKeyDir: dir, CryptoLegacy: true, StrictSecurity: true},
+			body:       bytes.NewReader(legacyOtherKey),
+			statusCode: http.StatusBadRequest,
+			reason:     security.ReasonUndecryptable,
+		},
+		{
+			name:       "missing keys",
+			cfg:        &config.Config{CryptoKey: "invalid_path.pem"},
+			body:       bytes.NewReader([]byte("test body")),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "strict missing keys",
+			cfg:        &config.Config{CryptoKey: "invalid_path.pem", StrictSecurity: true},
+			body:       bytes.NewReader([]byte("test body")),
+			statusCode: http.StatusInternalServerError,
+			reason:     security.ReasonNoKeys,
+		},
+		{
+			name:       "strict without crypto",
+			cfg:        &config.Config{StrictSecurity: true},
+			body:       bytes.NewReader([]byte("test body")),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "unreadable body",
+			cfg:        &config.Config{CryptoKeyDir: dir},
+			body:       errReader{},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "strict unreadable body",
+			cfg:        &config.Config{CryptoKeyDir: dir, StrictSecurity: true},
+			body:       errReader{},
+			statusCode: http.StatusBadRequest,
+			reason:     security.ReasonUnreadable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", tt.body)
+			rec := httptest.NewRecorder()
+
+			before := security.Count(tt.reason)
+
+			Initialize(tt.cfg).CryptoDecode(func(w http.ResponseWriter, r *http.Request) {}).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.statusCode, rec.Code)
+			if tt.reason != "" {
+				assert.Equal(t, before+1, security.Count(tt.reason))
+			}
+		})
+	}
+}
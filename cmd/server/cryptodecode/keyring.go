@@ -0,0 +1,162 @@
+package cryptodecode
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+)
+
+/*
+Keyring - разобранные приватные ключи сервера по идентификаторам.
+Идентификатор ключа - имя PEM файла без расширения: ключ keys/2024-06.pem имеет идентификатор 2024-06.
+Ключи читаются из cfg.CryptoKeyDir (все файлы *.pem) и из cfg.CryptoKey
+*/
+type Keyring struct {
+	dir  string
+	file string
+
+	mu   sync.RWMutex
+	keys map[string]*rsa.PrivateKey
+	ids  []string
+}
+
+// LoadKeyring - загружает ключи из cfg, если ключи не заданы - возвращает пустую связку
+func LoadKeyring(cfg *config.Config) (*Keyring, error) {
+	k := &Keyring{dir: cfg.CryptoKeyDir, file: cfg.CryptoKey, keys: make(map[string]*rsa.PrivateKey)}
+
+	return k, k.Reload()
+}
+
+// keyID - идентификатор ключа по имени файла
+func keyID(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// parsePrivateKey - разбирает RSA ключ в формате PKCS1 или PKCS8
+func parsePrivateKey(path string) (*rsa.PrivateKey, error) {
+	privateKeyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeyBlock, _ := pem.Decode(privateKeyPEM)
+	if privateKeyBlock == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	if privateKey, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes); err == nil {
+		return privateKey, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(privateKeyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing private key %s: %w", path, err)
+	}
+
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not RSA", path)
+	}
+
+	return privateKey, nil
+}
+
+/*
+Reload - перечитывает ключи. Если хотя бы один ключ не удалось прочитать,
+связка остается прежней, чтобы ошибка в одном файле не остановила прием метрик
+*/
+func (k *Keyring) Reload() error {
+	var paths []string
+
+	if k.dir != "" {
+		if _, err := os.Stat(k.dir); err != nil {
+			return fmt.Errorf("error while reading key directory: %w", err)
+		}
+
+		dirPaths, err := filepath.Glob(filepath.Join(k.dir, "*.pem"))
+		if err != nil {
+			return err
+		}
+
+		paths = append(paths, dirPaths...)
+	}
+
+	if k.file != "" {
+		paths = append(paths, k.file)
+	}
+
+	keys := make(map[string]*rsa.PrivateKey, len(paths))
+	var errs []error
+
+	for _, path := range paths {
+		privateKey, err := parsePrivateKey(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		id := keyID(path)
+		if _, ok := keys[id]; ok {
+			errs = append(errs, fmt.Errorf("duplicate key id %s", id))
+			continue
+		}
+
+		keys[id] = privateKey
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	k.mu.Lock()
+	k.keys = keys
+	k.ids = ids
+	k.mu.Unlock()
+
+	return nil
+}
+
+// Key - ключ по идентификатору
+func (k *Keyring) Key(id string) (*rsa.PrivateKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	privateKey, ok := k.keys[id]
+	return privateKey, ok
+}
+
+// Keys - все ключи в порядке идентификаторов, нужны для запросов агентов, которые не передают идентификатор ключа
+func (k *Keyring) Keys() []*rsa.PrivateKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := make([]*rsa.PrivateKey, 0, len(k.ids))
+	for _, id := range k.ids {
+		keys = append(keys, k.keys[id])
+	}
+
+	return keys
+}
+
+// IDs - идентификаторы загруженных ключей
+func (k *Keyring) IDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return append([]string(nil), k.ids...)
+}
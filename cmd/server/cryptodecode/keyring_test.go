@@ -0,0 +1,110 @@
+package cryptodecode
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKey - генерирует RSA ключ и записывает его в path в формате PKCS1 или PKCS8
+func writeKey(t *testing.T, path string, pkcs8 bool) *rsa.PrivateKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}
+	if pkcs8 {
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		require.NoError(t, err)
+
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+
+	return privateKey
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	oldKey := writeKey(t, filepath.Join(dir, "2024-01.pem"), false)
+	newKey := writeKey(t, filepath.Join(dir, "2024-06.pem"), true)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a key"), 0600))
+
+	singleKeyFile := writeKeyFile(t, "private.pem")
+
+	keyring, err := LoadKeyring(&config.Config{CryptoKeyDir: dir, CryptoKey: filepath.Join(dir, "missing.pem")})
+	assert.Error(t, err)
+	assert.Empty(t, keyring.Keys())
+
+	keyring, err = LoadKeyring(&config.Config{CryptoKeyDir: dir, CryptoKey: singleKeyFile})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2024-01", "2024-06", "private"}, keyring.IDs())
+	assert.Len(t, keyring.Keys(), 3)
+
+	key, ok := keyring.Key("2024-01")
+	require.True(t, ok)
+	assert.True(t, oldKey.Equal(key))
+
+	key, ok = keyring.Key("2024-06")
+	require.True(t, ok)
+	assert.True(t, newKey.Equal(key))
+
+	_, ok = keyring.Key("README")
+	assert.False(t, ok)
+
+	_, err = LoadKeyring(&config.Config{CryptoKeyDir: filepath.Join(dir, "missing")})
+	assert.Error(t, err)
+
+	empty, err := LoadKeyring(&config.Config{})
+	require.NoError(t, err)
+	assert.Empty(t, empty.Keys())
+}
+
+func TestKeyring_Reload(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, filepath.Join(dir, "2024-01.pem"), false)
+
+	keyring, err := LoadKeyring(&config.Config{CryptoKeyDir: dir})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2024-01"}, keyring.IDs())
+
+	// новый ключ появляется после перечитывания
+	writeKey(t, filepath.Join(dir, "2024-06.pem"), false)
+	require.NoError(t, keyring.Reload())
+	assert.Equal(t, []string{"2024-01", "2024-06"}, keyring.IDs())
+
+	// поврежденный файл не сбрасывает уже загруженные ключи
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("invalid_key_data"), 0600))
+	assert.Error(t, keyring.Reload())
+	assert.Equal(t, []string{"2024-01", "2024-06"}, keyring.IDs())
+
+	// удаленный ключ пропадает из связки
+	require.NoError(t, os.Remove(filepath.Join(dir, "broken.pem")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "2024-01.pem")))
+	require.NoError(t, keyring.Reload())
+	assert.Equal(t, []string{"2024-06"}, keyring.IDs())
+}
+
+func TestKeyring_DuplicateID(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, filepath.Join(dir, "private.pem"), false)
+
+	_, err := LoadKeyring(&config.Config{CryptoKeyDir: dir, CryptoKey: writeKeyFile(t, "private.pem")})
+	assert.Error(t, err)
+}
+
+// writeKeyFile - ключ в отдельной временной директории
+func writeKeyFile(t *testing.T, name string) string {
+	path := filepath.Join(t.TempDir(), name)
+	writeKey(t, path, false)
+
+	return path
+}
@@ -1,48 +1,44 @@
 package main
 
 import (
-	"errors"
+	"crypto/tls"
 	"net"
-	"net/http"
-	_ "net/http/pprof"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
 	"github.com/dglazkoff/go-metrics/cmd/server/router"
 	"github.com/dglazkoff/go-metrics/cmd/server/services/service"
 	"github.com/dglazkoff/go-metrics/cmd/server/storage"
 	"google.golang.org/grpc"
-
-	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
+	"google.golang.org/grpc/credentials"
 )
 
-func RunGRPCServer(cfg *config.Config, errChan chan<- error) *grpc.Server {
-	store, fileStorage, err := storage.InitStorages(cfg)
+/*
+RunGRPCServer - запускает gRPC сервер на addr, ошибки после успешного старта отправляются в errChan.
+Если tlsConfig не nil, сервер принимает только TLS соединения
+*/
+func RunGRPCServer(addr string, cfg *config.Config, store storage.MetricsStorage, fileStorage storage.FileStorage, tlsConfig *tls.Config, errChan chan<- error) (*grpc.Server, error) {
+	listen, err := net.Listen("tcp", addr)
 
 	if err != nil {
-		errChan <- err
-		return nil
+		return nil, err
 	}
 
-	// logger.Log.Infow("Starting gRPC Server on ", "addr", cfg.RunAddr)
-
-	if cfg.StoreInterval != 0 {
-		go fileStorage.WriteMetrics(true)
-	}
+	// logger.Log.Infow("Starting gRPC Server on ", "addr", addr)
 
 	metricService := service.New(store, fileStorage, cfg)
 
-	listen, err := net.Listen("tcp", cfg.RunAddr)
-	if err != nil {
-		errChan <- err
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
 	}
-	grpcServer := grpc.NewServer()
-	pb.RegisterMetricsServer(grpcServer, router.NewMetricsServer(metricService))
+
+	grpcServer := router.NewGRPCServer(metricService, cfg, opts...)
 
 	go func() {
-		if err := grpcServer.Serve(listen); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := grpcServer.Serve(listen); err != nil {
 			errChan <- err
 		}
 	}()
 
-	return grpcServer
+	return grpcServer, nil
 }
@@ -6,10 +6,15 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/dglazkoff/go-metrics/cmd/server/auth"
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/cryptodecode"
+	"github.com/dglazkoff/go-metrics/cmd/server/storage"
+	"github.com/dglazkoff/go-metrics/cmd/server/tlsconfig"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"google.golang.org/grpc"
@@ -21,6 +26,9 @@ var (
 	BuildCommit  = "N/A"
 )
 
+// shutdownTimeout - сколько ждем завершения текущих запросов при остановке серверов
+const shutdownTimeout = 5 * time.Second
+
 func runApp(cfg *config.Config) error {
 	err := logger.Initialize()
 	if err != nil {
@@ -31,45 +39,166 @@ func runApp(cfg *config.Config) error {
 	fmt.Printf("Build date: %s\n", BuildDate)
 	fmt.Printf("Build commit: %s\n", BuildCommit)
 
+	if err = cfg.Validate(); err != nil {
+		return err
+	}
+
 	sigs := make(chan os.Signal, 1)
-	errChan := make(chan error, 1)
 	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	defer signal.Stop(sigs)
+
+	tlsConfig, err := tlsconfig.Load(cfg)
+	if err != nil {
+		return err
+	}
+
+	// с неверным файлом токенов сервер отклонял бы всю запись метрик, поэтому проверяем его до старта
+	if cfg.AgentKeysFile != "" {
+		if _, err = auth.LoadTokens(cfg.AgentKeysFile); err != nil {
+			return err
+		}
+	}
+
+	// одно хранилище на оба сервера, иначе метрики, пришедшие по HTTP, не были бы видны по gRPC
+	store, fileStorage, err := storage.InitStorages(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := store.Close(); err != nil {
+			logger.Log.Debug("Error while close storage: ", err)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if cfg.StoreInterval != 0 {
+		go fileStorage.WriteMetrics(true)
+	}
+
+	// ключи, которые не удалось загрузить, можно исправить и перечитать по SIGHUP без перезапуска
+	keyring, err := cryptodecode.LoadKeyring(cfg)
+	if err != nil {
+		// в режиме strict-security сервер без ключей отклонял бы все зашифрованные метрики, поэтому не запускаемся
+		if cfg.StrictSecurity {
+			return fmt.Errorf("failed to load private keys: %w", err)
+		}
+
+		logger.Log.Debug("Error while loading private keys: ", err)
+	}
+
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	defer signal.Stop(hups)
+
+	go reloadKeyring(ctx, keyring, hups)
+
+	engine, err := startAlerting(ctx, cfg, store)
+	if err != nil {
+		return err
+	}
+
+	// без -grpc-addr флаг -grpc, как и раньше, запускает gRPC вместо HTTP на основном адресе
+	runHTTP := cfg.GRPCAddr != "" || !cfg.IsGRPC
+	runGRPC := cfg.GRPCAddr != "" || cfg.IsGRPC
+
+	grpcAddr := cfg.GRPCAddr
+	if grpcAddr == "" {
+		grpcAddr = cfg.RunAddr
+	}
+
+	errChan := make(chan error, 2)
 
 	var grpcServer *grpc.Server
 	var httpServer *http.Server
 
-	if cfg.IsGRPC {
-		grpcServer = RunGRPCServer(cfg, errChan)
-	} else {
-		httpServer = RunHTTPServer(cfg, errChan)
+	if runHTTP {
+		httpServer, err = RunHTTPServer(cfg, store, fileStorage, engine, keyring, tlsConfig, errChan)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if runGRPC {
+		grpcServer, err = RunGRPCServer(grpcAddr, cfg, store, fileStorage, tlsConfig, errChan)
+
+		if err != nil {
+			shutdown(httpServer, nil)
+			return err
+		}
 	}
 
 	select {
-	case err := <-errChan:
+	case err = <-errChan:
 		logger.Log.Debug("Server error occurred: ", err)
-		return err
 	case sig := <-sigs:
 		logger.Log.Debug("Signal: ", sig)
 	}
 
+	shutdown(httpServer, grpcServer)
+
+	if err != nil {
+		return err
+	}
+
+	logger.Log.Infow("Server exited properly")
+	return nil
+}
+
+// shutdown - останавливает оба сервера одновременно, дожидаясь завершения текущих запросов не дольше shutdownTimeout
+func shutdown(httpServer *http.Server, grpcServer *grpc.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+
 	if httpServer != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := httpServer.Shutdown(ctx); err != nil {
-			logger.Log.Debug("Server Shutdown Failed", "err", err)
-		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := httpServer.Shutdown(ctx); err != nil {
+				logger.Log.Debug("HTTP server shutdown failed: ", err)
+			}
+		}()
 	}
 
 	if grpcServer != nil {
-		grpcServer.GracefulStop()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				logger.Log.Debug("gRPC server graceful stop timeout")
+				grpcServer.Stop()
+			}
+		}()
 	}
 
-	logger.Log.Infow("Server exited properly")
-	return nil
+	wg.Wait()
 }
 
 // go run -ldflags "-X main.BuildVersion=v1.0.1 -X 'main.BuildDate=$(date +'%Y/%m/%d %H:%M:%S')'" ./cmd/server
+// go run ./cmd/server migrate [up|down|version] -d <dsn>
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	cfg := config.ParseConfig()
 	if err := runApp(&cfg); err != nil {
 		panic(err)
@@ -1,15 +1,28 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/cryptodecode"
 	"github.com/dglazkoff/go-metrics/internal/logger"
+	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 func TestRunApp_HTTPServer(t *testing.T) {
@@ -56,3 +69,123 @@ func TestRunApp_GRPCServer(t *testing.T) {
 
 	time.Sleep(100 * time.Millisecond)
 }
+
+// freeAddr - свободный локальный адрес для сервера
+func freeAddr(t *testing.T) string {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listen.Close()
+
+	return listen.Addr().String()
+}
+
+func TestRunApp_HTTPAndGRPCServers(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	cfg := &config.Config{RunAddr: freeAddr(t), GRPCAddr: freeAddr(t)}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runApp(cfg)
+	}()
+
+	// метрика, записанная по HTTP, должна читаться по gRPC из того же хранилища
+	require.Eventually(t, func() bool {
+		res, err := http.Post("http://"+cfg.RunAddr+"/update/counter/PollCount/5", "text/plain", nil)
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+
+		return res.StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	conn, err := grpc.NewClient(cfg.GRPCAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	metric, err := pb.NewMetricsClient(conn).GetMetric(context.Background(), &pb.GetMetricRequest{Id: "PollCount", Type: pb.Metric_Counter})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), metric.GetDelta())
+
+	process, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, process.Signal(syscall.SIGTERM))
+
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(shutdownTimeout + time.Second):
+		t.Fatal("servers were not stopped")
+	}
+
+	_, err = http.Get("http://" + cfg.RunAddr + "/ping")
+	assert.Error(t, err, "HTTP server should be stopped")
+}
+
+func TestRunApp_AddressInUse(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listen.Close()
+
+	err = runApp(&config.Config{RunAddr: freeAddr(t), GRPCAddr: listen.Addr().String()})
+	assert.Error(t, err)
+}
+
+func TestRunApp_StrictSecurityWithoutKeys(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	err = runApp(&config.Config{RunAddr: freeAddr(t), CryptoKey: filepath.Join(t.TempDir(), "missing.pem"), StrictSecurity: true})
+	assert.Error(t, err)
+}
+
+func TestRunApp_InvalidTrustedSubnet(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	err = runApp(&config.Config{RunAddr: freeAddr(t), TrustedSubnet: "192.168.31.0/24,192.168.31,206"})
+	assert.ErrorContains(t, err, "invalid trusted subnet")
+}
+
+func TestRunMigrate(t *testing.T) {
+	oldDSN := os.Getenv("DATABASE_DSN")
+	os.Unsetenv("DATABASE_DSN")
+	defer os.Setenv("DATABASE_DSN", oldDSN)
+
+	assert.EqualError(t, runMigrate([]string{"up"}), "database dsn is required for migrate")
+	assert.EqualError(t, runMigrate([]string{"sideways", "-d", "postgres://localhost/db"}), "unknown migrate command sideways")
+	assert.Error(t, runMigrate([]string{"down", "-unknown-flag"}))
+}
+
+func TestReloadKeyring(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	keyring, err := cryptodecode.LoadKeyring(&config.Config{CryptoKeyDir: dir})
+	require.NoError(t, err)
+	assert.Empty(t, keyring.IDs())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hups := make(chan os.Signal)
+	go reloadKeyring(ctx, keyring, hups)
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "2024-06.pem"), keyPEM, 0600))
+
+	hups <- syscall.SIGHUP
+	assert.Eventually(t, func() bool {
+		_, ok := keyring.Key("2024-06")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/storage/db/migrations"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+)
+
+/*
+runMigrate - подкоманда `server migrate [up|down|version] -d <dsn> [-steps N]`,
+управляет схемой БД без запуска сервера. По умолчанию выполняется up
+*/
+func runMigrate(args []string) error {
+	err := logger.Initialize()
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+
+	action := "up"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action = args[0]
+		args = args[1:]
+	}
+
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dsn := flags.String("d", "", "database dsn string")
+	steps := flags.Int("steps", 1, "количество откатываемых миграций для down")
+
+	if err = flags.Parse(args); err != nil {
+		return err
+	}
+
+	if databaseDSN := os.Getenv("DATABASE_DSN"); databaseDSN != "" {
+		*dsn = databaseDSN
+	}
+
+	if *dsn == "" {
+		return errors.New("database dsn is required for migrate")
+	}
+
+	pgDB, err := sql.Open("pgx", *dsn)
+	if err != nil {
+		return err
+	}
+	defer pgDB.Close()
+
+	migrator, err := migrations.New(pgDB)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch action {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx, *steps)
+	case "version":
+	default:
+		return fmt.Errorf("unknown migrate command %s", action)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Schema version: %d\n", version)
+	return nil
+}
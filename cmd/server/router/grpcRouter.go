@@ -2,16 +2,35 @@ package router
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
 
+	"github.com/dglazkoff/go-metrics/cmd/server/auth"
+	"github.com/dglazkoff/go-metrics/cmd/server/bodyhash"
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/services/service"
+	subnetvalidate "github.com/dglazkoff/go-metrics/cmd/server/subnetValidate"
+	"github.com/dglazkoff/go-metrics/cmd/server/tlsconfig"
 	constants "github.com/dglazkoff/go-metrics/internal/const"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/dglazkoff/go-metrics/internal/models"
 	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	// регистрирует компрессор gzip для gRPC
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 type metric interface {
+	Get(ctx context.Context, mType string, name string, labels map[string]string) (models.Metrics, error)
+	GetAll(ctx context.Context) ([]models.Metrics, error)
 	UpdateList(ctx context.Context, metric []models.Metrics) error
 }
 
@@ -25,39 +44,207 @@ func NewMetricsServer(metricService metric) *MetricsServer {
 	return &MetricsServer{metricService: metricService}
 }
 
-func (ms *MetricsServer) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
-	var metrics []models.Metrics
+/*
+NewGRPCServer - gRPC сервер с теми же проверками, что и у HTTP роутера: логирование запросов,
+агент по клиентскому сертификату и токену, доверенная подсеть и подпись запроса. Сжатие gzip доступно клиентам через зарегистрированный компрессор,
+сервис grpc.health.v1.Health - для проверки здоровья сервера.
+В opts передаются дополнительные опции сервера, например TLS credentials
+*/
+func NewGRPCServer(metricService metric, cfg *config.Config, opts ...grpc.ServerOption) *grpc.Server {
+	bh := bodyhash.Initialize(cfg)
+	ts := subnetvalidate.Initialize(cfg)
+	au := auth.Initialize(cfg)
+
+	// агент держит одно соединение и проверяет его keepalive пингами, в том числе между отправками метрик
+	opts = append(opts,
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: 10 * time.Second, PermitWithoutStream: true}),
+		grpc.ChainUnaryInterceptor(logger.Log.UnaryInterceptor, tlsconfig.UnaryInterceptor, au.UnaryInterceptor, ts.UnaryInterceptor, bh.UnaryInterceptor),
+		grpc.ChainStreamInterceptor(logger.Log.StreamInterceptor, tlsconfig.StreamInterceptor, au.StreamInterceptor, ts.StreamInterceptor, bh.StreamInterceptor),
+	)
+
+	server := grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(server, NewMetricsServer(metricService))
+	// агенты проверяют здоровье сервера и не отправляют метрики, пока он не отвечает SERVING
+	healthpb.RegisterHealthServer(server, health.NewServer())
+
+	return server
+}
+
+// fromProto - переводит метрику из protobuf в модель, тип метрики должен совпадать с переданным значением
+func fromProto(metric *pb.Metric) (models.Metrics, error) {
+	m := models.Metrics{ID: metric.Id, Labels: models.CopyLabels(metric.Labels)}
+
+	if metric.Id == "" {
+		return m, errors.New("empty metric id")
+	}
+
+	switch v := metric.MetricValue.(type) {
+	case *pb.Metric_Value:
+		if metric.Type != pb.Metric_Gauge {
+			return m, fmt.Errorf("value is set for %s metric %s", metric.Type, metric.Id)
+		}
+
+		value := v.Value
+		m.MType = constants.MetricTypeGauge
+		m.Value = &value
+	case *pb.Metric_Delta:
+		if metric.Type != pb.Metric_Counter {
+			return m, fmt.Errorf("delta is set for %s metric %s", metric.Type, metric.Id)
+		}
+
+		delta := v.Delta
+		m.MType = constants.MetricTypeCounter
+		m.Delta = &delta
+	default:
+		return m, fmt.Errorf("no value for metric %s", metric.Id)
+	}
+
+	return m, nil
+}
+
+// toProto - переводит модель метрики в protobuf
+func toProto(metric models.Metrics) *pb.Metric {
+	m := &pb.Metric{Id: metric.ID, Labels: metric.Labels}
+
+	switch {
+	case metric.MType == constants.MetricTypeGauge && metric.Value != nil:
+		m.Type = pb.Metric_Gauge
+		m.MetricValue = &pb.Metric_Value{Value: *metric.Value}
+	case metric.MType == constants.MetricTypeCounter && metric.Delta != nil:
+		m.Type = pb.Metric_Counter
+		m.MetricValue = &pb.Metric_Delta{Delta: *metric.Delta}
+	}
+
+	return m
+}
+
+// metricType - тип метрики из protobuf в строковом представлении модели
+func metricType(t pb.Metric_Type) (string, bool) {
+	switch t {
+	case pb.Metric_Gauge:
+		return constants.MetricTypeGauge, true
+	case pb.Metric_Counter:
+		return constants.MetricTypeCounter, true
+	}
+
+	return "", false
+}
+
+/*
+updateMetrics - сохраняет корректные метрики из запроса, некорректные отбрасываются и попадают в ответ в rejected.
+Ошибка возвращается, только если не удалось сохранить корректные метрики
+*/
+func (ms *MetricsServer) updateMetrics(ctx context.Context, in *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	response := &pb.UpdateMetricsResponse{}
+	metrics := make([]models.Metrics, 0, len(in.Metrics))
 
 	for _, metric := range in.Metrics {
-		if metric.Type == pb.Metric_Gauge {
-			value := metric.GetValue()
-			m := models.Metrics{
-				ID:    metric.Id,
-				MType: constants.MetricTypeGauge,
-				Value: &value,
-			}
+		m, err := fromProto(metric)
 
-			metrics = append(metrics, m)
+		if err != nil {
+			response.Rejected++
+			response.Errors = append(response.Errors, err.Error())
+			continue
 		}
 
-		if metric.Type == pb.Metric_Counter {
-			delta := metric.GetDelta()
-			m := models.Metrics{
-				ID:    metric.Id,
-				MType: constants.MetricTypeCounter,
-				Delta: &delta,
-			}
+		metrics = append(metrics, m)
+	}
+
+	if len(metrics) == 0 {
+		return response, nil
+	}
+
+	if err := ms.metricService.UpdateList(ctx, metrics); err != nil {
+		return nil, err
+	}
+
+	response.Accepted = int32(len(metrics))
 
-			metrics = append(metrics, m)
+	return response, nil
+}
+
+/*
+UpdateMetrics - сохраняет пачку метрик. Повторная доставка пачки с тем же x-batch-id подтверждается, но не применяется еще раз.
+У StreamMetrics метаданные общие на весь стрим, поэтому пачки стрима идентификатора не имеют
+*/
+func (ms *MetricsServer) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(constants.BatchIDMetadataKey); len(values) > 0 {
+			ctx = service.WithBatchID(ctx, values[0])
 		}
 	}
 
-	err := ms.metricService.UpdateList(ctx, metrics)
+	response, err := ms.updateMetrics(ctx, in)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error on update metrics: %v", err)
+	}
+
+	logger.Log.Debug("Metrics updated: ", response.Accepted, " rejected: ", response.Rejected)
+	return response, nil
+}
+
+// GetMetric - возвращает метрику по типу, имени и меткам
+func (ms *MetricsServer) GetMetric(ctx context.Context, in *pb.GetMetricRequest) (*pb.Metric, error) {
+	mType, ok := metricType(in.Type)
+
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "wrong metric type %s", in.Type)
+	}
+
+	metric, err := ms.metricService.Get(ctx, mType, in.Id, in.Labels)
+
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "metric %s not found: %v", in.Id, err)
+	}
+
+	return toProto(metric), nil
+}
+
+// ListMetrics - отправляет в стрим все метрики
+func (ms *MetricsServer) ListMetrics(_ *pb.ListMetricsRequest, stream grpc.ServerStreamingServer[pb.Metric]) error {
+	metrics, err := ms.metricService.GetAll(stream.Context())
 
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "error on update metrics: %v", err)
+		return status.Errorf(codes.Internal, "error on get metrics: %v", err)
+	}
+
+	for _, metric := range metrics {
+		if err = stream.Send(toProto(metric)); err != nil {
+			return err
+		}
 	}
 
-	logger.Log.Debug("Metrics updated")
-	return nil, nil
+	return nil
+}
+
+/*
+StreamMetrics - принимает пачки метрик из долгоживущего стрима агента и сохраняет каждую пачку по мере получения.
+Когда агент закрывает стрим, отвечает суммарным количеством принятых и отброшенных метрик
+*/
+func (ms *MetricsServer) StreamMetrics(stream grpc.ClientStreamingServer[pb.UpdateMetricsRequest, pb.UpdateMetricsResponse]) error {
+	total := &pb.UpdateMetricsResponse{}
+
+	for {
+		in, err := stream.Recv()
+
+		if errors.Is(err, io.EOF) {
+			logger.Log.Debug("Metrics stream closed, accepted: ", total.Accepted, " rejected: ", total.Rejected)
+			return stream.SendAndClose(total)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		response, err := ms.updateMetrics(stream.Context(), in)
+
+		if err != nil {
+			return status.Errorf(codes.Internal, "error on update metrics: %v", err)
+		}
+
+		total.Accepted += response.Accepted
+		total.Rejected += response.Rejected
+		total.Errors = append(total.Errors, response.Errors...)
+	}
 }
@@ -2,14 +2,31 @@ package router
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net"
 	"testing"
 
+	agentclient "github.com/dglazkoff/go-metrics/cmd/agent/client"
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/services/service"
+	"github.com/dglazkoff/go-metrics/cmd/server/storage/file"
+	"github.com/dglazkoff/go-metrics/cmd/server/storage/metrics"
 	constants "github.com/dglazkoff/go-metrics/internal/const"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/dglazkoff/go-metrics/internal/models"
 	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 // Mock для интерфейса metric
@@ -17,6 +34,16 @@ type mockMetricService struct {
 	mock.Mock
 }
 
+func (m *mockMetricService) Get(ctx context.Context, mType string, name string, labels map[string]string) (models.Metrics, error) {
+	args := m.Called(ctx, mType, name, labels)
+	return args.Get(0).(models.Metrics), args.Error(1)
+}
+
+func (m *mockMetricService) GetAll(ctx context.Context) ([]models.Metrics, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]models.Metrics), args.Error(1)
+}
+
 func (m *mockMetricService) UpdateList(ctx context.Context, metric []models.Metrics) error {
 	args := m.Called(ctx, metric)
 	return args.Error(0)
@@ -40,12 +67,24 @@ func TestUpdateMetrics(t *testing.T) {
 				},
 			},
 			{
-				Id:   "metric2",
-				Type: pb.Metric_Counter,
+				Id:     "metric2",
+				Type:   pb.Metric_Counter,
+				Labels: map[string]string{"host": "agent-1"},
+				MetricValue: &pb.Metric_Delta{
+					Delta: 5,
+				},
+			},
+			{
+				Id:   "metric3",
+				Type: pb.Metric_Gauge,
 				MetricValue: &pb.Metric_Delta{
 					Delta: 5,
 				},
 			},
+			{
+				Id:   "metric4",
+				Type: pb.Metric_Counter,
+			},
 		},
 	}
 
@@ -56,9 +95,10 @@ func TestUpdateMetrics(t *testing.T) {
 			Value: float64Pointer(1.23),
 		},
 		{
-			ID:    "metric2",
-			MType: constants.MetricTypeCounter,
-			Delta: int64Pointer(5),
+			ID:     "metric2",
+			MType:  constants.MetricTypeCounter,
+			Delta:  int64Pointer(5),
+			Labels: map[string]string{"host": "agent-1"},
 		},
 	}
 
@@ -67,7 +107,9 @@ func TestUpdateMetrics(t *testing.T) {
 	resp, err := server.UpdateMetrics(ctx, req)
 
 	assert.NoError(t, err)
-	assert.Nil(t, resp)
+	assert.Equal(t, int32(2), resp.Accepted)
+	assert.Equal(t, int32(2), resp.Rejected)
+	assert.Len(t, resp.Errors, 2)
 	mockService.AssertExpectations(t)
 }
 
@@ -78,3 +120,220 @@ func float64Pointer(v float64) *float64 {
 func int64Pointer(v int64) *int64 {
 	return &v
 }
+
+// grpcClient - поднимает MetricsServer поверх хранилища в памяти на bufconn и возвращает клиент к нему
+func grpcClient(t *testing.T, cfg *config.Config, store []models.Metrics, opts ...grpc.DialOption) pb.MetricsClient {
+	return pb.NewMetricsClient(grpcConn(t, cfg, store, opts...))
+}
+
+// grpcConn - соединение с NewGRPCServer через bufconn
+func grpcConn(t *testing.T, cfg *config.Config, store []models.Metrics, opts ...grpc.DialOption) *grpc.ClientConn {
+	memStore := metrics.New(store)
+	metricService := service.New(memStore, file.New(memStore, cfg), cfg)
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := NewGRPCServer(metricService, cfg)
+
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	t.Cleanup(s.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestNewGRPCServer_Health(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	// проверка здоровья не подписывается и проходит даже в режиме strict-security
+	conn := grpcConn(t, &config.Config{SecretKey: "secret", StrictSecurity: true}, nil)
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
+
+func TestMetricsServer_Read(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	client := grpcClient(t, &config.Config{}, []models.Metrics{
+		{ID: "HeapAlloc", MType: constants.MetricTypeGauge, Value: float64Pointer(1.5), Labels: map[string]string{"host": "agent-1"}},
+		{ID: "PollCount", MType: constants.MetricTypeCounter, Delta: int64Pointer(3)},
+	})
+	ctx := context.Background()
+
+	metric, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "HeapAlloc", Type: pb.Metric_Gauge, Labels: map[string]string{"host": "agent-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, metric.GetValue())
+
+	_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "HeapAlloc", Type: pb.Metric_Gauge})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "HeapAlloc"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{})
+	require.NoError(t, err)
+
+	var ids []string
+	for {
+		m, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+
+		ids = append(ids, m.Id)
+	}
+
+	assert.Equal(t, []string{"PollCount", "HeapAlloc"}, ids)
+}
+
+func TestMetricsServer_StreamMetrics(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	client := grpcClient(t, &config.Config{}, nil)
+	ctx := context.Background()
+
+	stream, err := client.StreamMetrics(ctx)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+			{Id: "PollCount", Type: pb.Metric_Counter, MetricValue: &pb.Metric_Delta{Delta: 2}},
+			{Id: "Broken", Type: pb.Metric_Gauge},
+		}})
+		require.NoError(t, err)
+	}
+
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), resp.Accepted)
+	assert.Equal(t, int32(3), resp.Rejected)
+
+	metric, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Type: pb.Metric_Counter})
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), metric.GetDelta())
+}
+
+func TestMetricsServer_UpdateMetricsBatchID(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	client := grpcClient(t, &config.Config{}, nil)
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "PollCount", Type: pb.Metric_Counter, MetricValue: &pb.Metric_Delta{Delta: 2}}}}
+
+	// повторная доставка пачки подтверждается, но не применяется
+	ctx := metadata.AppendToOutgoingContext(context.Background(), constants.BatchIDMetadataKey, "batch-1")
+	for i := 0; i < 2; i++ {
+		resp, err := client.UpdateMetrics(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), resp.Accepted)
+	}
+
+	metric, err := client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "PollCount", Type: pb.Metric_Counter})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), metric.GetDelta())
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), constants.BatchIDMetadataKey, "batch-2")
+	_, err = client.UpdateMetrics(ctx, req)
+	require.NoError(t, err)
+
+	metric, err = client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "PollCount", Type: pb.Metric_Counter})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), metric.GetDelta())
+}
+
+func TestNewGRPCServer_Interceptors(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	cfg := &config.Config{SecretKey: "secret"}
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "HeapAlloc", Type: pb.Metric_Gauge, MetricValue: &pb.Metric_Value{Value: 1}, Labels: map[string]string{"host": "a", "dc": "b"}},
+	}}
+
+	t.Run("signed and compressed request", func(t *testing.T) {
+		client := grpcClient(t, cfg, nil,
+			grpc.WithUnaryInterceptor(agentclient.UnaryInterceptor("secret", "")),
+			grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)),
+		)
+
+		var header metadata.MD
+		resp, err := client.UpdateMetrics(context.Background(), req, grpc.Header(&header))
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), resp.Accepted)
+		assert.NotEmpty(t, header.Get("hashsha256"))
+	})
+
+	t.Run("signed stream batches", func(t *testing.T) {
+		client := grpcClient(t, cfg, nil)
+
+		stream, err := client.StreamMetrics(context.Background())
+		require.NoError(t, err)
+
+		// каждая пачка стрима подписывается отдельно, поэтому в одном стриме можно передать несколько подписанных пачек
+		for i := 0; i < 3; i++ {
+			batch := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "PollCount", Type: pb.Metric_Counter, MetricValue: &pb.Metric_Delta{Delta: 2}}}}
+			require.NoError(t, agentclient.SignStreamRequest("secret", batch))
+			require.NoError(t, stream.Send(batch))
+		}
+
+		resp, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), resp.Accepted)
+
+		stream, err = client.StreamMetrics(context.Background())
+		require.NoError(t, err)
+
+		batch := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "PollCount", Type: pb.Metric_Counter, MetricValue: &pb.Metric_Delta{Delta: 2}}}}
+		require.NoError(t, agentclient.SignStreamRequest("other", batch))
+		require.NoError(t, stream.Send(batch))
+
+		_, err = stream.CloseAndRecv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		client := grpcClient(t, cfg, nil, grpc.WithUnaryInterceptor(agentclient.UnaryInterceptor("other", "")))
+
+		_, err := client.UpdateMetrics(context.Background(), req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("untrusted subnet", func(t *testing.T) {
+		client := grpcClient(t, &config.Config{TrustedSubnet: "192.168.31.0/24"}, nil)
+
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-real-ip", "10.0.0.1")
+		_, err := client.UpdateMetrics(ctx, req)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		// x-real-ip принимается только от доверенного прокси, а не от самого агента
+		trustedCtx := metadata.AppendToOutgoingContext(context.Background(), "x-real-ip", "192.168.31.5")
+		_, err = client.UpdateMetrics(trustedCtx, req)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		// чтение из недоверенной подсети разрешено, как и на HTTP
+		stream, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.NotEqual(t, codes.PermissionDenied, status.Code(err))
+
+		_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "HeapAlloc", Type: pb.Metric_Gauge})
+		assert.NotEqual(t, codes.PermissionDenied, status.Code(err))
+	})
+}
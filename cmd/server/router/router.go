@@ -1,13 +1,16 @@
 package router
 
 import (
+	"net/http"
 	"net/http/pprof"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/api"
+	"github.com/dglazkoff/go-metrics/cmd/server/auth"
 	"github.com/dglazkoff/go-metrics/cmd/server/bodyhash"
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
 	"github.com/dglazkoff/go-metrics/cmd/server/cryptodecode"
 	"github.com/dglazkoff/go-metrics/cmd/server/gzip"
+	"github.com/dglazkoff/go-metrics/cmd/server/services/alerting"
 	"github.com/dglazkoff/go-metrics/cmd/server/services/service"
 	"github.com/dglazkoff/go-metrics/cmd/server/storage"
 	subnetvalidate "github.com/dglazkoff/go-metrics/cmd/server/subnetValidate"
@@ -15,31 +18,84 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-func Router(store storage.MetricsStorage, fs storage.FileStorage, cfg *config.Config) chi.Router {
+// Option - необязательная зависимость роутера
+type Option func(*options)
+
+type options struct {
+	engine  *alerting.Engine
+	keyring *cryptodecode.Keyring
+}
+
+// WithAlerts - роутер отдает алерты движка engine на /alerts и на html странице
+func WithAlerts(engine *alerting.Engine) Option {
+	return func(o *options) {
+		o.engine = engine
+	}
+}
+
+/*
+WithKeyring - роутер расшифровывает метрики ключами связки keyring, которую можно перечитать без перезапуска сервера.
+Без связки ключи загружаются из cfg
+*/
+func WithKeyring(keyring *cryptodecode.Keyring) Option {
+	return func(o *options) {
+		o.keyring = keyring
+	}
+}
+
+func Router(store storage.MetricsStorage, fs storage.FileStorage, cfg *config.Config, opts ...Option) chi.Router {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	r := chi.NewRouter()
 
 	metricService := service.New(store, fs, cfg)
-	newAPI := api.NewAPI(metricService, cfg)
+
+	var apiOpts []api.Option
+	if o.engine != nil {
+		apiOpts = append(apiOpts, api.WithAlerts(o.engine))
+	}
+	newAPI := api.NewAPI(metricService, cfg, apiOpts...)
+
 	bh := bodyhash.Initialize(cfg)
-	cd := cryptodecode.Initialize(cfg)
+	var cd *cryptodecode.CryptoBody
+	if o.keyring != nil {
+		cd = cryptodecode.InitializeWithKeyring(cfg, o.keyring)
+	} else {
+		cd = cryptodecode.Initialize(cfg)
+	}
 	ts := subnetvalidate.Initialize(cfg)
+	au := auth.Initialize(cfg)
+
+	// запись метрик - только из доверенных подсетей и агентам с токеном, если заданы подсети и файл токенов
+	r.Post("/update/", logger.Log.Request(ts.Validate(au.Authenticate(bh.BodyHash(gzip.GzipHandle(newAPI.UpdateMetricValueInBody(), false))))))
+	r.Post("/update/{metricType}/{metricName}/{metricValue}", logger.Log.Request(ts.Validate(au.Authenticate(bh.BodyHash(gzip.GzipHandle(newAPI.UpdateMetricValueInRequest(), false))))))
+
+	r.Post("/updates/", logger.Log.Request(ts.Validate(au.Authenticate(bh.BodyHash(gzip.GzipHandle(cd.CryptoDecode(newAPI.UpdateList()), false))))))
 
-	r.Post("/update/", logger.Log.Request(bh.BodyHash(gzip.GzipHandle(newAPI.UpdateMetricValueInBody(), false))))
-	r.Post("/update/{metricType}/{metricName}/{metricValue}", logger.Log.Request(bh.BodyHash(gzip.GzipHandle(newAPI.UpdateMetricValueInRequest(), false))))
+	// чтение - роль reader, если включен cfg.ReadAuth
+	reader := func(handler http.HandlerFunc) http.HandlerFunc {
+		return au.Require(auth.RoleReader, handler)
+	}
 
-	r.Post("/updates/", logger.Log.Request(ts.Validate(bh.BodyHash(gzip.GzipHandle(cd.CryptoDecode(newAPI.UpdateList()), false)))))
+	r.Post("/value/", logger.Log.Request(reader(bh.BodyHash(gzip.GzipHandle(newAPI.GetMetricValueInBody(), false)))))
+	r.Get("/value/{metricType}/{metricName}", logger.Log.Request(reader(bh.BodyHash(gzip.GzipHandle(newAPI.GetMetricValueInRequest(), false)))))
 
-	r.Post("/value/", logger.Log.Request(bh.BodyHash(gzip.GzipHandle(newAPI.GetMetricValueInBody(), false))))
-	r.Get("/value/{metricType}/{metricName}", logger.Log.Request(bh.BodyHash(gzip.GzipHandle(newAPI.GetMetricValueInRequest(), false))))
+	r.Get("/history/{metricType}/{metricName}", logger.Log.Request(reader(bh.BodyHash(gzip.GzipHandle(newAPI.GetHistory(), false)))))
+	r.Get("/alerts", logger.Log.Request(reader(bh.BodyHash(gzip.GzipHandle(newAPI.GetAlerts(), true)))))
+	r.Get("/metrics", logger.Log.Request(reader(bh.BodyHash(gzip.GzipHandle(newAPI.GetPrometheusMetrics(), true)))))
+	r.Get("/", logger.Log.Request(reader(bh.BodyHash(gzip.GzipHandle(newAPI.GetHTML(), true)))))
 
-	r.Get("/", logger.Log.Request(bh.BodyHash(gzip.GzipHandle(newAPI.GetHTML(), true))))
+	r.Get("/ping", logger.Log.Request(reader(newAPI.PingDB())))
 
-	r.Get("/ping", logger.Log.Request(newAPI.PingDB()))
-	r.Get("/debug/pprof/", pprof.Index)
-	r.Get("/debug/pprof/{action}", pprof.Index)
-	r.Get("/debug/pprof/profile", pprof.Profile)
-	r.Get("/debug/pprof/symbol", pprof.Symbol)
-	r.Get("/debug/pprof/trace", pprof.Trace)
+	// профилирование и будущие маршруты управления сервером - только роль admin
+	r.Get("/debug/pprof/", au.Require(auth.RoleAdmin, pprof.Index))
+	r.Get("/debug/pprof/{action}", au.Require(auth.RoleAdmin, pprof.Index))
+	r.Get("/debug/pprof/profile", au.Require(auth.RoleAdmin, pprof.Profile))
+	r.Get("/debug/pprof/symbol", au.Require(auth.RoleAdmin, pprof.Symbol))
+	r.Get("/debug/pprof/trace", au.Require(auth.RoleAdmin, pprof.Trace))
 
 	return r
 }
@@ -2,12 +2,20 @@ package router
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/services/alerting"
 	"github.com/dglazkoff/go-metrics/cmd/server/storage/file"
 	"github.com/dglazkoff/go-metrics/cmd/server/storage/metrics"
 	"github.com/dglazkoff/go-metrics/internal/logger"
@@ -18,7 +26,7 @@ import (
 
 type metricService struct{}
 
-func (m *metricService) Get(ctx context.Context, name string) (models.Metrics, error) {
+func (m *metricService) Get(ctx context.Context, mType string, name string, labels map[string]string) (models.Metrics, error) {
 	return models.Metrics{}, nil
 }
 
@@ -34,6 +42,10 @@ func (m *metricService) UpdateList(ctx context.Context, metric []models.Metrics)
 	return nil
 }
 
+func (m *metricService) History(ctx context.Context, mType string, name string, labels map[string]string, from, to time.Time, step time.Duration) ([]models.MetricPoint, error) {
+	return []models.MetricPoint{}, nil
+}
+
 func (m *metricService) PingDB(ctx context.Context) error {
 	return nil
 }
@@ -95,6 +107,30 @@ func TestRouter(t *testing.T) {
 			url:          "/",
 			expectedCode: http.StatusOK,
 		},
+		{
+			name:         "GET /metrics",
+			method:       http.MethodGet,
+			url:          "/metrics",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "GET /history/{metricType}/{metricName} with disabled history",
+			method:       http.MethodGet,
+			url:          "/history/gauge/test_metric",
+			expectedCode: http.StatusNotImplemented,
+		},
+		{
+			name:         "GET /alerts with disabled alerting",
+			method:       http.MethodGet,
+			url:          "/alerts",
+			expectedCode: http.StatusNotImplemented,
+		},
+		{
+			name:         "GET /debug/pprof/ without agent keys",
+			method:       http.MethodGet,
+			url:          "/debug/pprof/",
+			expectedCode: http.StatusForbidden,
+		},
 	}
 
 	for _, tt := range tests {
@@ -108,3 +144,143 @@ func TestRouter(t *testing.T) {
 		})
 	}
 }
+
+func TestRouter_PrometheusMetricsMiddlewares(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	cfg := &config.Config{SecretKey: "secret"}
+
+	value := 1.5
+	store := metrics.New([]models.Metrics{{ID: "HeapAlloc", MType: "gauge", Value: &value}})
+	fileStore := file.New(store, cfg)
+
+	router := Router(store, fileStore, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.NotEmpty(t, rec.Header().Get("HashSHA256"))
+
+	zr, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "# TYPE HeapAlloc gauge\nHeapAlloc 1.5\n")
+}
+
+func TestRouter_Alerts(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	cfg := &config.Config{}
+
+	value := 2e9
+	store := metrics.New([]models.Metrics{{ID: "HeapAlloc", MType: "gauge", Value: &value}})
+	fileStore := file.New(store, cfg)
+
+	rules, err := alerting.LoadRules("testdata/rules.yaml")
+	require.NoError(t, err)
+
+	engine := alerting.New(store, rules)
+	require.NoError(t, engine.Evaluate(context.Background()))
+
+	router := Router(store, fileStore, cfg, WithAlerts(engine))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/alerts", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"rule":"HighHeap","expr":"gauge HeapAlloc \u003e 1e9","state":"firing"`)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<h3>Alerts:</h3><ul><li>firing HighHeap: HeapAlloc = 2e+09</li></ul>")
+}
+
+func TestRouter_AgentAuth(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	keysFile := filepath.Join(t.TempDir(), "agents.yaml")
+	require.NoError(t, os.WriteFile(keysFile, []byte("agents:\n  - id: agent-1\n    token: token-1\n  - id: agent-2\n    token: token-2\n  - id: ops\n    token: token-admin\n    roles: [admin]\n"), 0600))
+
+	cfg := &config.Config{AgentKeysFile: keysFile}
+
+	store := metrics.New([]models.Metrics{})
+	fileStore := file.New(store, cfg)
+
+	router := Router(store, fileStore, cfg)
+
+	// last_writer от клиента не используется, сервер записывает аутентифицированного агента
+	body := []byte(`[{"type":"counter","id":"PollCount","delta":2,"last_writer":"ops"}]`)
+
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	for _, token := range []string{"token-1", "token-2"} {
+		req = httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// агент не входит в ключ метрики: counter разных агентов - один ряд, и он находится запросом без меток
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/value/counter/PollCount", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "4", rec.Body.String())
+
+	// агент, последним записавший метрику, хранится вместе с ней
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/value/", strings.NewReader(`{"type":"counter","id":"PollCount"}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var metric models.Metrics
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&metric))
+	assert.Equal(t, "agent-2", metric.LastWriter)
+
+	// чтение метрик токена не требует
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// профилирование - только роль admin
+	req = httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+	req.Header.Set("Authorization", "Bearer token-1")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+	req.Header.Set("Authorization", "Bearer token-admin")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// с cfg.ReadAuth чтение только по токену с ролью reader
+	cfg.ReadAuth = true
+	router = Router(store, fileStore, cfg)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/value/gauge/HeapAlloc", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set("Authorization", "Bearer token-admin")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.NotEqual(t, http.StatusUnauthorized, rec.Code)
+	assert.NotEqual(t, http.StatusForbidden, rec.Code)
+}
@@ -2,9 +2,11 @@ package router
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
@@ -13,6 +15,8 @@ import (
 	constants "github.com/dglazkoff/go-metrics/internal/const"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func BenchmarkUpdateMetrics(b *testing.B) {
@@ -52,3 +56,52 @@ func BenchmarkUpdateMetrics(b *testing.B) {
 		result.Body.Close()
 	}
 }
+
+// запускать с -race: конкурентные /updates/ вместе с чтением всех метрик
+func TestUpdateMetrics_Parallel(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	cfg := config.Config{StoreInterval: 300}
+
+	store := metrics.New([]models.Metrics{})
+	fileStore := file.New(store, &cfg)
+	ts := httptest.NewServer(Router(store, &fileStore, &cfg))
+	defer ts.Close()
+
+	var deltaValue int64 = 1
+	body, err := json.Marshal([]models.Metrics{{ID: "PollCount", MType: constants.MetricTypeCounter, Delta: &deltaValue}})
+	require.NoError(t, err)
+
+	const requests = 50
+
+	var wg sync.WaitGroup
+	wg.Add(requests * 2)
+
+	for i := 0; i < requests; i++ {
+		go func() {
+			defer wg.Done()
+
+			result, err := ts.Client().Post(ts.URL+"/updates/", "application/json", bytes.NewReader(body))
+			if assert.NoError(t, err) {
+				assert.Equal(t, http.StatusOK, result.StatusCode)
+				result.Body.Close()
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			result, err := ts.Client().Get(ts.URL + "/")
+			if assert.NoError(t, err) {
+				result.Body.Close()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	metric, err := store.ReadMetric(context.Background(), constants.MetricTypeCounter, "PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(requests), *metric.Delta)
+}
@@ -0,0 +1,72 @@
+// Пакет security - счетчики запросов, отклоненных проверками подписи, повторов, расшифровки, токена, роли и подсети
+package security
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/dglazkoff/go-metrics/internal/logger"
+)
+
+// Причины отклонения запроса
+const (
+	ReasonMissingHash   = "missing_hash"       // нет подписи при заданном ключе в режиме strict-security
+	ReasonWrongHash     = "wrong_hash"         // подпись не совпала с телом
+	ReasonUnknownKey    = "unknown_key"        // агент зашифровал тело ключом, которого нет у сервера
+	ReasonUndecryptable = "undecryptable_body" // тело не удалось расшифровать
+	ReasonPlaintextBody = "plaintext_body"     // незашифрованное тело в режиме strict-security
+	ReasonNoKeys        = "no_keys"            // шифрование настроено, но ключи не загружены
+	ReasonUnreadable    = "unreadable_body"    // тело запроса не удалось прочитать
+	ReasonMissingToken  = "missing_token"      // запрос записи без токена агента
+	ReasonInvalidToken  = "invalid_token"      // неизвестный токен агента
+	ReasonAgentMismatch = "agent_mismatch"     // токен принадлежит не тому агенту, что клиентский сертификат
+	ReasonForbiddenRole = "forbidden_role"     // у токена нет роли, нужной для маршрута
+	ReasonUntrustedIP   = "untrusted_ip"       // адрес клиента не входит в доверенные подсети
+	ReasonMissingNonce  = "missing_nonce"      // подпись без времени и nonce в режиме strict-security
+	ReasonStaleRequest  = "stale_request"      // время подписанного запроса вне окна защиты от повторов
+	ReasonReplayedNonce = "replayed_nonce"     // nonce подписанного запроса уже использовался
+)
+
+// Rejection - количество отклоненных запросов по одной причине
+type Rejection struct {
+	Reason string
+	Count  int64
+}
+
+var (
+	mu     sync.Mutex
+	counts = make(map[string]int64)
+)
+
+// Reject - учитывает отклоненный запрос
+func Reject(reason string) {
+	mu.Lock()
+	counts[reason]++
+	mu.Unlock()
+
+	logger.Log.Debug("Request rejected: ", reason)
+}
+
+// Count - количество запросов, отклоненных по причине reason
+func Count(reason string) int64 {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return counts[reason]
+}
+
+// Rejections - количество отклоненных запросов по причинам, отсортированное по причине
+func Rejections() []Rejection {
+	mu.Lock()
+	rejections := make([]Rejection, 0, len(counts))
+	for reason, count := range counts {
+		rejections = append(rejections, Rejection{Reason: reason, Count: count})
+	}
+	mu.Unlock()
+
+	sort.Slice(rejections, func(i, j int) bool {
+		return rejections[i].Reason < rejections[j].Reason
+	})
+
+	return rejections
+}
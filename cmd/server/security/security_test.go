@@ -0,0 +1,25 @@
+package security
+
+import (
+	"testing"
+
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReject(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	Reject(ReasonWrongHash)
+	Reject(ReasonUndecryptable)
+	Reject(ReasonWrongHash)
+
+	assert.Equal(t, int64(2), Count(ReasonWrongHash))
+	assert.Equal(t, int64(0), Count(ReasonNoKeys))
+	assert.Equal(t, []Rejection{
+		{Reason: ReasonUndecryptable, Count: 1},
+		{Reason: ReasonWrongHash, Count: 2},
+	}, Rejections())
+}
@@ -1,39 +1,96 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"net"
 	"net/http"
-	_ "net/http/pprof"
+	"os"
+	"time"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/cryptodecode"
 	"github.com/dglazkoff/go-metrics/cmd/server/router"
+	"github.com/dglazkoff/go-metrics/cmd/server/services/alerting"
+	"github.com/dglazkoff/go-metrics/cmd/server/services/notifier"
 	"github.com/dglazkoff/go-metrics/cmd/server/storage"
+	"github.com/dglazkoff/go-metrics/cmd/server/tlsconfig"
+	"github.com/dglazkoff/go-metrics/internal/logger"
 )
 
-func RunHTTPServer(cfg *config.Config, errChan chan<- error) *http.Server {
-	store, fileStorage, err := storage.InitStorages(cfg)
+// startAlerting - если заданы правила алертов, запускает их проверку до отмены ctx
+func startAlerting(ctx context.Context, cfg *config.Config, store storage.MetricsStorage) (*alerting.Engine, error) {
+	if cfg.AlertRulesFile == "" {
+		return nil, nil
+	}
+
+	rules, err := alerting.LoadRules(cfg.AlertRulesFile)
 
 	if err != nil {
-		errChan <- err
-		return nil
+		return nil, err
 	}
 
-	// logger.Log.Infow("Starting HTTP Server on ", "addr", cfg.RunAddr)
+	var opts []alerting.Option
 
-	if cfg.StoreInterval != 0 {
-		go fileStorage.WriteMetrics(true)
+	if len(cfg.AlertWebhooks) != 0 {
+		n := notifier.New(cfg.AlertWebhooks, cfg.SecretKey, notifier.RetryIntervals)
+		go n.Run(ctx)
+
+		opts = append(opts, alerting.WithNotifier(n))
 	}
 
+	engine := alerting.New(store, rules, opts...)
+
+	go engine.Run(ctx, time.Duration(cfg.AlertInterval)*time.Second)
+
+	return engine, nil
+}
+
+// reloadKeyring - перечитывает ключи расшифровки при каждом сигнале из hups до отмены ctx
+func reloadKeyring(ctx context.Context, keyring *cryptodecode.Keyring, hups <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hups:
+			if err := keyring.Reload(); err != nil {
+				logger.Log.Infow("Keyring reload failed, previous keys are kept", "error", err)
+				continue
+			}
+
+			logger.Log.Infow("Keyring reloaded", "keys", keyring.IDs())
+		}
+	}
+}
+
+/*
+RunHTTPServer - запускает HTTP сервер на cfg.RunAddr, ошибки после успешного старта отправляются в errChan.
+Если tlsConfig не nil, сервер принимает только HTTPS соединения
+*/
+func RunHTTPServer(cfg *config.Config, store storage.MetricsStorage, fileStorage storage.FileStorage, engine *alerting.Engine, keyring *cryptodecode.Keyring, tlsConfig *tls.Config, errChan chan<- error) (*http.Server, error) {
+	listen, err := net.Listen("tcp", cfg.RunAddr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		listen = tls.NewListener(listen, tlsConfig)
+	}
+
+	// logger.Log.Infow("Starting HTTP Server on ", "addr", cfg.RunAddr)
+
 	server := &http.Server{
 		Addr:    cfg.RunAddr,
-		Handler: router.Router(store, fileStorage, cfg),
+		Handler: tlsconfig.HTTPIdentity(router.Router(store, fileStorage, cfg, router.WithAlerts(engine), router.WithKeyring(keyring))),
 	}
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := server.Serve(listen); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errChan <- err
 		}
 	}()
 
-	return server
+	return server, nil
 }
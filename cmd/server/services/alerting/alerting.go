@@ -0,0 +1,297 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+)
+
+// ResolvedRetention - сколько времени resolved алерт остается в списке алертов
+const ResolvedRetention = 15 * time.Minute
+
+type metricsReader interface {
+	ReadMetrics(ctx context.Context) ([]models.Metrics, error)
+}
+
+type notifier interface {
+	Notify(alert models.Alert)
+}
+
+// alertKey - алерт определяется правилом и метками метрики, имя и тип метрики задаются правилом
+type alertKey struct {
+	rule   int
+	labels string
+}
+
+// counterSample - значение counter при прошлой проверке, нужно для вычисления rate
+type counterSample struct {
+	delta int64
+	at    time.Time
+}
+
+/*
+Engine - периодически проверяет правила по всем метрикам хранилища.
+Для каждой пары правило + метки метрики хранится состояние алерта: pending -> firing -> resolved
+*/
+type Engine struct {
+	storage  metricsReader
+	rules    []Rule
+	notifier notifier
+
+	mu       sync.RWMutex
+	alerts   map[alertKey]*models.Alert
+	counters map[string]counterSample
+	now      func() time.Time
+}
+
+// Option - необязательная зависимость движка алертов
+type Option func(*Engine)
+
+// WithNotifier - движок сообщает n о переходах алертов в firing и resolved
+func WithNotifier(n notifier) Option {
+	return func(e *Engine) {
+		e.notifier = n
+	}
+}
+
+// New - создает движок алертов по разобранным правилам
+func New(storage metricsReader, rules []Rule, opts ...Option) *Engine {
+	e := &Engine{
+		storage:  storage,
+		rules:    rules,
+		alerts:   make(map[alertKey]*models.Alert),
+		counters: make(map[string]counterSample),
+		now:      time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+// Run - проверяет правила каждые interval до отмены контекста
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.Evaluate(ctx); err != nil {
+			logger.Log.Debug("Error while evaluate alert rules: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate - одна проверка всех правил по текущим значениям метрик
+func (e *Engine) Evaluate(ctx context.Context) error {
+	metrics, err := e.storage.ReadMetrics(ctx)
+
+	if err != nil {
+		return fmt.Errorf("error while reading metrics: %w", err)
+	}
+
+	changed := e.evaluate(metrics, e.now())
+
+	if e.notifier != nil {
+		for _, alert := range changed {
+			e.notifier.Notify(alert)
+		}
+	}
+
+	return nil
+}
+
+// evaluate - проверяет правила по метрикам и возвращает копии алертов, перешедших в firing или resolved
+func (e *Engine) evaluate(metrics []models.Metrics, now time.Time) []models.Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rates := e.rates(metrics, now)
+	active := make(map[alertKey]bool)
+	var changed []models.Alert
+
+	for i, rule := range e.rules {
+		for _, metric := range metrics {
+			if metric.MType != rule.mType || metric.ID != rule.metric {
+				continue
+			}
+
+			key := alertKey{rule: i, labels: models.LabelsKey(metric.Labels)}
+
+			value, ok := ruleValue(rule, metric, rates)
+			if !ok {
+				// rate еще не посчитать, оставляем алерт в прежнем состоянии
+				if alert, exists := e.alerts[key]; exists && alert.State != constants.AlertStateResolved {
+					active[key] = true
+				}
+				continue
+			}
+
+			if !rule.compare(value) {
+				continue
+			}
+
+			active[key] = true
+			if alert := e.activate(key, rule, metric, value, now); alert != nil {
+				changed = append(changed, copyAlert(alert))
+			}
+		}
+	}
+
+	for _, alert := range e.deactivate(active, now) {
+		changed = append(changed, copyAlert(alert))
+	}
+
+	return changed
+}
+
+// rates - скорость изменения counter в секунду с прошлой проверки, запоминает текущие значения для следующей проверки
+func (e *Engine) rates(metrics []models.Metrics, now time.Time) map[string]float64 {
+	rates := make(map[string]float64)
+
+	for _, metric := range metrics {
+		if metric.MType != constants.MetricTypeCounter || metric.Delta == nil {
+			continue
+		}
+
+		seriesKey := metric.FullName()
+
+		if prev, ok := e.counters[seriesKey]; ok && now.After(prev.at) {
+			diff := *metric.Delta - prev.delta
+			// counter уменьшился - значит он был сброшен, считаем от нуля
+			if diff < 0 {
+				diff = *metric.Delta
+			}
+
+			rates[seriesKey] = float64(diff) / now.Sub(prev.at).Seconds()
+		}
+
+		e.counters[seriesKey] = counterSample{delta: *metric.Delta, at: now}
+	}
+
+	return rates
+}
+
+// ruleValue - значение выражения правила для метрики
+func ruleValue(rule Rule, metric models.Metrics, rates map[string]float64) (float64, bool) {
+	if rule.isRate {
+		rate, ok := rates[metric.FullName()]
+		return rate, ok
+	}
+
+	switch {
+	case metric.MType == constants.MetricTypeGauge && metric.Value != nil:
+		return *metric.Value, true
+	case metric.MType == constants.MetricTypeCounter && metric.Delta != nil:
+		return float64(*metric.Delta), true
+	}
+
+	return 0, false
+}
+
+// copyAlert - копия алерта, не разделяющая метки с хранимым алертом
+func copyAlert(alert *models.Alert) models.Alert {
+	a := *alert
+	a.Labels = models.CopyLabels(alert.Labels)
+
+	return a
+}
+
+/*
+activate - условие правила выполняется: создает pending алерт или переводит его в firing по истечении for.
+Возвращает алерт, если он перешел в firing
+*/
+func (e *Engine) activate(key alertKey, rule Rule, metric models.Metrics, value float64, now time.Time) *models.Alert {
+	alert, ok := e.alerts[key]
+
+	if !ok || alert.State == constants.AlertStateResolved {
+		alert = &models.Alert{
+			Rule:     rule.Name,
+			Expr:     rule.Expr,
+			State:    constants.AlertStatePending,
+			Metric:   metric.FullName(),
+			Labels:   models.CopyLabels(metric.Labels),
+			ActiveAt: now,
+		}
+		e.alerts[key] = alert
+	}
+
+	alert.Value = value
+
+	if alert.State == constants.AlertStatePending && now.Sub(alert.ActiveAt) >= rule.duration {
+		firedAt := now
+		alert.State = constants.AlertStateFiring
+		alert.FiredAt = &firedAt
+
+		logger.Log.Infow("Alert firing", "rule", alert.Rule, "metric", alert.Metric, "value", value)
+
+		return alert
+	}
+
+	return nil
+}
+
+/*
+deactivate - для алертов, условие которых больше не выполняется: pending удаляются, firing переходят в resolved.
+Возвращает алерты, перешедшие в resolved
+*/
+func (e *Engine) deactivate(active map[alertKey]bool, now time.Time) []*models.Alert {
+	var resolved []*models.Alert
+
+	for key, alert := range e.alerts {
+		if active[key] {
+			continue
+		}
+
+		switch alert.State {
+		case constants.AlertStatePending:
+			delete(e.alerts, key)
+		case constants.AlertStateFiring:
+			resolvedAt := now
+			alert.State = constants.AlertStateResolved
+			alert.ResolvedAt = &resolvedAt
+
+			logger.Log.Infow("Alert resolved", "rule", alert.Rule, "metric", alert.Metric)
+			resolved = append(resolved, alert)
+		case constants.AlertStateResolved:
+			if now.Sub(*alert.ResolvedAt) > ResolvedRetention {
+				delete(e.alerts, key)
+			}
+		}
+	}
+
+	return resolved
+}
+
+// Alerts - возвращает копии текущих алертов, отсортированные по правилу и метрике
+func (e *Engine) Alerts() []models.Alert {
+	e.mu.RLock()
+	alerts := make([]models.Alert, 0, len(e.alerts))
+	for _, alert := range e.alerts {
+		alerts = append(alerts, copyAlert(alert))
+	}
+	e.mu.RUnlock()
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+
+		return alerts[i].Metric < alerts[j].Metric
+	})
+
+	return alerts
+}
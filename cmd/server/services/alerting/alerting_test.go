@@ -0,0 +1,164 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubStorage struct {
+	metrics []models.Metrics
+	err     error
+}
+
+func (s *stubStorage) ReadMetrics(_ context.Context) ([]models.Metrics, error) {
+	return s.metrics, s.err
+}
+
+func gauge(id string, value float64, labels map[string]string) models.Metrics {
+	return models.Metrics{ID: id, MType: constants.MetricTypeGauge, Value: &value, Labels: labels}
+}
+
+func counter(id string, delta int64) models.Metrics {
+	return models.Metrics{ID: id, MType: constants.MetricTypeCounter, Delta: &delta}
+}
+
+func TestEngine_GaugeLifecycle(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	rule, err := parseRule(Rule{Name: "HighHeap", Expr: "gauge HeapAlloc > 100 for 2m"})
+	require.NoError(t, err)
+
+	store := &stubStorage{}
+	engine := New(store, []Rule{rule})
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	engine.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	host := map[string]string{"host": "agent-1"}
+
+	store.metrics = []models.Metrics{gauge("HeapAlloc", 50, host), gauge("HeapAlloc", 200, nil)}
+	require.NoError(t, engine.Evaluate(ctx))
+
+	alerts := engine.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, constants.AlertStatePending, alerts[0].State)
+	assert.Equal(t, "HeapAlloc", alerts[0].Metric)
+	assert.Equal(t, float64(200), alerts[0].Value)
+
+	// pending, условие перестало выполняться раньше for - алерт пропадает
+	store.metrics = []models.Metrics{gauge("HeapAlloc", 50, host), gauge("HeapAlloc", 50, nil)}
+	now = now.Add(time.Minute)
+	require.NoError(t, engine.Evaluate(ctx))
+	assert.Empty(t, engine.Alerts())
+
+	store.metrics = []models.Metrics{gauge("HeapAlloc", 300, host)}
+	now = now.Add(time.Minute)
+	require.NoError(t, engine.Evaluate(ctx))
+
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, engine.Evaluate(ctx))
+
+	alerts = engine.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, constants.AlertStateFiring, alerts[0].State)
+	assert.Equal(t, `HeapAlloc{host="agent-1"}`, alerts[0].Metric)
+	require.NotNil(t, alerts[0].FiredAt)
+	assert.Equal(t, now, *alerts[0].FiredAt)
+
+	store.metrics = []models.Metrics{gauge("HeapAlloc", 10, host)}
+	now = now.Add(time.Minute)
+	require.NoError(t, engine.Evaluate(ctx))
+
+	alerts = engine.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, constants.AlertStateResolved, alerts[0].State)
+	require.NotNil(t, alerts[0].ResolvedAt)
+
+	now = now.Add(ResolvedRetention + time.Second)
+	require.NoError(t, engine.Evaluate(ctx))
+	assert.Empty(t, engine.Alerts())
+}
+
+func TestEngine_Rate(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	rule, err := parseRule(Rule{Name: "AgentStopped", Expr: "rate(counter PollCount) == 0"})
+	require.NoError(t, err)
+
+	store := &stubStorage{}
+	engine := New(store, []Rule{rule})
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	engine.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// при первой проверке rate еще не посчитать
+	store.metrics = []models.Metrics{counter("PollCount", 10)}
+	require.NoError(t, engine.Evaluate(ctx))
+	assert.Empty(t, engine.Alerts())
+
+	store.metrics = []models.Metrics{counter("PollCount", 20)}
+	now = now.Add(10 * time.Second)
+	require.NoError(t, engine.Evaluate(ctx))
+	assert.Empty(t, engine.Alerts())
+
+	now = now.Add(10 * time.Second)
+	require.NoError(t, engine.Evaluate(ctx))
+
+	alerts := engine.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, constants.AlertStateFiring, alerts[0].State)
+	assert.Equal(t, float64(0), alerts[0].Value)
+}
+
+func TestEngine_StorageError(t *testing.T) {
+	engine := New(&stubStorage{err: errors.New("db is down")}, nil)
+
+	err := engine.Evaluate(context.Background())
+	assert.Error(t, err)
+	assert.Empty(t, engine.Alerts())
+}
+
+type stubNotifier struct {
+	alerts []models.Alert
+}
+
+func (n *stubNotifier) Notify(alert models.Alert) {
+	n.alerts = append(n.alerts, alert)
+}
+
+func TestEngine_Notifier(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	rule, err := parseRule(Rule{Name: "HighHeap", Expr: "gauge HeapAlloc > 100"})
+	require.NoError(t, err)
+
+	store := &stubStorage{}
+	n := &stubNotifier{}
+	engine := New(store, []Rule{rule}, WithNotifier(n))
+	ctx := context.Background()
+
+	store.metrics = []models.Metrics{gauge("HeapAlloc", 200, nil)}
+	require.NoError(t, engine.Evaluate(ctx))
+	require.NoError(t, engine.Evaluate(ctx))
+
+	store.metrics = []models.Metrics{gauge("HeapAlloc", 50, nil)}
+	require.NoError(t, engine.Evaluate(ctx))
+	require.NoError(t, engine.Evaluate(ctx))
+
+	require.Len(t, n.alerts, 2)
+	assert.Equal(t, constants.AlertStateFiring, n.alerts[0].State)
+	assert.Equal(t, constants.AlertStateResolved, n.alerts[1].State)
+}
@@ -0,0 +1,155 @@
+// Пакет alerting - правила алертов и их периодическая проверка по хранилищу метрик
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Rule - правило алерта. Expr имеет вид "<тип> <имя> <оператор> <порог>" или "rate(counter <имя>) <оператор> <порог>",
+например "gauge HeapAlloc > 1e9 for 2m" или "rate(counter PollCount) == 0 for 5m".
+Длительность можно указать в самом выражении или в поле For
+*/
+type Rule struct {
+	Name string `json:"name" yaml:"name"`
+	Expr string `json:"expr" yaml:"expr"`
+	For  string `json:"for" yaml:"for"`
+
+	mType     string
+	metric    string
+	isRate    bool
+	op        string
+	threshold float64
+	duration  time.Duration
+}
+
+type rulesFile struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+var exprRegexp = regexp.MustCompile(`^\s*(?:rate\(\s*(\w+)\s+([^\s()]+)\s*\)|(\w+)\s+(\S+))\s*(>=|<=|==|!=|>|<)\s*(\S+)(?:\s+for\s+(\S+))?\s*$`)
+
+// parseRule - разбирает выражение правила и проверяет его
+func parseRule(rule Rule) (Rule, error) {
+	match := exprRegexp.FindStringSubmatch(rule.Expr)
+	if match == nil {
+		return rule, fmt.Errorf("wrong expression %q", rule.Expr)
+	}
+
+	if match[1] != "" {
+		rule.isRate = true
+		rule.mType, rule.metric = match[1], match[2]
+	} else {
+		rule.mType, rule.metric = match[3], match[4]
+	}
+
+	if rule.mType != constants.MetricTypeGauge && rule.mType != constants.MetricTypeCounter {
+		return rule, fmt.Errorf("unknown metric type %s in expression %q", rule.mType, rule.Expr)
+	}
+
+	if rule.isRate && rule.mType != constants.MetricTypeCounter {
+		return rule, fmt.Errorf("rate is supported only for counter metrics in expression %q", rule.Expr)
+	}
+
+	rule.op = match[5]
+
+	threshold, err := strconv.ParseFloat(match[6], 64)
+	if err != nil {
+		return rule, fmt.Errorf("wrong threshold in expression %q: %w", rule.Expr, err)
+	}
+	rule.threshold = threshold
+
+	forValue := rule.For
+	if match[7] != "" {
+		if forValue != "" {
+			return rule, fmt.Errorf("duration is set both in expression and in for field of rule %q", rule.Name)
+		}
+
+		forValue = match[7]
+	}
+
+	if forValue != "" {
+		rule.duration, err = time.ParseDuration(forValue)
+		if err != nil || rule.duration < 0 {
+			return rule, fmt.Errorf("wrong duration %q in rule %q", forValue, rule.Name)
+		}
+	}
+
+	if rule.Name == "" {
+		rule.Name = rule.Expr
+	}
+
+	return rule, nil
+}
+
+// LoadRules - читает правила из YAML (расширения .yaml и .yml) или JSON файла вида {"rules": [...]}
+func LoadRules(path string) ([]Rule, error) {
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while reading alert rules: %w", err)
+	}
+
+	var file rulesFile
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &file)
+	default:
+		err = json.Unmarshal(content, &file)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing alert rules: %w", err)
+	}
+
+	rules := make([]Rule, 0, len(file.Rules))
+	var errs []error
+
+	for _, rule := range file.Rules {
+		parsed, err := parseRule(rule)
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		rules = append(rules, parsed)
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// compare - проверяет условие правила для значения
+func (r Rule) compare(value float64) bool {
+	switch r.op {
+	case ">":
+		return value > r.threshold
+	case ">=":
+		return value >= r.threshold
+	case "<":
+		return value < r.threshold
+	case "<=":
+		return value <= r.threshold
+	case "==":
+		return value == r.threshold
+	case "!=":
+		return value != r.threshold
+	}
+
+	return false
+}
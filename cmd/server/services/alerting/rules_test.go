@@ -0,0 +1,97 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		want    Rule
+		wantErr bool
+	}{
+		{
+			name: "gauge with for in expression",
+			rule: Rule{Name: "HighHeap", Expr: "gauge HeapAlloc > 1e9 for 2m"},
+			want: Rule{Name: "HighHeap", Expr: "gauge HeapAlloc > 1e9 for 2m", mType: "gauge", metric: "HeapAlloc", op: ">", threshold: 1e9, duration: 2 * time.Minute},
+		},
+		{
+			name: "rate with for field",
+			rule: Rule{Expr: "rate(counter PollCount) == 0", For: "5m"},
+			want: Rule{Name: "rate(counter PollCount) == 0", Expr: "rate(counter PollCount) == 0", For: "5m", mType: "counter", metric: "PollCount", isRate: true, op: "==", threshold: 0, duration: 5 * time.Minute},
+		},
+		{
+			name: "without duration",
+			rule: Rule{Name: "LowMemory", Expr: "gauge FreeMemory<=100"},
+			want: Rule{Name: "LowMemory", Expr: "gauge FreeMemory<=100", mType: "gauge", metric: "FreeMemory", op: "<=", threshold: 100},
+		},
+		{name: "unknown type", rule: Rule{Expr: "histogram HeapAlloc > 1"}, wantErr: true},
+		{name: "rate of gauge", rule: Rule{Expr: "rate(gauge HeapAlloc) > 1"}, wantErr: true},
+		{name: "wrong threshold", rule: Rule{Expr: "gauge HeapAlloc > big"}, wantErr: true},
+		{name: "wrong operator", rule: Rule{Expr: "gauge HeapAlloc => 1"}, wantErr: true},
+		{name: "wrong duration", rule: Rule{Expr: "gauge HeapAlloc > 1 for soon"}, wantErr: true},
+		{name: "duration twice", rule: Rule{Expr: "gauge HeapAlloc > 1 for 1m", For: "2m"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseRule(tt.rule)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rule)
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "rules.yaml")
+	err := os.WriteFile(yamlPath, []byte(`rules:
+  - name: HighHeap
+    expr: gauge HeapAlloc > 1e9 for 2m
+  - name: AgentStopped
+    expr: rate(counter PollCount) == 0
+    for: 5m
+`), 0600)
+	require.NoError(t, err)
+
+	rules, err := LoadRules(yamlPath)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "HighHeap", rules[0].Name)
+	assert.Equal(t, 2*time.Minute, rules[0].duration)
+	assert.True(t, rules[1].isRate)
+	assert.Equal(t, 5*time.Minute, rules[1].duration)
+
+	jsonPath := filepath.Join(dir, "rules.json")
+	err = os.WriteFile(jsonPath, []byte(`{"rules": [{"name": "HighHeap", "expr": "gauge HeapAlloc > 1e9"}]}`), 0600)
+	require.NoError(t, err)
+
+	rules, err = LoadRules(jsonPath)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "HeapAlloc", rules[0].metric)
+
+	wrongPath := filepath.Join(dir, "wrong.json")
+	err = os.WriteFile(wrongPath, []byte(`{"rules": [{"name": "Wrong", "expr": "gauge HeapAlloc"}]}`), 0600)
+	require.NoError(t, err)
+
+	_, err = LoadRules(wrongPath)
+	assert.Error(t, err)
+
+	_, err = LoadRules(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}
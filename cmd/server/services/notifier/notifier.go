@@ -0,0 +1,195 @@
+// Пакет notifier - отправка уведомлений о сработавших и разрешенных алертах на webhook
+package notifier
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/dglazkoff/go-metrics/internal/signature"
+	"github.com/go-resty/resty/v2"
+)
+
+// RetryIntervals - паузы между повторными попытками отправки уведомления
+var RetryIntervals = []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
+
+// queueSize - сколько уведомлений может ждать отправки, при переполнении новые уведомления отбрасываются
+const queueSize = 100
+
+// requestTimeout - сколько ждем ответа webhook на одну попытку отправки
+const requestTimeout = 10 * time.Second
+
+// webhook - адрес webhook и его собственная очередь уведомлений
+type webhook struct {
+	url   string
+	queue chan []byte
+}
+
+/*
+Notifier - отправляет алерты POST запросом с JSON телом на все webhook адреса.
+Уведомление отправляется один раз при переходе алерта в firing и один раз при переходе в resolved.
+У каждого webhook своя очередь и своя горутина отправки, поэтому медленный или недоступный webhook не задерживает остальные.
+Если задан secretKey, тело подписывается так же, как запросы агента: HMAC SHA256 времени отправки, nonce и тела
+в заголовках HashSHA256, X-Timestamp и X-Nonce, поэтому перехваченное уведомление нельзя повторить
+*/
+type Notifier struct {
+	client         *resty.Client
+	webhooks       []webhook
+	secretKey      string
+	retryIntervals []time.Duration
+
+	mu sync.Mutex
+	// sent - последнее отправленное состояние по каждому алерту
+	sent  map[string]string
+	queue chan models.Alert
+}
+
+func New(urls []string, secretKey string, retryIntervals []time.Duration) *Notifier {
+	webhooks := make([]webhook, 0, len(urls))
+	for _, url := range urls {
+		webhooks = append(webhooks, webhook{url: url, queue: make(chan []byte, queueSize)})
+	}
+
+	return &Notifier{
+		client:         resty.New().SetTimeout(requestTimeout),
+		webhooks:       webhooks,
+		secretKey:      secretKey,
+		retryIntervals: retryIntervals,
+		sent:           make(map[string]string),
+		queue:          make(chan models.Alert, queueSize),
+	}
+}
+
+// Notify - ставит уведомление об алерте в очередь, если его состояние с прошлого уведомления изменилось
+func (n *Notifier) Notify(alert models.Alert) {
+	if alert.State != constants.AlertStateFiring && alert.State != constants.AlertStateResolved {
+		return
+	}
+
+	key := alert.Rule + "/" + alert.Metric
+
+	n.mu.Lock()
+	if n.sent[key] == alert.State || (alert.State == constants.AlertStateResolved && n.sent[key] == "") {
+		n.mu.Unlock()
+		return
+	}
+
+	if alert.State == constants.AlertStateResolved {
+		delete(n.sent, key)
+	} else {
+		n.sent[key] = alert.State
+	}
+	n.mu.Unlock()
+
+	select {
+	case n.queue <- alert:
+	default:
+		logger.Log.Debug("Notification queue is full, alert dropped: ", key)
+	}
+}
+
+// Run - раскладывает уведомления из очереди по очередям webhook и отправляет их до отмены контекста
+func (n *Notifier) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, w := range n.webhooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.deliver(ctx, w)
+		}()
+	}
+
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alert := <-n.queue:
+			body, err := json.Marshal(alert)
+
+			if err != nil {
+				logger.Log.Debug("Error while encode alert: ", err)
+				continue
+			}
+
+			for _, w := range n.webhooks {
+				select {
+				case w.queue <- body:
+				default:
+					logger.Log.Debug("Webhook queue is full, alert dropped: ", w.url)
+				}
+			}
+		}
+	}
+}
+
+// deliver - по порядку отправляет уведомления из очереди webhook w до отмены контекста
+func (n *Notifier) deliver(ctx context.Context, w webhook) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case body := <-w.queue:
+			if err := n.send(ctx, w.url, body); err != nil {
+				logger.Log.Debug("Error while send alert notification: ", err)
+			}
+		}
+	}
+}
+
+/*
+send - отправляет тело на url, при сетевой ошибке или 5xx ответе повторяет попытку через retryIntervals.
+Подпись каждой попытки новая, иначе получатель отклонил бы повтор по уже использованному nonce
+*/
+func (n *Notifier) send(ctx context.Context, url string, body []byte) error {
+	request := n.client.R().SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body)
+
+	for retryNumber := 0; ; retryNumber++ {
+		if n.secretKey != "" {
+			nonce, err := signature.NewNonce()
+			if err != nil {
+				return err
+			}
+
+			timestamp := signature.Timestamp(time.Now())
+			request.SetHeader("HashSHA256", hex.EncodeToString(signature.Sign(n.secretKey, timestamp, nonce, body))).
+				SetHeader(signature.TimestampHeader, timestamp).
+				SetHeader(signature.NonceHeader, nonce)
+		}
+
+		res, err := request.Post(url)
+
+		switch {
+		case err != nil:
+			logger.Log.Debug("Error on webhook request: ", err)
+		case res.StatusCode() >= http.StatusInternalServerError:
+			err = fmt.Errorf("webhook %s responded with status %d", url, res.StatusCode())
+		case res.StatusCode() >= http.StatusBadRequest:
+			// 4xx повтором не исправить
+			return fmt.Errorf("webhook %s responded with status %d", url, res.StatusCode())
+		default:
+			return nil
+		}
+
+		if retryNumber == len(n.retryIntervals) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(n.retryIntervals[retryNumber]):
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package notifier
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/dglazkoff/go-metrics/internal/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type received struct {
+	alert     models.Alert
+	hash      string
+	timestamp string
+	nonce     string
+	body      []byte
+}
+
+// receiver - webhook, который отвечает failures раз ошибкой 500, а затем принимает уведомления
+func receiver(t *testing.T, failures int32) (*httptest.Server, chan received, *atomic.Int32) {
+	ch := make(chan received, 10)
+	var calls atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		var alert models.Alert
+		require.NoError(t, json.Unmarshal(body, &alert))
+
+		ch <- received{
+			alert:     alert,
+			hash:      r.Header.Get("HashSHA256"),
+			timestamp: r.Header.Get(signature.TimestampHeader),
+			nonce:     r.Header.Get(signature.NonceHeader),
+			body:      body,
+		}
+	}))
+
+	return ts, ch, &calls
+}
+
+func wait(t *testing.T, ch chan received) received {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification was not received")
+	}
+
+	return received{}
+}
+
+func TestNotifier(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	ts, ch, calls := receiver(t, 2)
+	defer ts.Close()
+
+	n := New([]string{ts.URL}, "secret", []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	alert := models.Alert{Rule: "HighHeap", Expr: "gauge HeapAlloc > 1e9", Metric: "HeapAlloc", Value: 2e9}
+
+	// pending не отправляется
+	alert.State = constants.AlertStatePending
+	n.Notify(alert)
+
+	alert.State = constants.AlertStateFiring
+	n.Notify(alert)
+	// повторный firing того же алерта отбрасывается
+	n.Notify(alert)
+
+	r := wait(t, ch)
+	assert.Equal(t, constants.AlertStateFiring, r.alert.State)
+	assert.Equal(t, int32(3), calls.Load(), "two failed attempts and one successful")
+
+	// подписаны время отправки и nonce вместе с телом
+	require.NotEmpty(t, r.timestamp)
+	require.NotEmpty(t, r.nonce)
+	assert.Equal(t, hex.EncodeToString(signature.Sign("secret", r.timestamp, r.nonce, r.body)), r.hash)
+
+	alert.State = constants.AlertStateResolved
+	n.Notify(alert)
+	n.Notify(alert)
+
+	r = wait(t, ch)
+	assert.Equal(t, constants.AlertStateResolved, r.alert.State)
+
+	// после resolved алерт может сработать снова
+	alert.State = constants.AlertStateFiring
+	n.Notify(alert)
+
+	r = wait(t, ch)
+	assert.Equal(t, constants.AlertStateFiring, r.alert.State)
+
+	select {
+	case r = <-ch:
+		t.Fatalf("unexpected notification %v", r.alert)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNotifier_SlowWebhook(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	ts, ch, _ := receiver(t, 0)
+	defer ts.Close()
+
+	n := New([]string{slow.URL, ts.URL}, "", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	// зависший webhook не задерживает уведомления на остальные адреса
+	n.Notify(models.Alert{Rule: "HighHeap", Metric: "HeapAlloc", State: constants.AlertStateFiring})
+	n.Notify(models.Alert{Rule: "HighHeap", Metric: "Alloc", State: constants.AlertStateFiring})
+
+	assert.Equal(t, "HeapAlloc", wait(t, ch).alert.Metric)
+	assert.Equal(t, "Alloc", wait(t, ch).alert.Metric)
+}
+
+func TestNotifier_send(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		ts, _, calls := receiver(t, 10)
+		defer ts.Close()
+
+		n := New([]string{ts.URL}, "", []time.Duration{time.Millisecond, time.Millisecond})
+
+		err := n.send(context.Background(), ts.URL, []byte(`{}`))
+		assert.Error(t, err)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("client error is not retried", func(t *testing.T) {
+		var calls atomic.Int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer ts.Close()
+
+		n := New([]string{ts.URL}, "", []time.Duration{time.Millisecond})
+
+		err := n.send(context.Background(), ts.URL, []byte(`{}`))
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("hanging webhook times out", func(t *testing.T) {
+		release := make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer ts.Close()
+		defer close(release)
+
+		n := New([]string{ts.URL}, "", nil)
+		n.client.SetTimeout(50 * time.Millisecond)
+
+		start := time.Now()
+		err := n.send(context.Background(), ts.URL, []byte(`{}`))
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("resolved without firing is not sent", func(t *testing.T) {
+		n := New(nil, "", nil)
+		n.Notify(models.Alert{Rule: "HighHeap", Metric: "HeapAlloc", State: constants.AlertStateResolved})
+
+		assert.Empty(t, n.queue)
+	})
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/models"
+)
+
+// History - метод для получения истории значений метрики за интервал [from, to].
+// Если step больше нуля, значения усредняются (gauge) или берется последнее значение (counter) в пределах каждого шага
+func (s service) History(ctx context.Context, mType string, name string, labels map[string]string, from, to time.Time, step time.Duration) ([]models.MetricPoint, error) {
+	points, err := s.storage.ReadHistory(ctx, mType, name, labels, from, to)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if step <= 0 {
+		return points, nil
+	}
+
+	return downsample(mType, points, step), nil
+}
+
+// downsample - группирует отсортированные по времени значения по интервалам длиной step
+func downsample(mType string, points []models.MetricPoint, step time.Duration) []models.MetricPoint {
+	result := make([]models.MetricPoint, 0)
+
+	var sum float64
+	var count int
+
+	for _, point := range points {
+		bucket := point.Timestamp.Truncate(step)
+
+		if len(result) == 0 || !result[len(result)-1].Timestamp.Equal(bucket) {
+			result = append(result, models.MetricPoint{Timestamp: bucket})
+			sum, count = 0, 0
+		}
+
+		last := &result[len(result)-1]
+
+		if mType == constants.MetricTypeCounter {
+			last.Delta = point.Delta
+			continue
+		}
+
+		if point.Value != nil {
+			sum += *point.Value
+			count++
+
+			avg := sum / float64(count)
+			last.Value = &avg
+		}
+	}
+
+	return result
+}
@@ -4,7 +4,9 @@ package service
 import (
 	"context"
 	"errors"
+	"time"
 
+	"github.com/dglazkoff/go-metrics/cmd/server/auth"
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
 	"github.com/dglazkoff/go-metrics/cmd/server/storage"
 	constants "github.com/dglazkoff/go-metrics/internal/const"
@@ -17,12 +19,31 @@ type fileStorage interface {
 }
 
 type metricStorage interface {
-	ReadMetric(ctx context.Context, name string) (models.Metrics, error)
+	ReadMetric(ctx context.Context, mType string, name string, labels map[string]string) (models.Metrics, error)
 	ReadMetrics(ctx context.Context) ([]models.Metrics, error)
 	UpdateMetric(ctx context.Context, metric models.Metrics) error
+	SaveMetrics(ctx context.Context, metrics []models.Metrics) error
+	SaveBatch(ctx context.Context, batchID string, metrics []models.Metrics) (bool, error)
+	ReadHistory(ctx context.Context, mType string, name string, labels map[string]string, from, to time.Time) ([]models.MetricPoint, error)
 	PingDB(ctx context.Context) error
 }
 
+type batchKey struct{}
+
+// maxBatchIDLength - максимальная длина идентификатора пачки метрик
+const maxBatchIDLength = 128
+
+// WithBatchID - контекст с идентификатором пачки метрик, которую агент может доставить повторно
+func WithBatchID(ctx context.Context, batchID string) context.Context {
+	return context.WithValue(ctx, batchKey{}, batchID)
+}
+
+// BatchID - идентификатор пачки метрик из контекста
+func BatchID(ctx context.Context) (string, bool) {
+	batchID, ok := ctx.Value(batchKey{}).(string)
+	return batchID, ok && batchID != ""
+}
+
 type service struct {
 	storage     metricStorage
 	fileStorage fileStorage
@@ -34,9 +55,9 @@ func New(s storage.MetricsStorage, f fileStorage, cfg *config.Config) service {
 	return service{storage: s, cfg: cfg, fileStorage: f}
 }
 
-// Get - метод для получения метрики по имени
-func (s service) Get(ctx context.Context, name string) (models.Metrics, error) {
-	return s.storage.ReadMetric(ctx, name)
+// Get - метод для получения метрики по типу, имени и меткам
+func (s service) Get(ctx context.Context, mType string, name string, labels map[string]string) (models.Metrics, error) {
+	return s.storage.ReadMetric(ctx, mType, name, labels)
 }
 
 // GetAll - метод для получения всех метрик
@@ -44,8 +65,8 @@ func (s service) GetAll(ctx context.Context) ([]models.Metrics, error) {
 	return s.storage.ReadMetrics(ctx)
 }
 
-// Update - метод для обновления метрики
-func (s service) Update(ctx context.Context, metric models.Metrics) error {
+// validate - проверяет тип метрики и наличие значения для этого типа
+func validate(metric models.Metrics) error {
 	if metric.MType != constants.MetricTypeGauge && metric.MType != constants.MetricTypeCounter {
 		logger.Log.Debug("Wrong type")
 		return errors.New("wrong type")
@@ -66,24 +87,103 @@ func (s service) Update(ctx context.Context, metric models.Metrics) error {
 		}
 	}
 
-	err := s.storage.UpdateMetric(ctx, metric)
-	if s.cfg.StoreInterval == 0 && err == nil {
+	// имена меток не экранируются в ключе метрики, поэтому ограничены форматом Prometheus
+	for name := range metric.Labels {
+		if !models.ValidLabelName(name) {
+			logger.Log.Debug("Wrong label name ", name)
+			return errors.New("wrong label name")
+		}
+	}
+
+	return nil
+}
+
+/*
+withWriter - копия метрик, в которой LastWriter - аутентифицированный агент из контекста, значение от клиента не используется.
+Агент не входит в метки метрики: иначе метрика разных агентов распадается на несколько рядов, а запрос без меток ее не находит
+*/
+func withWriter(ctx context.Context, metrics []models.Metrics) []models.Metrics {
+	agent, _ := auth.Agent(ctx)
+
+	result := make([]models.Metrics, len(metrics))
+	for i, metric := range metrics {
+		metric.LastWriter = agent
+		result[i] = metric
+	}
+
+	if agent != "" {
+		logger.Log.Debug("Agent ", agent, " updates metrics: ", len(metrics))
+	}
+
+	return result
+}
+
+// Update - метод для обновления метрики
+func (s service) Update(ctx context.Context, metric models.Metrics) error {
+	if err := validate(metric); err != nil {
+		return err
+	}
+
+	err := s.storage.UpdateMetric(ctx, withWriter(ctx, []models.Metrics{metric})[0])
+	if err != nil {
+		return err
+	}
+
+	if s.cfg.StoreInterval == 0 {
 		s.fileStorage.WriteMetrics(false)
 	}
 
-	return err
+	return nil
 }
 
-// UpdateList - метод для обновления списка метрик
+/*
+UpdateList - метод для обновления списка метрик. Список применяется целиком: либо все метрики, либо ни одной.
+Если в контексте есть идентификатор пачки, уже примененная пачка повторно не применяется,
+а повторная доставка считается успешной - так повтор запроса агентом не задваивает counter
+*/
 func (s service) UpdateList(ctx context.Context, metrics []models.Metrics) error {
 	for _, metric := range metrics {
-		err := s.Update(ctx, metric)
-		if err != nil {
+		if err := validate(metric); err != nil {
 			logger.Log.Debug("Error while updating metric ", err)
 			return err
 		}
 	}
 
+	metrics = withWriter(ctx, metrics)
+
+	batchID, ok := BatchID(ctx)
+	if !ok {
+		err := s.storage.SaveMetrics(ctx, metrics)
+		if err != nil {
+			return err
+		}
+
+		if s.cfg.StoreInterval == 0 {
+			s.fileStorage.WriteMetrics(false)
+		}
+
+		return nil
+	}
+
+	if len(batchID) > maxBatchIDLength {
+		logger.Log.Debug("Too long batch id")
+		return errors.New("too long batch id")
+	}
+
+	applied, err := s.storage.SaveBatch(ctx, batchID, metrics)
+	if err != nil {
+		return err
+	}
+
+	if !applied {
+		logger.Log.Debug("Batch was already applied: ", batchID)
+		return nil
+	}
+
+	if s.cfg.StoreInterval == 0 {
+		s.fileStorage.WriteMetrics(false)
+	}
+
 	return nil
 }
 
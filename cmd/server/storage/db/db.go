@@ -3,11 +3,14 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/dglazkoff/go-metrics/cmd/server/storage/db/migrations"
 	constants "github.com/dglazkoff/go-metrics/internal/const"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 	"github.com/dglazkoff/go-metrics/internal/models"
@@ -20,10 +23,28 @@ var RetryIntervals = []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.
 type dbStorage struct {
 	db             *sql.DB
 	retryIntervals []time.Duration
+	// isHistory - при каждом обновлении метрики дописывать ее значение в metric_samples
+	isHistory bool
 }
 
-func New(db *sql.DB, retryIntervals []time.Duration) *dbStorage {
-	return &dbStorage{db: db, retryIntervals: retryIntervals}
+// Option - необязательная настройка хранилища
+type Option func(*dbStorage)
+
+// WithHistory - хранилище сохраняет каждое обновление метрики в таблицу metric_samples
+func WithHistory() Option {
+	return func(d *dbStorage) {
+		d.isHistory = true
+	}
+}
+
+func New(db *sql.DB, retryIntervals []time.Duration, opts ...Option) *dbStorage {
+	d := &dbStorage{db: db, retryIntervals: retryIntervals}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
 }
 
 func (d *dbStorage) dbExecute(exec func() (sql.Result, error)) (res sql.Result, err error) {
@@ -72,9 +93,16 @@ func (d *dbStorage) dbQueryRow(query func() (*sql.Row, error)) (res *sql.Row, er
 	return res, err
 }
 
+// Bootstrap - приводит схему БД к последней версии, применяя недостающие миграции
 func Bootstrap(d *dbStorage) error {
-	_, err := d.dbExecute(func() (sql.Result, error) {
-		return d.db.Exec("CREATE TABLE IF NOT EXISTS metrics (id VARCHAR(250) PRIMARY KEY, type VARCHAR(250) NOT NULL, value DOUBLE PRECISION, delta BIGINT)")
+	migrator, err := migrations.New(d.db)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = d.dbExecute(func() (sql.Result, error) {
+		return nil, migrator.Up(context.Background())
 	})
 
 	//  @tmvrus как вообще понимать какого рода ошибка упала ? читать код библиотек и понимать какие ошибки они выкидывают?
@@ -98,16 +126,54 @@ func Bootstrap(d *dbStorage) error {
 	*/
 
 	if err != nil {
-		logger.Log.Debug("error while creating table: ", err)
+		logger.Log.Debug("error while applying migrations: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// jsonLabels - метки метрики, хранящиеся в колонке labels типа JSONB
+type jsonLabels map[string]string
+
+func (l *jsonLabels) Scan(src any) error {
+	var data []byte
+
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported labels type %T", src)
+	}
+
+	var labels map[string]string
+	if err := json.Unmarshal(data, &labels); err != nil {
 		return err
 	}
 
+	*l = models.CopyLabels(labels)
 	return nil
 }
 
+// labelsArg - метки в виде JSON для передачи в запрос, пустой набор меток хранится как {}
+func labelsArg(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "{}"
+	}
+
+	// json.Marshal для map[string]string не возвращает ошибок
+	data, _ := json.Marshal(labels)
+	return string(data)
+}
+
 func (d *dbStorage) ReadMetrics(ctx context.Context) ([]models.Metrics, error) {
 	var metrics []models.Metrics
-	rows, err := d.db.QueryContext(ctx, "SELECT id, type, value, delta from metrics")
+	rows, err := d.db.QueryContext(ctx, "SELECT id, type, value, delta, labels, last_writer from metrics")
 
 	if err != nil {
 		logger.Log.Debug("error while reading metrics ", err)
@@ -118,7 +184,7 @@ func (d *dbStorage) ReadMetrics(ctx context.Context) ([]models.Metrics, error) {
 
 	for rows.Next() {
 		var metric models.Metrics
-		err = rows.Scan(&metric.ID, &metric.MType, &metric.Value, &metric.Delta)
+		err = rows.Scan(&metric.ID, &metric.MType, &metric.Value, &metric.Delta, (*jsonLabels)(&metric.Labels), &metric.LastWriter)
 
 		if err != nil {
 			logger.Log.Debug("error while scan metric ", err)
@@ -135,12 +201,16 @@ func (d *dbStorage) ReadMetrics(ctx context.Context) ([]models.Metrics, error) {
 	return metrics, nil
 }
 
-func (d *dbStorage) ReadMetric(ctx context.Context, id string) (models.Metrics, error) {
+func (d *dbStorage) ReadMetric(ctx context.Context, mType string, id string, labels map[string]string) (models.Metrics, error) {
 	var metric models.Metrics
 	_, err := d.dbQueryRow(func() (*sql.Row, error) {
-		row := d.db.QueryRowContext(ctx, "SELECT id, type, value, delta from metrics WHERE id = $1", id)
+		row := d.db.QueryRowContext(
+			ctx,
+			"SELECT id, type, value, delta, labels, last_writer from metrics WHERE type = $1 AND id = $2 AND labels = $3::jsonb",
+			mType, id, labelsArg(labels),
+		)
 
-		err := row.Scan(&metric.ID, &metric.MType, &metric.Value, &metric.Delta)
+		err := row.Scan(&metric.ID, &metric.MType, &metric.Value, &metric.Delta, (*jsonLabels)(&metric.Labels), &metric.LastWriter)
 
 		return row, err
 	})
@@ -153,56 +223,288 @@ func (d *dbStorage) ReadMetric(ctx context.Context, id string) (models.Metrics,
 	return metric, nil
 }
 
+// upsertQuery - собирает один многострочный INSERT ... ON CONFLICT для метрик одного типа.
+// gauge перезаписывает значение, counter прибавляет delta к уже сохраненному в БД значению, last_writer всегда перезаписывается.
+// В режиме истории итоговые значения в том же запросе дописываются в metric_samples
+func (d *dbStorage) upsertQuery(mType string, metrics []models.Metrics) (string, []any) {
+	placeholders := make([]string, 0, len(metrics))
+	args := make([]any, 0, len(metrics)*6)
+
+	for i, metric := range metrics {
+		placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d::jsonb, $%d)", i*6+1, i*6+2, i*6+3, i*6+4, i*6+5, i*6+6))
+		args = append(args, metric.ID, metric.MType, metric.Value, metric.Delta, labelsArg(metric.Labels), metric.LastWriter)
+	}
+
+	onConflict := "ON CONFLICT (type, id, labels) DO UPDATE SET value = EXCLUDED.value, last_writer = EXCLUDED.last_writer"
+	if mType == constants.MetricTypeCounter {
+		onConflict = "ON CONFLICT (type, id, labels) DO UPDATE SET delta = metrics.delta + EXCLUDED.delta, last_writer = EXCLUDED.last_writer"
+	}
+
+	query := "INSERT INTO metrics (id, type, value, delta, labels, last_writer) VALUES " + strings.Join(placeholders, ", ") + " " + onConflict
+
+	if d.isHistory {
+		query = "WITH upserted AS (" + query + " RETURNING type, id, value, delta, labels) " +
+			"INSERT INTO metric_samples (type, id, value, delta, labels) SELECT type, id, value, delta, labels FROM upserted"
+	}
+
+	return query, args
+}
+
+/*
+groupMetrics - раскладывает метрики по типам и схлопывает повторы:
+для gauge остается последнее значение, для counter delta суммируются.
+Один INSERT ... ON CONFLICT не может обновить одну и ту же строку дважды, поэтому повторы убираем до запроса.
+Метрики сортируются по имени и меткам: INSERT блокирует строки в порядке VALUES,
+и две пачки с общими метриками в разном порядке иначе могут заблокировать друг друга (deadlock)
+*/
+func groupMetrics(metrics []models.Metrics) (gauges []models.Metrics, counters []models.Metrics, err error) {
+	gaugeIndex := make(map[string]int)
+	counterIndex := make(map[string]int)
+
+	for _, metric := range metrics {
+		key := metric.FullName()
+
+		switch metric.MType {
+		case constants.MetricTypeGauge:
+			if metric.Value == nil {
+				return nil, nil, fmt.Errorf("required Value field for gauge metric %s", metric.ID)
+			}
+
+			if i, ok := gaugeIndex[key]; ok {
+				gauges[i] = metric
+				continue
+			}
+
+			gaugeIndex[key] = len(gauges)
+			gauges = append(gauges, metric)
+		case constants.MetricTypeCounter:
+			if metric.Delta == nil {
+				return nil, nil, fmt.Errorf("required Delta field for counter metric %s", metric.ID)
+			}
+
+			if i, ok := counterIndex[key]; ok {
+				delta := *counters[i].Delta + *metric.Delta
+				counters[i].Delta = &delta
+				continue
+			}
+
+			delta := *metric.Delta
+			metric.Delta = &delta
+			counterIndex[key] = len(counters)
+			counters = append(counters, metric)
+		default:
+			return nil, nil, fmt.Errorf("unknown metric type %s", metric.MType)
+		}
+	}
+
+	sortMetrics(gauges)
+	sortMetrics(counters)
+
+	return gauges, counters, nil
+}
+
+// sortMetrics - сортирует метрики одного типа по имени и меткам
+func sortMetrics(metrics []models.Metrics) {
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].ID != metrics[j].ID {
+			return metrics[i].ID < metrics[j].ID
+		}
+
+		return models.LabelsKey(metrics[i].Labels) < models.LabelsKey(metrics[j].Labels)
+	})
+}
+
+/*
+dbTransaction - выполняет fn в транзакции, при ошибке соединения или взаимной блокировке (deadlock)
+повторяет транзакцию целиком: PostgreSQL откатывает одну из заблокировавших друг друга транзакций
+*/
+func (d *dbStorage) dbTransaction(ctx context.Context, fn func(tx *sql.Tx) error) (err error) {
+	for index, interval := range d.retryIntervals {
+		logger.Log.Debug("execute db transaction. retry number: ", index)
+		err = d.runTransaction(ctx, fn)
+
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgerrcode.IsConnectionException(pgErr.Code) {
+				if index == len(d.retryIntervals)-1 {
+					return fmt.Errorf("no connection to database")
+				}
+
+				time.Sleep(interval)
+				continue
+			}
+
+			if pgErr.Code == pgerrcode.DeadlockDetected && index < len(d.retryIntervals)-1 {
+				time.Sleep(interval)
+				continue
+			}
+		}
+
+		break
+	}
+
+	return err
+}
+
+func (d *dbStorage) runTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			logger.Log.Debug("error while rollback transaction ", rollbackErr)
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (d *dbStorage) UpdateMetric(ctx context.Context, metric models.Metrics) error {
-	if metric.MType == constants.MetricTypeGauge {
-		_, err := d.dbExecute(func() (sql.Result, error) {
-			return d.db.ExecContext(
-				ctx,
-				"INSERT INTO metrics (id, type, value, delta) VALUES($1, $2, $3, $4) ON CONFLICT (id) DO UPDATE SET value = $3",
-				metric.ID, metric.MType, metric.Value, metric.Delta,
-			)
-		})
+	if metric.MType != constants.MetricTypeGauge && metric.MType != constants.MetricTypeCounter {
+		return fmt.Errorf("unknown metric type %s", metric.MType)
+	}
+
+	// сложение delta происходит внутри одного запроса, поэтому конкурентные обновления counter не теряются
+	query, args := d.upsertQuery(metric.MType, []models.Metrics{metric})
+	_, err := d.dbExecute(func() (sql.Result, error) {
+		return d.db.ExecContext(ctx, query, args...)
+	})
+
+	return err
+}
+
+// saveMetrics - сохраняет метрики, сгруппированные по типу, в транзакции tx
+func (d *dbStorage) saveMetrics(ctx context.Context, tx *sql.Tx, gauges, counters []models.Metrics) error {
+	if len(gauges) > 0 {
+		query, args := d.upsertQuery(constants.MetricTypeGauge, gauges)
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	if len(counters) > 0 {
+		query, args := d.upsertQuery(constants.MetricTypeCounter, counters)
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SaveMetrics - сохраняет список метрик в одной транзакции: либо все метрики, либо ни одной
+func (d *dbStorage) SaveMetrics(ctx context.Context, metrics []models.Metrics) error {
+	gauges, counters, err := groupMetrics(metrics)
+
+	if err != nil {
 		return err
 	}
 
-	if metric.MType == constants.MetricTypeCounter {
-		dbMetric, err := d.ReadMetric(ctx, metric.ID)
+	err = d.dbTransaction(ctx, func(tx *sql.Tx) error {
+		return d.saveMetrics(ctx, tx, gauges, counters)
+	})
+
+	if err != nil {
+		logger.Log.Debug("error while save metrics ", err)
+		return fmt.Errorf("metrics was not saved: %w", err)
+	}
 
+	return nil
+}
+
+// BatchRetention - сколько хранятся идентификаторы примененных пачек метрик в таблице metric_batches
+const BatchRetention = 24 * time.Hour
+
+/*
+SaveBatch - сохраняет пачку метрик batchID в одной транзакции с записью о ней в metric_batches.
+Если запись о пачке уже есть, метрики не сохраняются, поэтому повтор пачки не задваивает counter
+даже при одновременной доставке на несколько экземпляров сервера с общей БД
+*/
+func (d *dbStorage) SaveBatch(ctx context.Context, batchID string, metrics []models.Metrics) (bool, error) {
+	gauges, counters, err := groupMetrics(metrics)
+
+	if err != nil {
+		return false, err
+	}
+
+	var applied bool
+
+	err = d.dbTransaction(ctx, func(tx *sql.Tx) error {
+		applied = false
+
+		res, err := tx.ExecContext(ctx, "INSERT INTO metric_batches (id) VALUES ($1) ON CONFLICT (id) DO NOTHING", batchID)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
 		if err != nil {
-			_, err = d.dbExecute(func() (sql.Result, error) {
-				return d.db.ExecContext(ctx, "INSERT INTO metrics (id, type, value, delta) VALUES($1, $2, $3, $4)", metric.ID, metric.MType, metric.Value, metric.Delta)
-			})
+			return err
+		}
+
+		if rows == 0 {
+			return nil
+		}
 
+		if err = d.saveMetrics(ctx, tx, gauges, counters); err != nil {
 			return err
 		}
 
-		newDelta := *dbMetric.Delta + *metric.Delta
-		_, err = d.dbExecute(func() (sql.Result, error) {
-			return d.db.ExecContext(ctx, "UPDATE metrics SET delta = $1 WHERE id = $2", &newDelta, metric.ID)
-		})
-		return err
+		if _, err = tx.ExecContext(ctx, "DELETE FROM metric_batches WHERE applied_at < $1", time.Now().Add(-BatchRetention)); err != nil {
+			return err
+		}
+
+		applied = true
+
+		return nil
+	})
+
+	if err != nil {
+		logger.Log.Debug("error while save batch ", err)
+		return false, fmt.Errorf("batch was not saved: %w", err)
 	}
 
-	return fmt.Errorf("unknown metric type %s", metric.MType)
+	return applied, nil
 }
 
-func (d *dbStorage) SaveMetrics(ctx context.Context, metrics []models.Metrics) error {
-	var notSavedMetricsIds []string
-	// можно сделать транзакцию для ускорения
-	for _, metric := range metrics {
-		err := d.UpdateMetric(ctx, metric)
+// ReadHistory - возвращает сохраненные значения метрики в интервале [from, to] по возрастанию времени
+func (d *dbStorage) ReadHistory(ctx context.Context, mType string, id string, labels map[string]string, from, to time.Time) ([]models.MetricPoint, error) {
+	points := make([]models.MetricPoint, 0)
+	rows, err := d.db.QueryContext(
+		ctx,
+		"SELECT created_at, value, delta FROM metric_samples WHERE type = $1 AND id = $2 AND labels = $3::jsonb AND created_at BETWEEN $4 AND $5 ORDER BY created_at",
+		mType, id, labelsArg(labels), from, to,
+	)
+
+	if err != nil {
+		logger.Log.Debug("error while reading metric history ", err)
+		return nil, fmt.Errorf("error while reading metric history")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var point models.MetricPoint
+		err = rows.Scan(&point.Timestamp, &point.Value, &point.Delta)
 
 		if err != nil {
-			notSavedMetricsIds = append(notSavedMetricsIds, metric.ID)
-			logger.Log.Debug("error while insert value ", err)
+			logger.Log.Debug("error while scan metric point ", err)
+			continue
 		}
+
+		points = append(points, point)
 	}
 
-	if len(notSavedMetricsIds) > 0 {
-		return fmt.Errorf("metrics was not saved: %s", strings.Join(notSavedMetricsIds, ", "))
+	if rows.Err() != nil {
+		logger.Log.Debug("error from rows ", rows.Err())
 	}
 
-	return nil
+	return points, nil
 }
 
 func (d *dbStorage) PingDB(ctx context.Context) error {
@@ -212,3 +514,8 @@ func (d *dbStorage) PingDB(ctx context.Context) error {
 
 	return nil
 }
+
+// Close - закрывает пул соединений с БД
+func (d *dbStorage) Close() error {
+	return d.db.Close()
+}
@@ -5,6 +5,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"os"
+	"regexp"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,6 +16,7 @@ import (
 	"github.com/dglazkoff/go-metrics/internal/models"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
@@ -27,11 +31,11 @@ func TestReadMetrics(t *testing.T) {
 		assert.NoError(t, err)
 		defer db.Close()
 
-		rows := sqlmock.NewRows([]string{"id", "type", "value", "delta"}).
-			AddRow("1", "gauge", 10.5, nil).
-			AddRow("2", "counter", nil, 15)
+		rows := sqlmock.NewRows([]string{"id", "type", "value", "delta", "labels", "last_writer"}).
+			AddRow("1", "gauge", 10.5, nil, []byte("{}"), "").
+			AddRow("2", "counter", nil, 15, []byte(`{"host": "agent-1"}`), "agent-1")
 
-		mock.ExpectQuery("SELECT id, type, value, delta from metrics").
+		mock.ExpectQuery("SELECT id, type, value, delta, labels, last_writer from metrics").
 			WillReturnRows(rows)
 
 		storage := New(db, RetryIntervals)
@@ -48,6 +52,9 @@ func TestReadMetrics(t *testing.T) {
 		assert.Equal(t, "counter", metrics[1].MType)
 		assert.Nil(t, metrics[1].Value)
 		assert.Equal(t, int64(15), *metrics[1].Delta)
+		assert.Nil(t, metrics[0].Labels)
+		assert.Equal(t, map[string]string{"host": "agent-1"}, metrics[1].Labels)
+		assert.Equal(t, "agent-1", metrics[1].LastWriter)
 
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -57,7 +64,7 @@ func TestReadMetrics(t *testing.T) {
 		assert.NoError(t, err)
 		defer db.Close()
 
-		mock.ExpectQuery("SELECT id, type, value, delta from metrics").
+		mock.ExpectQuery("SELECT id, type, value, delta, labels, last_writer from metrics").
 			WillReturnError(fmt.Errorf("query error"))
 
 		storage := New(db, RetryIntervals)
@@ -74,10 +81,10 @@ func TestReadMetrics(t *testing.T) {
 		assert.NoError(t, err)
 		defer db.Close()
 
-		rows := sqlmock.NewRows([]string{"id", "type", "value", "delta"}).
-			AddRow("invalid", "invalid", "not-a-float", "not-an-int")
+		rows := sqlmock.NewRows([]string{"id", "type", "value", "delta", "labels", "last_writer"}).
+			AddRow("invalid", "invalid", "not-a-float", "not-an-int", []byte("{}"), "")
 
-		mock.ExpectQuery("SELECT id, type, value, delta from metrics").
+		mock.ExpectQuery("SELECT id, type, value, delta, labels, last_writer from metrics").
 			WillReturnRows(rows)
 
 		storage := New(db, RetryIntervals)
@@ -99,25 +106,25 @@ func TestReadMetric(t *testing.T) {
 		assert.NoError(t, err)
 		defer db.Close()
 
-		rowsGauge := sqlmock.NewRows([]string{"id", "type", "value", "delta"}).
-			AddRow("1", "gauge", 10.5, nil)
+		rowsGauge := sqlmock.NewRows([]string{"id", "type", "value", "delta", "labels", "last_writer"}).
+			AddRow("1", "gauge", 10.5, nil, []byte("{}"), "")
 
-		rowsCounter := sqlmock.NewRows([]string{"id", "type", "value", "delta"}).
-			AddRow("2", "counter", nil, 15)
+		rowsCounter := sqlmock.NewRows([]string{"id", "type", "value", "delta", "labels", "last_writer"}).
+			AddRow("2", "counter", nil, 15, []byte("{}"), "")
 
 		mock.ExpectQuery("SELECT (.+) from metrics (.+)").
-			WithArgs("1").
+			WithArgs(constants.MetricTypeGauge, "1", "{}").
 			WillReturnRows(rowsGauge)
 
 		mock.ExpectQuery("SELECT (.+) from metrics (.+)").
-			WithArgs("2").
+			WithArgs(constants.MetricTypeCounter, "2", "{}").
 			WillReturnRows(rowsCounter)
 
 		storage := New(db, RetryIntervals)
 
-		metricGauge, err := storage.ReadMetric(context.Background(), "1")
+		metricGauge, err := storage.ReadMetric(context.Background(), constants.MetricTypeGauge, "1", nil)
 		assert.NoError(t, err)
-		metricCounter, err := storage.ReadMetric(context.Background(), "2")
+		metricCounter, err := storage.ReadMetric(context.Background(), constants.MetricTypeCounter, "2", nil)
 
 		assert.NoError(t, err)
 		assert.Equal(t, "1", metricGauge.ID)
@@ -139,12 +146,12 @@ func TestReadMetric(t *testing.T) {
 		defer db.Close()
 
 		mock.ExpectQuery("SELECT (.+) from metrics (.+)").
-			WithArgs("1").
+			WithArgs(constants.MetricTypeGauge, "1", "{}").
 			WillReturnError(fmt.Errorf("query error"))
 
 		storage := New(db, RetryIntervals)
 
-		metric, err := storage.ReadMetric(context.Background(), "1")
+		metric, err := storage.ReadMetric(context.Background(), constants.MetricTypeGauge, "1", nil)
 
 		assert.Error(t, err)
 		assert.Equal(t, models.Metrics{}, metric)
@@ -156,16 +163,16 @@ func TestReadMetric(t *testing.T) {
 		assert.NoError(t, err)
 		defer db.Close()
 
-		rows := sqlmock.NewRows([]string{"id", "type", "value", "delta"}).
-			AddRow("invalid", "invalid", "not-a-float", "not-an-int")
+		rows := sqlmock.NewRows([]string{"id", "type", "value", "delta", "labels", "last_writer"}).
+			AddRow("invalid", "invalid", "not-a-float", "not-an-int", []byte("{}"), "")
 
 		mock.ExpectQuery("SELECT (.+) from metrics (.+)").
-			WithArgs("1").
+			WithArgs(constants.MetricTypeGauge, "1", "{}").
 			WillReturnRows(rows)
 
 		storage := New(db, RetryIntervals)
 
-		metric, err := storage.ReadMetric(context.Background(), "1")
+		metric, err := storage.ReadMetric(context.Background(), constants.MetricTypeGauge, "1", nil)
 
 		assert.Error(t, err)
 		assert.Equal(t, "error while reading metric", err.Error())
@@ -179,12 +186,12 @@ func TestReadMetric(t *testing.T) {
 		defer db.Close()
 
 		mock.ExpectQuery("SELECT (.+) from metrics (.+)").
-			WithArgs("nonexistent-id").
+			WithArgs(constants.MetricTypeGauge, "nonexistent-id", "{}").
 			WillReturnError(sql.ErrNoRows)
 
 		storage := New(db, RetryIntervals)
 
-		metric, err := storage.ReadMetric(context.Background(), "nonexistent-id")
+		metric, err := storage.ReadMetric(context.Background(), constants.MetricTypeGauge, "nonexistent-id", nil)
 
 		assert.Error(t, err)
 		assert.Equal(t, "error while reading metric", err.Error())
@@ -334,15 +341,65 @@ func TestBootstrap(t *testing.T) {
 	err := logger.Initialize()
 	require.NoError(t, err)
 
-	t.Run("successful table creation", func(t *testing.T) {
+	t.Run("successful migrations", func(t *testing.T) {
 		mockDB, mock, err := sqlmock.New()
 		assert.NoError(t, err)
 		defer mockDB.Close()
 
 		storage := New(mockDB, RetryIntervals)
 
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version FROM schema_version").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}))
+
+		mock.ExpectBegin()
 		mock.ExpectExec("CREATE TABLE IF NOT EXISTS metrics").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_version").
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectExec("ALTER TABLE metrics").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_version").
+			WithArgs(2).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS metric_samples").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_version").
+			WithArgs(3).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectExec("ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_version").
+			WithArgs(4).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS metric_batches").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_version").
+			WithArgs(5).
 			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectExec("ALTER TABLE metrics ADD COLUMN IF NOT EXISTS last_writer").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_version").
+			WithArgs(6).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		err = Bootstrap(storage)
 
@@ -350,20 +407,27 @@ func TestBootstrap(t *testing.T) {
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
-	t.Run("table creation error", func(t *testing.T) {
+	t.Run("migration error", func(t *testing.T) {
 		mockDB, mock, err := sqlmock.New()
 		assert.NoError(t, err)
 		defer mockDB.Close()
 
 		storage := New(mockDB, RetryIntervals)
 
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version FROM schema_version").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}))
+
+		mock.ExpectBegin()
 		mock.ExpectExec("CREATE TABLE IF NOT EXISTS metrics").
 			WillReturnError(errors.New("failed to create table"))
+		mock.ExpectRollback()
 
 		err = Bootstrap(storage)
 
 		assert.Error(t, err)
-		assert.EqualError(t, err, "failed to create table")
+		assert.EqualError(t, err, "error while applying migration 1_create_metrics: failed to create table")
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
@@ -390,7 +454,7 @@ func TestUpdateMetric(t *testing.T) {
 		}
 
 		mock.ExpectExec("INSERT INTO metrics").
-			WithArgs(metric.ID, metric.MType, metric.Value, sql.NullInt64{}).
+			WithArgs(metric.ID, metric.MType, metric.Value, sql.NullInt64{}, "{}", "").
 			WillReturnResult(sqlmock.NewResult(1, 1))
 
 		err = storage.UpdateMetric(ctx, metric)
@@ -399,7 +463,7 @@ func TestUpdateMetric(t *testing.T) {
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
-	t.Run("insert new counter metric", func(t *testing.T) {
+	t.Run("upsert counter metric", func(t *testing.T) {
 		mockDB, mock, err := sqlmock.New()
 		assert.NoError(t, err)
 		defer mockDB.Close()
@@ -408,16 +472,15 @@ func TestUpdateMetric(t *testing.T) {
 		storage := New(mockDB, RetryIntervals)
 
 		metric := models.Metrics{
-			ID:    "counter_metric_1",
-			MType: constants.MetricTypeCounter,
-			Delta: &value,
+			ID:         "counter_metric_1",
+			MType:      constants.MetricTypeCounter,
+			Delta:      &value,
+			Labels:     map[string]string{"host": "agent-1"},
+			LastWriter: "agent-1",
 		}
 
-		mock.ExpectQuery("SELECT (.+) from metrics (.+)").
-			WithArgs("counter_metric_1").
-			WillReturnError(fmt.Errorf("query error"))
-		mock.ExpectExec("INSERT INTO metrics").
-			WithArgs(metric.ID, metric.MType, sql.NullFloat64{}, metric.Delta).
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO metrics (id, type, value, delta, labels, last_writer) VALUES ($1, $2, $3, $4, $5::jsonb, $6) ON CONFLICT (type, id, labels) DO UPDATE SET delta = metrics.delta + EXCLUDED.delta, last_writer = EXCLUDED.last_writer")).
+			WithArgs(metric.ID, metric.MType, sql.NullFloat64{}, metric.Delta, `{"host":"agent-1"}`, "agent-1").
 			WillReturnResult(sqlmock.NewResult(1, 1))
 
 		err = storage.UpdateMetric(ctx, metric)
@@ -426,52 +489,357 @@ func TestUpdateMetric(t *testing.T) {
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
-	t.Run("update existing counter metric", func(t *testing.T) {
-		mockDB, mock, err := sqlmock.New()
+	t.Run("unknown metric type", func(t *testing.T) {
+		mockDB, _, err := sqlmock.New()
 		assert.NoError(t, err)
 		defer mockDB.Close()
 
-		valueExisting := int64(10)
-		valueNew := int64(5)
 		storage := New(mockDB, RetryIntervals)
 
 		metric := models.Metrics{
-			ID:    "counter_metric_1",
-			MType: constants.MetricTypeCounter,
-			Delta: &valueNew,
+			ID:    "unknown_metric",
+			MType: "unknown_type",
 		}
 
-		rowsCounter := sqlmock.NewRows([]string{"id", "type", "value", "delta"}).
-			AddRow("counter_metric_1", "counter", nil, valueExisting)
+		err = storage.UpdateMetric(ctx, metric)
 
-		mock.ExpectQuery("SELECT (.+) from metrics (.+)").
-			WithArgs("counter_metric_1").
-			WillReturnRows(rowsCounter)
-		mock.ExpectExec("UPDATE metrics SET delta").
-			WithArgs(int64(15), metric.ID).
+		assert.Error(t, err)
+		assert.Equal(t, "unknown metric type unknown_type", err.Error())
+	})
+}
+
+func TestSaveMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	value := 1.5
+	newValue := 2.5
+	delta := int64(3)
+
+	t.Run("batch upsert in one transaction", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer mockDB.Close()
+
+		storage := New(mockDB, RetryIntervals)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO metrics (id, type, value, delta, labels, last_writer) VALUES ($1, $2, $3, $4, $5::jsonb, $6) ON CONFLICT (type, id, labels) DO UPDATE SET value = EXCLUDED.value, last_writer = EXCLUDED.last_writer")).
+			WithArgs("gauge", constants.MetricTypeGauge, newValue, sql.NullInt64{}, "{}", "").
 			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO metrics (id, type, value, delta, labels, last_writer) VALUES ($1, $2, $3, $4, $5::jsonb, $6), ($7, $8, $9, $10, $11::jsonb, $12), ($13, $14, $15, $16, $17::jsonb, $18) ON CONFLICT (type, id, labels) DO UPDATE SET delta = metrics.delta + EXCLUDED.delta, last_writer = EXCLUDED.last_writer")).
+			WithArgs(
+				"first", constants.MetricTypeCounter, sql.NullFloat64{}, int64(6), "{}", "",
+				"second", constants.MetricTypeCounter, sql.NullFloat64{}, int64(3), "{}", "",
+				"second", constants.MetricTypeCounter, sql.NullFloat64{}, int64(3), `{"host":"agent-1"}`, "",
+			).
+			WillReturnResult(sqlmock.NewResult(2, 2))
+		mock.ExpectCommit()
+
+		err = storage.SaveMetrics(ctx, []models.Metrics{
+			{ID: "first", MType: constants.MetricTypeCounter, Delta: &delta},
+			{ID: "gauge", MType: constants.MetricTypeGauge, Value: &value},
+			{ID: "second", MType: constants.MetricTypeCounter, Delta: &delta},
+			{ID: "second", MType: constants.MetricTypeCounter, Delta: &delta, Labels: map[string]string{"host": "agent-1"}},
+			{ID: "first", MType: constants.MetricTypeCounter, Delta: &delta},
+			{ID: "gauge", MType: constants.MetricTypeGauge, Value: &newValue},
+		})
 
-		err = storage.UpdateMetric(ctx, metric)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), delta, "input metrics must not be mutated")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rows are sorted by id and labels", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer mockDB.Close()
+
+		storage := New(mockDB, RetryIntervals)
+
+		// порядок строк не зависит от порядка метрик в пачке, поэтому пачки не блокируют друг друга
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO metrics").
+			WithArgs(
+				"Alloc", constants.MetricTypeGauge, value, sql.NullInt64{}, "{}", "",
+				"HeapAlloc", constants.MetricTypeGauge, value, sql.NullInt64{}, `{"host":"agent-1"}`, "",
+				"HeapAlloc", constants.MetricTypeGauge, value, sql.NullInt64{}, `{"host":"agent-2"}`, "",
+			).
+			WillReturnResult(sqlmock.NewResult(3, 3))
+		mock.ExpectExec("INSERT INTO metrics").
+			WithArgs(
+				"PollCount", constants.MetricTypeCounter, sql.NullFloat64{}, delta, "{}", "",
+				"Requests", constants.MetricTypeCounter, sql.NullFloat64{}, delta, "{}", "",
+			).
+			WillReturnResult(sqlmock.NewResult(2, 2))
+		mock.ExpectCommit()
+
+		err = storage.SaveMetrics(ctx, []models.Metrics{
+			{ID: "Requests", MType: constants.MetricTypeCounter, Delta: &delta},
+			{ID: "HeapAlloc", MType: constants.MetricTypeGauge, Value: &value, Labels: map[string]string{"host": "agent-2"}},
+			{ID: "PollCount", MType: constants.MetricTypeCounter, Delta: &delta},
+			{ID: "HeapAlloc", MType: constants.MetricTypeGauge, Value: &value, Labels: map[string]string{"host": "agent-1"}},
+			{ID: "Alloc", MType: constants.MetricTypeGauge, Value: &value},
+		})
 
 		assert.NoError(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
-	t.Run("unknown metric type", func(t *testing.T) {
-		mockDB, _, err := sqlmock.New()
+	t.Run("rollback on error", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer mockDB.Close()
+
+		storage := New(mockDB, RetryIntervals)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO metrics").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO metrics").
+			WillReturnError(errors.New("insert error"))
+		mock.ExpectRollback()
+
+		err = storage.SaveMetrics(ctx, []models.Metrics{
+			{ID: "gauge", MType: constants.MetricTypeGauge, Value: &value},
+			{ID: "counter", MType: constants.MetricTypeCounter, Delta: &delta},
+		})
+
+		assert.EqualError(t, err, "metrics was not saved: insert error")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("invalid metric is rejected before transaction", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer mockDB.Close()
+
+		storage := New(mockDB, RetryIntervals)
+
+		err = storage.SaveMetrics(ctx, []models.Metrics{
+			{ID: "gauge", MType: constants.MetricTypeGauge, Value: &value},
+			{ID: "counter", MType: constants.MetricTypeCounter},
+		})
+
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("retry transaction on connection error", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer mockDB.Close()
+
+		storage := New(mockDB, []time.Duration{time.Millisecond, time.Millisecond})
+
+		mock.ExpectBegin().WillReturnError(&pgconn.PgError{Code: pgerrcode.ConnectionException})
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO metrics").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		err = storage.SaveMetrics(ctx, []models.Metrics{{ID: "gauge", MType: constants.MetricTypeGauge, Value: &value}})
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("retry transaction on deadlock", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer mockDB.Close()
+
+		storage := New(mockDB, []time.Duration{time.Millisecond, time.Millisecond})
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO metrics").
+			WillReturnError(&pgconn.PgError{Code: pgerrcode.DeadlockDetected})
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO metrics").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		err = storage.SaveMetrics(ctx, []models.Metrics{{ID: "counter", MType: constants.MetricTypeCounter, Delta: &delta}})
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// TestSaveMetrics_ConcurrentCounters - интеграционный тест, запускается только при заданной TEST_DATABASE_DSN
+func TestSaveMetrics_ConcurrentCounters(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	pgDB, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	defer pgDB.Close()
+
+	storage := New(pgDB, RetryIntervals)
+	require.NoError(t, Bootstrap(storage))
+
+	_, err = pgDB.ExecContext(ctx, "DELETE FROM metrics WHERE type = $1 AND id = $2", constants.MetricTypeCounter, "ConcurrentPollCount")
+	require.NoError(t, err)
+
+	const workers = 20
+	const iterations = 25
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < iterations; j++ {
+				delta := int64(1)
+				assert.NoError(t, storage.SaveMetrics(ctx, []models.Metrics{{ID: "ConcurrentPollCount", MType: constants.MetricTypeCounter, Delta: &delta}}))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	metric, err := storage.ReadMetric(ctx, constants.MetricTypeCounter, "ConcurrentPollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(workers*iterations), *metric.Delta)
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	t.Run("upsert appends samples in history mode", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer mockDB.Close()
+
+		delta := int64(2)
+		storage := New(mockDB, RetryIntervals, WithHistory())
+
+		mock.ExpectExec(regexp.QuoteMeta("WITH upserted AS (INSERT INTO metrics (id, type, value, delta, labels, last_writer) VALUES ($1, $2, $3, $4, $5::jsonb, $6) ON CONFLICT (type, id, labels) DO UPDATE SET delta = metrics.delta + EXCLUDED.delta, last_writer = EXCLUDED.last_writer RETURNING type, id, value, delta, labels) INSERT INTO metric_samples (type, id, value, delta, labels) SELECT type, id, value, delta, labels FROM upserted")).
+			WithArgs("PollCount", constants.MetricTypeCounter, sql.NullFloat64{}, delta, "{}", "").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		err = storage.UpdateMetric(ctx, models.Metrics{ID: "PollCount", MType: constants.MetricTypeCounter, Delta: &delta})
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("read history", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer mockDB.Close()
+
+		from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		to := from.Add(time.Hour)
+
+		rows := sqlmock.NewRows([]string{"created_at", "value", "delta"}).
+			AddRow(from.Add(time.Minute), 1.5, nil).
+			AddRow(from.Add(2*time.Minute), 2.5, nil)
+
+		mock.ExpectQuery("SELECT created_at, value, delta FROM metric_samples").
+			WithArgs(constants.MetricTypeGauge, "HeapAlloc", `{"host":"agent-1"}`, from, to).
+			WillReturnRows(rows)
+
+		storage := New(mockDB, RetryIntervals, WithHistory())
+
+		points, err := storage.ReadHistory(ctx, constants.MetricTypeGauge, "HeapAlloc", map[string]string{"host": "agent-1"}, from, to)
+
 		assert.NoError(t, err)
+		require.Len(t, points, 2)
+		assert.Equal(t, from.Add(time.Minute), points[0].Timestamp)
+		assert.Equal(t, 2.5, *points[1].Value)
+		assert.Nil(t, points[1].Delta)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestSaveBatch(t *testing.T) {
+	ctx := context.Background()
+
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	delta := int64(3)
+	metrics := []models.Metrics{{ID: "PollCount", MType: constants.MetricTypeCounter, Delta: &delta}}
+
+	t.Run("new batch", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
 		defer mockDB.Close()
 
 		storage := New(mockDB, RetryIntervals)
 
-		metric := models.Metrics{
-			ID:    "unknown_metric",
-			MType: "unknown_type",
-		}
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO metric_batches (id) VALUES ($1) ON CONFLICT (id) DO NOTHING")).
+			WithArgs("batch-1").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO metrics").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("DELETE FROM metric_batches").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
 
-		err = storage.UpdateMetric(ctx, metric)
+		applied, err := storage.SaveBatch(ctx, "batch-1", metrics)
+
+		assert.NoError(t, err)
+		assert.True(t, applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("duplicate batch", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer mockDB.Close()
+
+		storage := New(mockDB, RetryIntervals)
+
+		// запись о пачке уже есть - метрики не сохраняются
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO metric_batches").
+			WithArgs("batch-1").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		applied, err := storage.SaveBatch(ctx, "batch-1", metrics)
+
+		assert.NoError(t, err)
+		assert.False(t, applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rollback on error", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer mockDB.Close()
+
+		storage := New(mockDB, RetryIntervals)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO metric_batches").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO metrics").
+			WillReturnError(errors.New("insert error"))
+		mock.ExpectRollback()
+
+		applied, err := storage.SaveBatch(ctx, "batch-1", metrics)
 
 		assert.Error(t, err)
-		assert.Equal(t, "unknown metric type unknown_type", err.Error())
+		assert.False(t, applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
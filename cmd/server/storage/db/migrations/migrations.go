@@ -0,0 +1,255 @@
+// Пакет migrations - версионированные миграции схемы БД.
+// SQL файлы миграций встраиваются в бинарник, примененные версии хранятся в таблице schema_version
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dglazkoff/go-metrics/internal/logger"
+)
+
+//go:embed sql/*.sql
+var sqlFiles embed.FS
+
+const createVersionTableQuery = "CREATE TABLE IF NOT EXISTS schema_version (version INTEGER PRIMARY KEY, applied_at TIMESTAMPTZ NOT NULL DEFAULT now())"
+
+// Migration - одна миграция: версия, имя и SQL для наката и отката
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New - создает мигратор со встроенными миграциями
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := load(sqlFiles)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+/*
+load - читает миграции из fsys. Имя файла: <версия>_<имя>.up.sql или <версия>_<имя>.down.sql,
+у каждой версии должен быть up файл
+*/
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "sql")
+
+	if err != nil {
+		return nil, fmt.Errorf("error while reading migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		versionPart, migrationName, found := strings.Cut(strings.TrimSuffix(name, "."+direction+".sql"), "_")
+		if !found {
+			return nil, fmt.Errorf("wrong migration file name %s", name)
+		}
+
+		version, err := strconv.Atoi(versionPart)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("wrong migration version in file %s", name)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join("sql", name))
+		if err != nil {
+			return nil, fmt.Errorf("error while reading migration %s: %w", name, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// appliedVersions - возвращает множество уже примененных версий, при необходимости создает таблицу schema_version
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int]bool, error) {
+	if _, err := m.db.ExecContext(ctx, createVersionTableQuery); err != nil {
+		return nil, fmt.Errorf("error while creating schema_version table: %w", err)
+	}
+
+	rows, err := m.db.QueryContext(ctx, "SELECT version FROM schema_version")
+
+	if err != nil {
+		return nil, fmt.Errorf("error while reading schema version: %w", err)
+	}
+
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("error while reading schema version: %w", err)
+		}
+
+		applied[version] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading schema version: %w", err)
+	}
+
+	return applied, nil
+}
+
+// Version - возвращает последнюю примененную версию схемы, 0 если миграции не применялись
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	applied, err := m.appliedVersions(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+
+	return version, nil
+}
+
+// Up - применяет все еще не примененные миграции по возрастанию версии, каждую в своей транзакции
+func (m *Migrator) Up(ctx context.Context) error {
+	applied, err := m.appliedVersions(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if applied[migration.Version] {
+			continue
+		}
+
+		logger.Log.Debug("Applying migration ", migration.Version, " ", migration.Name)
+
+		err = m.inTransaction(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+				return err
+			}
+
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_version (version) VALUES ($1)", migration.Version)
+			return err
+		})
+
+		if err != nil {
+			return fmt.Errorf("error while applying migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Down - откатывает steps последних примененных миграций по убыванию версии
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	applied, err := m.appliedVersions(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+		migration := m.migrations[i]
+
+		if !applied[migration.Version] {
+			continue
+		}
+
+		if migration.Down == "" {
+			return fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+
+		logger.Log.Debug("Reverting migration ", migration.Version, " ", migration.Name)
+
+		err = m.inTransaction(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+				return err
+			}
+
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_version WHERE version = $1", migration.Version)
+			return err
+		})
+
+		if err != nil {
+			return fmt.Errorf("error while reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		steps--
+	}
+
+	return nil
+}
+
+func (m *Migrator) inTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			logger.Log.Debug("error while rollback migration ", rollbackErr)
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
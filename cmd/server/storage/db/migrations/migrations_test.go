@@ -0,0 +1,153 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("embedded migrations", func(t *testing.T) {
+		migrations, err := load(sqlFiles)
+
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, len(migrations), 2)
+		for i, migration := range migrations {
+			assert.Equal(t, i+1, migration.Version)
+			assert.NotEmpty(t, migration.Down)
+		}
+		assert.Equal(t, "create_metrics", migrations[0].Name)
+		assert.Contains(t, migrations[1].Up, "PRIMARY KEY (type, id)")
+	})
+
+	t.Run("sorted by version", func(t *testing.T) {
+		migrations, err := load(fstest.MapFS{
+			"sql/0010_second.up.sql": {Data: []byte("SELECT 10")},
+			"sql/0002_first.up.sql":  {Data: []byte("SELECT 2")},
+			"sql/README.md":          {Data: []byte("ignored")},
+		})
+
+		require.NoError(t, err)
+		require.Len(t, migrations, 2)
+		assert.Equal(t, 2, migrations[0].Version)
+		assert.Equal(t, 10, migrations[1].Version)
+	})
+
+	t.Run("missing up file", func(t *testing.T) {
+		_, err := load(fstest.MapFS{
+			"sql/0001_first.down.sql": {Data: []byte("SELECT 1")},
+		})
+
+		assert.EqualError(t, err, "migration 1 has no up file")
+	})
+
+	t.Run("wrong file name", func(t *testing.T) {
+		_, err := load(fstest.MapFS{
+			"sql/first.up.sql": {Data: []byte("SELECT 1")},
+		})
+
+		assert.Error(t, err)
+	})
+}
+
+func TestMigrator(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	migrations := []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE first", Down: "DROP TABLE first"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE second", Down: "DROP TABLE second"},
+	}
+
+	t.Run("up applies only pending migrations", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version FROM schema_version").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE second").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_version").
+			WithArgs(2).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		m := &Migrator{db: db, migrations: migrations}
+
+		assert.NoError(t, m.Up(ctx))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("down reverts latest migrations", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version FROM schema_version").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1).AddRow(2))
+		mock.ExpectBegin()
+		mock.ExpectExec("DROP TABLE second").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM schema_version").
+			WithArgs(2).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		m := &Migrator{db: db, migrations: migrations}
+
+		assert.NoError(t, m.Down(ctx, 1))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failed migration is rolled back", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version FROM schema_version").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}))
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE first").
+			WillReturnError(errors.New("syntax error"))
+		mock.ExpectRollback()
+
+		m := &Migrator{db: db, migrations: migrations}
+
+		assert.EqualError(t, m.Up(ctx), "error while applying migration 1_first: syntax error")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("version", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version FROM schema_version").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2).AddRow(1))
+
+		m := &Migrator{db: db, migrations: migrations}
+
+		version, err := m.Version(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS metrics (
+    id VARCHAR(250) PRIMARY KEY,
+    type VARCHAR(250) NOT NULL,
+    value DOUBLE PRECISION,
+    delta BIGINT
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS metric_samples (
+    type VARCHAR(250) NOT NULL,
+    id VARCHAR(250) NOT NULL,
+    value DOUBLE PRECISION,
+    delta BIGINT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS metric_samples_type_id_created_at_idx ON metric_samples (type, id, created_at);
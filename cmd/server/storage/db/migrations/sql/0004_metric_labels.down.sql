@@ -0,0 +1,8 @@
+DELETE FROM metrics WHERE labels <> '{}';
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics DROP COLUMN IF EXISTS labels;
+ALTER TABLE metrics ADD PRIMARY KEY (type, id);
+DROP INDEX IF EXISTS metric_samples_type_id_labels_created_at_idx;
+DELETE FROM metric_samples WHERE labels <> '{}';
+ALTER TABLE metric_samples DROP COLUMN IF EXISTS labels;
+CREATE INDEX IF NOT EXISTS metric_samples_type_id_created_at_idx ON metric_samples (type, id, created_at);
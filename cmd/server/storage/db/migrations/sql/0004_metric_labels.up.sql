@@ -0,0 +1,6 @@
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics ADD PRIMARY KEY (type, id, labels);
+ALTER TABLE metric_samples ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+DROP INDEX IF EXISTS metric_samples_type_id_created_at_idx;
+CREATE INDEX IF NOT EXISTS metric_samples_type_id_labels_created_at_idx ON metric_samples (type, id, labels, created_at);
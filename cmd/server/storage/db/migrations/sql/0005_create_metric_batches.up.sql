@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS metric_batches (
+    id VARCHAR(128) PRIMARY KEY,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS metric_batches_applied_at_idx ON metric_batches (applied_at);
@@ -0,0 +1 @@
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS last_writer TEXT NOT NULL DEFAULT '';
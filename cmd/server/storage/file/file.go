@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
@@ -21,10 +22,12 @@ type metricStorage interface {
 type fileStorage struct {
 	storage metricStorage
 	cfg     *config.Config
+	// mu - одна запись файла за раз: при StoreInterval == 0 файл пишут одновременно обработчики HTTP и gRPC серверов
+	mu *sync.Mutex
 }
 
 func New(s metricStorage, cfg *config.Config) fileStorage {
-	return fileStorage{storage: s, cfg: cfg}
+	return fileStorage{storage: s, cfg: cfg, mu: &sync.Mutex{}}
 }
 
 func closeFile(f *os.File) {
@@ -101,37 +104,63 @@ func (s fileStorage) WriteMetrics(isLoop bool) {
 	for {
 		time.Sleep(time.Duration(s.cfg.StoreInterval) * time.Second)
 
-		logger.Log.Debug("Opening file ", path)
-		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		/*
+			defer в цикле отработал бы только при завершении ф-и, которая может никогда не завершиться,
+			поэтому запись вынесена в writeFile, где файл закрывается на каждой итерации
+		*/
+		err = s.writeFile(ctx, path)
 
 		if err != nil {
-			logger.Log.Debug("Error while open file ", err)
+			logger.Log.Debug("Error while write store to file ", err)
 			return
 		}
 
-		/*
-			этот defer отработает только когда завершится ф-я, она может никогда не завершится и цикл будет копить defer, по этой причине defer в цикле - плохая идея
-		*/
-		defer closeFile(file)
+		if !isLoop {
+			break
+		}
+	}
+}
 
-		enc := json.NewEncoder(file)
+/*
+writeFile - записывает метрики во временный файл рядом с path и переименовывает его в path.
+Переименование атомарно, поэтому в path всегда лежит целый снимок: прежний, если запись не удалась, или новый
+*/
+func (s fileStorage) writeFile(ctx context.Context, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		metrics, err := s.storage.ReadMetrics(ctx)
+	metrics, err := s.storage.ReadMetrics(ctx)
 
-		if err != nil {
-			logger.Log.Debug("Error while read metrics ", err)
-			return
+	if err != nil {
+		return err
+	}
 
-		}
+	logger.Log.Debug("Creating temp file for ", path)
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+
+	if err != nil {
+		return err
+	}
 
-		err = enc.Encode(metrics)
+	// после переименования временного файла уже нет, и удаление ничего не делает
+	defer os.Remove(file.Name())
 
-		if err != nil {
-			logger.Log.Debug("Error while write store to file ", err)
-		}
+	err = json.NewEncoder(file).Encode(metrics)
 
-		if !isLoop {
-			break
-		}
+	if err == nil {
+		err = file.Chmod(0644)
 	}
+
+	if err != nil {
+		closeFile(file)
+		return err
+	}
+
+	err = file.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
 }
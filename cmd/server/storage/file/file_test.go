@@ -6,6 +6,7 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
@@ -97,7 +98,7 @@ func TestReadMetrics(t *testing.T) {
 				defer os.Remove(path)
 			}
 
-			s := fileStorage{cfg: tt.cfg, storage: &mockStorage}
+			s := New(&mockStorage, tt.cfg)
 			s.ReadMetrics()
 
 			if tt.expectSaveCall {
@@ -159,7 +160,7 @@ func TestWriteMetrics(t *testing.T) {
 			path := filepath.Join(dir, tt.cfg.FileStoragePath)
 			defer os.Remove(path)
 
-			s := fileStorage{cfg: tt.cfg, storage: tt.storage}
+			s := New(tt.storage, tt.cfg)
 			s.WriteMetrics(false)
 
 			if tt.expectFile {
@@ -185,10 +186,45 @@ func TestWriteMetrics_ReadMetricsError(t *testing.T) {
 
 	mockStorage := MockStorage{err: errors.New("error")}
 
-	s := fileStorage{
-		cfg:     &config.Config{FileStoragePath: "mock/path"},
-		storage: &mockStorage,
-	}
+	s := New(&mockStorage, &config.Config{FileStoragePath: "mock/path"})
 
 	s.WriteMetrics(false)
 }
+
+func TestWriteMetrics_Concurrent(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	floatValue := 42.5
+	metrics := []models.Metrics{{ID: "metric1", MType: "gauge", Value: &floatValue}}
+
+	dir, _ := os.Getwd()
+	storeDir := filepath.Join(dir, "concurrent")
+	defer os.RemoveAll(storeDir)
+
+	// при StoreInterval == 0 файл пишет каждый запрос на обновление, в том числе одновременно
+	s := New(&MockStorage{metrics: metrics}, &config.Config{FileStoragePath: "concurrent/test.json"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.WriteMetrics(false)
+		}()
+	}
+	wg.Wait()
+
+	file, err := os.Open(filepath.Join(storeDir, "test.json"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	var saved []models.Metrics
+	require.NoError(t, json.NewDecoder(file).Decode(&saved))
+	assert.Equal(t, metrics, saved)
+
+	// временные файлы после записи не остаются
+	entries, err := os.ReadDir(storeDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
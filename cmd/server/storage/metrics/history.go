@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/dglazkoff/go-metrics/internal/models"
+)
+
+// ring - кольцевой буфер последних значений метрики, при переполнении затирает самые старые значения
+type ring struct {
+	points []models.MetricPoint
+	start  int
+	size   int
+}
+
+func newRing(capacity int) *ring {
+	return &ring{points: make([]models.MetricPoint, capacity)}
+}
+
+func (r *ring) push(point models.MetricPoint) {
+	if r.size < len(r.points) {
+		r.points[(r.start+r.size)%len(r.points)] = point
+		r.size++
+		return
+	}
+
+	r.points[r.start] = point
+	r.start = (r.start + 1) % len(r.points)
+}
+
+// between - возвращает копии значений в интервале [from, to] в порядке добавления
+func (r *ring) between(from, to time.Time) []models.MetricPoint {
+	points := make([]models.MetricPoint, 0)
+
+	for i := 0; i < r.size; i++ {
+		point := r.points[(r.start+i)%len(r.points)]
+
+		if point.Timestamp.Before(from) || point.Timestamp.After(to) {
+			continue
+		}
+
+		metric := copyMetric(models.Metrics{Delta: point.Delta, Value: point.Value})
+		points = append(points, models.MetricPoint{Timestamp: point.Timestamp, Delta: metric.Delta, Value: metric.Value})
+	}
+
+	return points
+}
@@ -1,66 +1,277 @@
+// Пакет metrics - потокобезопасное хранилище метрик в памяти
 package metrics
 
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	constants "github.com/dglazkoff/go-metrics/internal/const"
 	"github.com/dglazkoff/go-metrics/internal/models"
 )
 
+// metricKey - ключ метрики в хранилище: метрики разных типов или с разными метками могут иметь одинаковое имя
+type metricKey struct {
+	mType  string
+	id     string
+	labels string
+}
+
+func keyOf(metric models.Metrics) metricKey {
+	return metricKey{mType: metric.MType, id: metric.ID, labels: models.LabelsKey(metric.Labels)}
+}
+
+/*
+хендлеры chi выполняются конкурентно, а fileStorage.WriteMetrics читает хранилище из отдельной горутины,
+поэтому все обращения к map защищены RWMutex.
+Наружу отдаем только копии метрик, чтобы вызывающий код не мог поменять значения в хранилище через указатели Delta/Value
+*/
 type storage struct {
-	metrics []models.Metrics
+	mu      sync.RWMutex
+	metrics map[metricKey]models.Metrics
+
+	// идентификаторы последних примененных пачек метрик, не больше BatchCacheSize
+	batches     map[string]struct{}
+	batchOrder  []string
+	batchCursor int
+
+	// история значений, заполняется только если хранилище создано с опцией WithHistory
+	history     map[metricKey]*ring
+	historySize int
+	now         func() time.Time
 }
 
-func New(metrics []models.Metrics) *storage {
-	storeMetrics := append([]models.Metrics{}, metrics...)
+// Option - необязательная настройка хранилища
+type Option func(*storage)
 
-	return &storage{
-		metrics: storeMetrics,
+// WithHistory - хранилище помимо последнего значения хранит historySize последних значений каждой метрики
+func WithHistory(historySize int) Option {
+	return func(s *storage) {
+		s.history = make(map[metricKey]*ring)
+		s.historySize = historySize
 	}
 }
 
-func (s *storage) ReadMetrics(_ context.Context) ([]models.Metrics, error) {
-	return s.metrics, nil
+func New(metrics []models.Metrics, opts ...Option) *storage {
+	s := &storage{
+		metrics: make(map[metricKey]models.Metrics, len(metrics)),
+		batches: make(map[string]struct{}),
+		now:     time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	for _, metric := range metrics {
+		s.metrics[keyOf(metric)] = copyMetric(metric)
+	}
+
+	return s
+}
+
+// copyMetric - возвращает копию метрики, не разделяющую указатели с исходной
+func copyMetric(metric models.Metrics) models.Metrics {
+	if metric.Delta != nil {
+		delta := *metric.Delta
+		metric.Delta = &delta
+	}
+
+	if metric.Value != nil {
+		value := *metric.Value
+		metric.Value = &value
+	}
+
+	metric.Labels = models.CopyLabels(metric.Labels)
+
+	return metric
 }
 
-func (s *storage) ReadMetric(_ context.Context, name string) (models.Metrics, error) {
+// ReadMetrics - возвращает копии всех метрик, отсортированные по типу, имени и меткам
+func (s *storage) ReadMetrics(_ context.Context) ([]models.Metrics, error) {
+	s.mu.RLock()
+	metrics := make([]models.Metrics, 0, len(s.metrics))
 	for _, metric := range s.metrics {
-		if metric.ID == name {
-			return metric, nil
+		metrics = append(metrics, copyMetric(metric))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].MType != metrics[j].MType {
+			return metrics[i].MType < metrics[j].MType
+		}
+
+		if metrics[i].ID != metrics[j].ID {
+			return metrics[i].ID < metrics[j].ID
 		}
+
+		return models.LabelsKey(metrics[i].Labels) < models.LabelsKey(metrics[j].Labels)
+	})
+
+	return metrics, nil
+}
+
+func (s *storage) ReadMetric(_ context.Context, mType string, name string, labels map[string]string) (models.Metrics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if metric, ok := s.metrics[metricKey{mType: mType, id: name, labels: models.LabelsKey(labels)}]; ok {
+		return copyMetric(metric), nil
 	}
 
 	return models.Metrics{}, fmt.Errorf("metric not found by name %s", name)
 }
 
 func (s *storage) UpdateMetric(_ context.Context, metric models.Metrics) error {
-	for i, m := range s.metrics {
-		if m.ID == metric.ID {
-			if metric.MType == constants.MetricTypeGauge {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.updateMetric(metric)
+}
+
+// validateMetric - проверяет, что метрику можно записать в хранилище
+func validateMetric(metric models.Metrics) error {
+	switch metric.MType {
+	case constants.MetricTypeGauge:
+		if metric.Value == nil {
+			return fmt.Errorf("required Value field for gauge metric %s", metric.ID)
+		}
+	case constants.MetricTypeCounter:
+		if metric.Delta == nil {
+			return fmt.Errorf("required Delta field for counter metric %s", metric.ID)
+		}
+	default:
+		return fmt.Errorf("unknown metric type %s", metric.MType)
+	}
+
+	return nil
+}
 
-				s.metrics[i] = metric
-				return nil
-			}
+// updateMetric - обновляет метрику, вызывается под захваченной блокировкой
+func (s *storage) updateMetric(metric models.Metrics) error {
+	if err := validateMetric(metric); err != nil {
+		return err
+	}
 
-			if metric.MType == constants.MetricTypeCounter {
-				*s.metrics[i].Delta += *metric.Delta
-				return nil
-			}
+	key := keyOf(metric)
 
-			return fmt.Errorf("unknown metric type %s", metric.MType)
+	switch metric.MType {
+	case constants.MetricTypeGauge:
+		s.metrics[key] = copyMetric(metric)
+	case constants.MetricTypeCounter:
+		delta := *metric.Delta
+		if stored, ok := s.metrics[key]; ok && stored.Delta != nil {
+			delta += *stored.Delta
 		}
+
+		metric.Delta = &delta
+		metric.Value = nil
+		metric.Labels = models.CopyLabels(metric.Labels)
+		s.metrics[key] = metric
 	}
 
-	s.metrics = append(s.metrics, metric)
+	s.appendHistory(key)
+
 	return nil
 }
 
+// appendHistory - добавляет текущее значение метрики в историю, вызывается под захваченной блокировкой
+func (s *storage) appendHistory(key metricKey) {
+	if s.history == nil || s.historySize <= 0 {
+		return
+	}
+
+	r, ok := s.history[key]
+	if !ok {
+		r = newRing(s.historySize)
+		s.history[key] = r
+	}
+
+	metric := copyMetric(s.metrics[key])
+	r.push(models.MetricPoint{Timestamp: s.now(), Delta: metric.Delta, Value: metric.Value})
+}
+
+// ReadHistory - возвращает сохраненные значения метрики в интервале [from, to]
+func (s *storage) ReadHistory(_ context.Context, mType string, name string, labels map[string]string, from, to time.Time) ([]models.MetricPoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.history[metricKey{mType: mType, id: name, labels: models.LabelsKey(labels)}]
+	if !ok {
+		return []models.MetricPoint{}, nil
+	}
+
+	return r.between(from, to), nil
+}
+
+// SaveMetrics - применяет список метрик атомарно: либо все, либо ни одной
 func (s *storage) SaveMetrics(_ context.Context, metrics []models.Metrics) error {
-	s.metrics = append(s.metrics, metrics...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, metric := range metrics {
+		if err := validateMetric(metric); err != nil {
+			return err
+		}
+	}
+
+	for _, metric := range metrics {
+		if err := s.updateMetric(metric); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// BatchCacheSize - сколько последних примененных пачек метрик помнит хранилище в памяти
+const BatchCacheSize = 10000
+
+/*
+SaveBatch - применяет пачку метрик атомарно, если пачка batchID еще не применялась.
+Хранилище помнит BatchCacheSize последних пачек, повтор более старой пачки будет применен еще раз
+*/
+func (s *storage) SaveBatch(_ context.Context, batchID string, metrics []models.Metrics) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.batches[batchID]; ok {
+		return false, nil
+	}
+
+	for _, metric := range metrics {
+		if err := validateMetric(metric); err != nil {
+			return false, err
+		}
+	}
+
+	for _, metric := range metrics {
+		if err := s.updateMetric(metric); err != nil {
+			return false, err
+		}
+	}
+
+	// кольцевой буфер: новая пачка вытесняет самую старую
+	if len(s.batchOrder) < BatchCacheSize {
+		s.batchOrder = append(s.batchOrder, batchID)
+	} else {
+		delete(s.batches, s.batchOrder[s.batchCursor])
+		s.batchOrder[s.batchCursor] = batchID
+		s.batchCursor = (s.batchCursor + 1) % BatchCacheSize
+	}
+
+	s.batches[batchID] = struct{}{}
+
+	return true, nil
+}
+
 func (s *storage) PingDB(_ context.Context) error {
 	return nil
 }
+
+// Close - хранилищу в памяти нечего закрывать
+func (s *storage) Close() error {
+	return nil
+}
@@ -0,0 +1,242 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	constants "github.com/dglazkoff/go-metrics/internal/const"
+	"github.com/dglazkoff/go-metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_UpdateMetric(t *testing.T) {
+	ctx := context.Background()
+	delta := int64(5)
+	value := 1.5
+
+	store := New([]models.Metrics{})
+
+	require.NoError(t, store.UpdateMetric(ctx, models.Metrics{ID: "name", MType: constants.MetricTypeCounter, Delta: &delta}))
+	require.NoError(t, store.UpdateMetric(ctx, models.Metrics{ID: "name", MType: constants.MetricTypeCounter, Delta: &delta}))
+	require.NoError(t, store.UpdateMetric(ctx, models.Metrics{ID: "name", MType: constants.MetricTypeGauge, Value: &value}))
+
+	assert.Equal(t, int64(5), delta, "input metric must not be mutated")
+
+	metrics, err := store.ReadMetrics(ctx)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, constants.MetricTypeCounter, metrics[0].MType)
+	assert.Equal(t, int64(10), *metrics[0].Delta)
+	assert.Equal(t, constants.MetricTypeGauge, metrics[1].MType)
+	assert.Equal(t, 1.5, *metrics[1].Value)
+
+	err = store.UpdateMetric(ctx, models.Metrics{ID: "name", MType: "unknown"})
+	assert.EqualError(t, err, "unknown metric type unknown")
+
+	err = store.UpdateMetric(ctx, models.Metrics{ID: "name", MType: constants.MetricTypeCounter})
+	assert.Error(t, err)
+}
+
+func TestStorage_ReadMetricsReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	delta := int64(1)
+
+	store := New([]models.Metrics{{ID: "name", MType: constants.MetricTypeCounter, Delta: &delta}})
+
+	metrics, err := store.ReadMetrics(ctx)
+	require.NoError(t, err)
+	*metrics[0].Delta = 100
+
+	metric, err := store.ReadMetric(ctx, constants.MetricTypeCounter, "name", nil)
+	require.NoError(t, err)
+	*metric.Delta = 200
+
+	metric, err = store.ReadMetric(ctx, constants.MetricTypeCounter, "name", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *metric.Delta)
+
+	_, err = store.ReadMetric(ctx, constants.MetricTypeCounter, "unknown", nil)
+	assert.Error(t, err)
+}
+
+func TestStorage_SaveMetricsIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	delta := int64(1)
+
+	store := New([]models.Metrics{})
+
+	err := store.SaveMetrics(ctx, []models.Metrics{
+		{ID: "first", MType: constants.MetricTypeCounter, Delta: &delta},
+		{ID: "second", MType: constants.MetricTypeGauge},
+	})
+	assert.Error(t, err)
+
+	metrics, err := store.ReadMetrics(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+}
+
+func TestStorage_SaveBatch(t *testing.T) {
+	ctx := context.Background()
+	delta := int64(2)
+
+	store := New([]models.Metrics{})
+	batch := []models.Metrics{{ID: "PollCount", MType: constants.MetricTypeCounter, Delta: &delta}}
+
+	applied, err := store.SaveBatch(ctx, "batch-1", batch)
+	require.NoError(t, err)
+	assert.True(t, applied)
+
+	// повтор пачки не задваивает counter
+	applied, err = store.SaveBatch(ctx, "batch-1", batch)
+	require.NoError(t, err)
+	assert.False(t, applied)
+
+	metric, err := store.ReadMetric(ctx, constants.MetricTypeCounter, "PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *metric.Delta)
+
+	// некорректная пачка не применяется и не запоминается
+	_, err = store.SaveBatch(ctx, "batch-2", []models.Metrics{{ID: "HeapAlloc", MType: constants.MetricTypeGauge}})
+	assert.Error(t, err)
+
+	applied, err = store.SaveBatch(ctx, "batch-2", batch)
+	require.NoError(t, err)
+	assert.True(t, applied)
+
+	// самые старые пачки вытесняются
+	for i := 0; i < BatchCacheSize; i++ {
+		_, err = store.SaveBatch(ctx, fmt.Sprintf("fill-%d", i), nil)
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, store.batches, BatchCacheSize)
+
+	applied, err = store.SaveBatch(ctx, "batch-1", batch)
+	require.NoError(t, err)
+	assert.True(t, applied)
+}
+
+// запускать с -race
+func TestStorage_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	store := New([]models.Metrics{})
+
+	const workers = 16
+	const iterations = 500
+
+	var wg sync.WaitGroup
+	wg.Add(workers * 2)
+
+	for i := 0; i < workers; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < iterations; j++ {
+				delta := int64(1)
+				value := float64(j)
+				err := store.SaveMetrics(ctx, []models.Metrics{
+					{ID: "PollCount", MType: constants.MetricTypeCounter, Delta: &delta},
+					{ID: fmt.Sprintf("gauge%d", i), MType: constants.MetricTypeGauge, Value: &value},
+				})
+				assert.NoError(t, err)
+			}
+		}(i)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < iterations; j++ {
+				metrics, err := store.ReadMetrics(ctx)
+				assert.NoError(t, err)
+
+				for _, m := range metrics {
+					if m.Delta != nil {
+						*m.Delta = 0
+					}
+				}
+
+				_, _ = store.ReadMetric(ctx, constants.MetricTypeCounter, "PollCount", nil)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	metric, err := store.ReadMetric(ctx, constants.MetricTypeCounter, "PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(workers*iterations), *metric.Delta)
+}
+
+func TestStorage_History(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+
+	store := New([]models.Metrics{}, WithHistory(3))
+	store.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	delta := int64(1)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.UpdateMetric(ctx, models.Metrics{ID: "PollCount", MType: constants.MetricTypeCounter, Delta: &delta}))
+	}
+
+	points, err := store.ReadHistory(ctx, constants.MetricTypeCounter, "PollCount", nil, start, now)
+	require.NoError(t, err)
+	require.Len(t, points, 3, "ring buffer keeps only last values")
+	assert.Equal(t, int64(3), *points[0].Delta)
+	assert.Equal(t, int64(5), *points[2].Delta)
+	assert.Equal(t, start.Add(5*time.Second), points[2].Timestamp)
+
+	points, err = store.ReadHistory(ctx, constants.MetricTypeCounter, "PollCount", nil, start.Add(4*time.Second), start.Add(4*time.Second))
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+	assert.Equal(t, int64(4), *points[0].Delta)
+
+	*points[0].Delta = 100
+	points, err = store.ReadHistory(ctx, constants.MetricTypeCounter, "PollCount", nil, start.Add(4*time.Second), start.Add(4*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), *points[0].Delta)
+
+	withoutHistory := New([]models.Metrics{})
+	require.NoError(t, withoutHistory.UpdateMetric(ctx, models.Metrics{ID: "PollCount", MType: constants.MetricTypeCounter, Delta: &delta}))
+	points, err = withoutHistory.ReadHistory(ctx, constants.MetricTypeCounter, "PollCount", nil, start, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, points)
+}
+
+func TestStorage_Labels(t *testing.T) {
+	ctx := context.Background()
+	first := 1.0
+	second := 2.0
+
+	store := New([]models.Metrics{})
+
+	require.NoError(t, store.UpdateMetric(ctx, models.Metrics{ID: "HeapAlloc", MType: constants.MetricTypeGauge, Value: &first, Labels: map[string]string{"host": "agent-1"}}))
+	require.NoError(t, store.UpdateMetric(ctx, models.Metrics{ID: "HeapAlloc", MType: constants.MetricTypeGauge, Value: &second, Labels: map[string]string{"host": "agent-2"}}))
+
+	metrics, err := store.ReadMetrics(ctx)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, map[string]string{"host": "agent-1"}, metrics[0].Labels)
+	assert.Equal(t, map[string]string{"host": "agent-2"}, metrics[1].Labels)
+
+	metric, err := store.ReadMetric(ctx, constants.MetricTypeGauge, "HeapAlloc", map[string]string{"host": "agent-2"})
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, *metric.Value)
+
+	metric.Labels["host"] = "changed"
+	metric, err = store.ReadMetric(ctx, constants.MetricTypeGauge, "HeapAlloc", map[string]string{"host": "agent-2"})
+	require.NoError(t, err)
+	assert.Equal(t, "agent-2", metric.Labels["host"])
+
+	_, err = store.ReadMetric(ctx, constants.MetricTypeGauge, "HeapAlloc", nil)
+	assert.Error(t, err)
+}
@@ -4,6 +4,7 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
 	"github.com/dglazkoff/go-metrics/cmd/server/storage/db"
@@ -15,16 +16,22 @@ import (
 
 // MetricsStorage - интерфейс для работы с хранилищем метрик
 type MetricsStorage interface {
-	// ReadMetric - метод для получения метрики по имени
-	ReadMetric(ctx context.Context, name string) (models.Metrics, error)
+	// ReadMetric - метод для получения метрики по типу, имени и меткам
+	ReadMetric(ctx context.Context, mType string, name string, labels map[string]string) (models.Metrics, error)
 	// ReadMetrics - метод для получения всех метрик
 	ReadMetrics(ctx context.Context) ([]models.Metrics, error)
 	// UpdateMetric - метод для обновления метрики
 	UpdateMetric(ctx context.Context, metric models.Metrics) error
 	// SaveMetrics - метод для добавления списка метрик
 	SaveMetrics(ctx context.Context, metrics []models.Metrics) error
+	// SaveBatch - метод для добавления пачки метрик batchID, если она еще не применялась. false - пачка уже была применена
+	SaveBatch(ctx context.Context, batchID string, metrics []models.Metrics) (bool, error)
+	// ReadHistory - метод для получения истории значений метрики за интервал [from, to]
+	ReadHistory(ctx context.Context, mType string, name string, labels map[string]string, from, to time.Time) ([]models.MetricPoint, error)
 	// PingDB - метод для проверки соединения с БД
 	PingDB(ctx context.Context) error
+	// Close - метод для освобождения ресурсов хранилища при остановке сервера
+	Close() error
 }
 
 // FileStorage - интерфейс для работы с файловым хранилищем
@@ -36,6 +43,7 @@ type FileStorage interface {
 	ReadMetrics()
 }
 
+// InitStorages - создает хранилище метрик по конфигурации, хранилище закрывается вызывающим кодом через Close
 func InitStorages(cfg *config.Config) (MetricsStorage, FileStorage, error) {
 	var store MetricsStorage
 
@@ -46,17 +54,25 @@ func InitStorages(cfg *config.Config) (MetricsStorage, FileStorage, error) {
 			logger.Log.Debug("Error on open db", "err", err)
 			return nil, nil, err
 		}
-		defer pgDB.Close()
 
-		dbStore := db.New(pgDB, db.RetryIntervals)
+		var dbOpts []db.Option
+		if cfg.IsHistory {
+			dbOpts = append(dbOpts, db.WithHistory())
+		}
+
+		dbStore := db.New(pgDB, db.RetryIntervals, dbOpts...)
+
 		err = db.Bootstrap(dbStore)
 
 		if err != nil {
 			logger.Log.Debug("Error on bootstrap db ", err)
+			pgDB.Close()
 			return nil, nil, err
 		}
 
 		store = dbStore
+	} else if cfg.IsHistory {
+		store = metrics.New([]models.Metrics{}, metrics.WithHistory(cfg.HistorySize))
 	} else {
 		store = metrics.New([]models.Metrics{})
 	}
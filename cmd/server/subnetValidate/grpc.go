@@ -0,0 +1,71 @@
+package subnetvalidate
+
+import (
+	"context"
+	"net"
+
+	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RealIPMetadataKey - ключ метаданных gRPC с IP агента, аналог заголовка X-Real-IP
+const RealIPMetadataKey = "x-real-ip"
+
+// ForwardedForMetadataKey - ключ метаданных gRPC с цепочкой адресов прокси, аналог заголовка X-Forwarded-For
+const ForwardedForMetadataKey = "x-forwarded-for"
+
+// writeMethods - методы gRPC, изменяющие метрики. Как и маршруты записи HTTP, только они проверяются на доверенную подсеть
+var writeMethods = map[string]bool{
+	pb.Metrics_UpdateMetrics_FullMethodName: true,
+	pb.Metrics_StreamMetrics_FullMethodName: true,
+}
+
+// contextIP - адрес клиента по адресу соединения и метаданным x-forwarded-for и x-real-ip
+func (subnet *Subnet) contextIP(ctx context.Context) net.IP {
+	var remote, realIP string
+	var forwarded []string
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remote = remoteHost(p.Addr.String())
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		forwarded = md.Get(ForwardedForMetadataKey)
+
+		if values := md.Get(RealIPMetadataKey); len(values) > 0 {
+			realIP = values[0]
+		}
+	}
+
+	return subnet.clientIP(remote, forwarded, realIP)
+}
+
+func (subnet *Subnet) validateContext(ctx context.Context, method string) error {
+	if !subnet.enabled || !writeMethods[method] || subnet.isTrusted(subnet.contextIP(ctx)) {
+		return nil
+	}
+
+	return status.Error(codes.PermissionDenied, "ip address is not in trusted subnet")
+}
+
+// UnaryInterceptor - проверка доверенной подсети для unary gRPC вызовов
+func (subnet *Subnet) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := subnet.validateContext(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// StreamInterceptor - проверка доверенной подсети для stream gRPC вызовов
+func (subnet *Subnet) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := subnet.validateContext(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
@@ -0,0 +1,92 @@
+package subnetvalidate
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	pb "github.com/dglazkoff/go-metrics/internal/models/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestSubnet_UnaryInterceptor(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	ts := Initialize(&config.Config{TrustedSubnet: "192.168.31.0/24", TrustedProxies: "10.0.0.1"})
+
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	}
+
+	withPeer := func(ip string) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 5000}})
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{name: "trusted x-real-ip", ctx: metadata.NewIncomingContext(withPeer("10.0.0.1"), metadata.Pairs(RealIPMetadataKey, "192.168.31.5")), code: codes.OK},
+		{name: "untrusted x-real-ip", ctx: metadata.NewIncomingContext(withPeer("10.0.0.1"), metadata.Pairs(RealIPMetadataKey, "10.0.0.2")), code: codes.PermissionDenied},
+		{name: "x-real-ip from untrusted proxy is ignored", ctx: metadata.NewIncomingContext(withPeer("172.16.0.1"), metadata.Pairs(RealIPMetadataKey, "192.168.31.5")), code: codes.PermissionDenied},
+		{name: "x-real-ip from trusted subnet is ignored", ctx: metadata.NewIncomingContext(withPeer("192.168.31.5"), metadata.Pairs(RealIPMetadataKey, "10.0.0.2")), code: codes.OK},
+		{name: "trusted x-forwarded-for", ctx: metadata.NewIncomingContext(withPeer("10.0.0.1"), metadata.Pairs(ForwardedForMetadataKey, "192.168.31.5")), code: codes.OK},
+		{name: "trusted peer address", ctx: withPeer("192.168.31.7"), code: codes.OK},
+		{name: "untrusted peer address", ctx: withPeer("10.0.0.1"), code: codes.PermissionDenied},
+		{name: "no address", ctx: context.Background(), code: codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ts.UnaryInterceptor(tt.ctx, nil, &grpc.UnaryServerInfo{FullMethod: pb.Metrics_UpdateMetrics_FullMethodName}, handler)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestSubnet_ReadMethods(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	ts := Initialize(&config.Config{TrustedSubnet: "192.168.31.0/24"})
+	untrusted := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	}
+
+	// чтение метрик, как и на HTTP, открыто из любой подсети
+	_, err = ts.UnaryInterceptor(untrusted, nil, &grpc.UnaryServerInfo{FullMethod: pb.Metrics_GetMetric_FullMethodName}, handler)
+	assert.NoError(t, err)
+
+	stream := &serverStream{ctx: untrusted}
+	streamHandler := func(srv any, ss grpc.ServerStream) error {
+		return nil
+	}
+
+	err = ts.StreamInterceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: pb.Metrics_ListMetrics_FullMethodName}, streamHandler)
+	assert.NoError(t, err)
+
+	err = ts.StreamInterceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: pb.Metrics_StreamMetrics_FullMethodName}, streamHandler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// serverStream - grpc.ServerStream с заданным контекстом
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -3,45 +3,127 @@ package subnetvalidate
 import (
 	"net"
 	"net/http"
+	"strings"
 
 	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/cmd/server/security"
 	"github.com/dglazkoff/go-metrics/internal/logger"
 )
 
+/*
+Subnet - пропускает запросы только из доверенных подсетей cfg.TrustedSubnet.
+Адрес клиента берется из соединения, а X-Real-IP и X-Forwarded-For учитываются, только если
+соединение пришло от прокси из cfg.TrustedProxies
+*/
 type Subnet struct {
-	cfg *config.Config
+	enabled bool
+	trusted []*net.IPNet
+	proxies []*net.IPNet
 }
 
 func Initialize(cfg *config.Config) *Subnet {
-	return &Subnet{cfg}
+	// подсети проверяются при загрузке конфигурации, здесь ошибка оставляет список пустым и запросы отклоняются
+	trusted, err := config.ParseNetworks(cfg.TrustedSubnet)
+	if err != nil {
+		logger.Log.Debug("Error while parsing trusted subnet: ", err)
+	}
+
+	proxies, err := config.ParseNetworks(cfg.TrustedProxies)
+	if err != nil {
+		logger.Log.Debug("Error while parsing trusted proxies: ", err)
+	}
+
+	return &Subnet{enabled: cfg.TrustedSubnet != "", trusted: trusted, proxies: proxies}
 }
 
-func (subnet *Subnet) Validate(handler http.HandlerFunc) http.HandlerFunc {
-	return func(writer http.ResponseWriter, request *http.Request) {
-		if subnet.cfg.TrustedSubnet == "" {
-			handler.ServeHTTP(writer, request)
-			return
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
 		}
+	}
 
-		ipHeader := request.Header.Get("X-Real-IP")
+	return false
+}
 
-		if ipHeader == "" {
-			logger.Log.Debug("No IP address in request")
-			writer.WriteHeader(http.StatusForbidden)
+// remoteHost - адрес без порта из адреса соединения
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+/*
+clientIP - адрес клиента. Если соединение пришло не от доверенного прокси - адрес соединения remote.
+Иначе - первый справа адрес X-Forwarded-For, который не является доверенным прокси, а без X-Forwarded-For - X-Real-IP
+*/
+func (subnet *Subnet) clientIP(remote string, forwarded []string, realIP string) net.IP {
+	ip := net.ParseIP(remote)
+
+	if ip == nil || !contains(subnet.proxies, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, value := range forwarded {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(hops[i]))
+
+		if ip == nil || !contains(subnet.proxies, ip) {
+			return ip
+		}
+	}
+
+	if len(hops) > 0 {
+		return ip
+	}
+
+	if realIP != "" {
+		return net.ParseIP(strings.TrimSpace(realIP))
+	}
+
+	return ip
+}
+
+// isTrusted - проверяет, что ip входит в одну из доверенных подсетей
+func (subnet *Subnet) isTrusted(ip net.IP) bool {
+	if ip == nil {
+		logger.Log.Debug("No IP address in request")
+		security.Reject(security.ReasonUntrustedIP)
+		return false
+	}
+
+	if !contains(subnet.trusted, ip) {
+		logger.Log.Debug("IP address is not in trusted subnet: ", ip)
+		security.Reject(security.ReasonUntrustedIP)
+		return false
+	}
+
+	logger.Log.Debug("IP address is in trusted subnet: ", ip)
+
+	return true
+}
+
+func (subnet *Subnet) Validate(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !subnet.enabled {
+			handler.ServeHTTP(writer, request)
 			return
 		}
 
-		_, ipv4Net, _ := net.ParseCIDR(subnet.cfg.TrustedSubnet)
-		ipv4 := net.ParseIP(ipHeader)
+		ip := subnet.clientIP(remoteHost(request.RemoteAddr), request.Header.Values("X-Forwarded-For"), request.Header.Get("X-Real-IP"))
 
-		if ipv4 == nil || !ipv4Net.Contains(ipv4) {
-			logger.Log.Debug("IP address is not in trusted subnet")
+		if !subnet.isTrusted(ip) {
 			writer.WriteHeader(http.StatusForbidden)
 			return
 		}
 
-		logger.Log.Debug("IP address is in trusted subnet")
-
 		handler.ServeHTTP(writer, request)
 	}
 }
@@ -15,8 +15,10 @@ func TestSubnetValidateHandle(t *testing.T) {
 	err := logger.Initialize()
 	require.NoError(t, err)
 
+	// httptest.NewRequest приходит с адреса 192.0.2.1, X-Real-IP принимается только от доверенного прокси
 	cfg := config.Config{
-		TrustedSubnet: "192.168.31.206/32",
+		TrustedSubnet:  "192.168.31.206/32",
+		TrustedProxies: "192.0.2.1",
 	}
 	ts := Initialize(&cfg)
 
@@ -91,3 +93,55 @@ func TestSubnetValidateHandle(t *testing.T) {
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 	})
 }
+
+func TestSubnetValidate_ClientIP(t *testing.T) {
+	err := logger.Initialize()
+	require.NoError(t, err)
+
+	ts := Initialize(&config.Config{
+		TrustedSubnet:  "192.168.31.0/24,fd00::/8",
+		TrustedProxies: "10.0.0.0/8",
+	})
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		realIP       string
+		forwarded    []string
+		resultStatus int
+	}{
+		{name: "trusted remote address", remoteAddr: "192.168.31.5:5000", resultStatus: http.StatusOK},
+		{name: "trusted ipv6 remote address", remoteAddr: "[fd00::1]:5000", resultStatus: http.StatusOK},
+		{name: "untrusted remote address", remoteAddr: "172.16.0.1:5000", resultStatus: http.StatusForbidden},
+		{name: "x-real-ip from untrusted proxy is ignored", remoteAddr: "172.16.0.1:5000", realIP: "192.168.31.5", resultStatus: http.StatusForbidden},
+		{name: "x-real-ip from trusted proxy", remoteAddr: "10.0.0.1:5000", realIP: "192.168.31.5", resultStatus: http.StatusOK},
+		{name: "x-forwarded-for from trusted proxies", remoteAddr: "10.0.0.1:5000", forwarded: []string{"192.168.31.5, 10.0.0.2"}, resultStatus: http.StatusOK},
+		{name: "spoofed x-forwarded-for", remoteAddr: "10.0.0.1:5000", forwarded: []string{"192.168.31.5", "172.16.0.1"}, resultStatus: http.StatusForbidden},
+		{name: "x-forwarded-for wins over x-real-ip", remoteAddr: "10.0.0.1:5000", realIP: "192.168.31.5", forwarded: []string{"172.16.0.1"}, resultStatus: http.StatusForbidden},
+		{name: "only proxies", remoteAddr: "10.0.0.1:5000", resultStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := ts.Validate(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			req.RemoteAddr = tt.remoteAddr
+
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			assert.Equal(t, tt.resultStatus, w.Code)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Пакет tlsconfig - TLS конфигурация HTTP и gRPC серверов и определение агента по клиентскому сертификату (mTLS)
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/auth"
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+/*
+Load - TLS конфигурация сервера по сертификату и ключу из cfg, nil если TLS не настроен.
+Если задан TLSClientCA, сервер требует клиентский сертификат, подписанный этим CA
+*/
+func Load(cfg *config.Config) (*tls.Config, error) {
+	if cfg.TLSCert == "" && cfg.TLSKey == "" {
+		if cfg.TLSClientCA != "" {
+			return nil, errors.New("client ca requires server tls certificate and key")
+		}
+
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while loading tls certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.TLSClientCA != "" {
+		pool, err := LoadCertPool(cfg.TLSClientCA)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// LoadCertPool - пул сертификатов из PEM файла
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	caPEM, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while reading ca bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates in ca bundle %s", path)
+	}
+
+	return pool, nil
+}
+
+// Identity - имя агента из клиентского сертификата: Common Name, а если он пустой - первое DNS имя
+func Identity(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+
+	return ""
+}
+
+// HTTPIdentity - добавляет в контекст запроса имя агента из проверенного клиентского сертификата
+func HTTPIdentity(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.TLS != nil && len(request.TLS.VerifiedChains) > 0 {
+			agent := Identity(request.TLS.VerifiedChains[0][0])
+			logger.Log.Debug("Agent identity: ", agent)
+
+			request = request.WithContext(auth.WithAgent(request.Context(), agent))
+		}
+
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// grpcAgent - имя агента из проверенного клиентского сертификата gRPC соединения
+func grpcAgent(ctx context.Context) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return ctx
+	}
+
+	agent := Identity(tlsInfo.State.VerifiedChains[0][0])
+	logger.Log.Debug("Agent identity: ", agent)
+
+	return auth.WithAgent(ctx, agent)
+}
+
+// UnaryInterceptor - добавляет в контекст unary вызова имя агента из клиентского сертификата
+func UnaryInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(grpcAgent(ctx), req)
+}
+
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamInterceptor - добавляет в контекст stream вызова имя агента из клиентского сертификата
+func StreamInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &identityStream{ServerStream: ss, ctx: grpcAgent(ss.Context())})
+}
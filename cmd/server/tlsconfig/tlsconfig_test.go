@@ -0,0 +1,203 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dglazkoff/go-metrics/cmd/server/auth"
+	"github.com/dglazkoff/go-metrics/cmd/server/config"
+	"github.com/dglazkoff/go-metrics/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	path string
+}
+
+// writePEM - записывает сертификат и ключ в PEM файлы в dir
+func writePEM(t *testing.T, dir, name string, der []byte, key *ecdsa.PrivateKey) (string, string) {
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certPath, keyPath
+}
+
+func newCA(t *testing.T, dir string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "metrics test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	path, _ := writePEM(t, dir, "ca", der, key)
+
+	return &testCA{cert: cert, key: key, path: path}
+}
+
+// issue - выпускает сертификат, подписанный CA, и возвращает пути до сертификата и ключа
+func (ca *testCA) issue(t *testing.T, dir string, template *x509.Certificate) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return writePEM(t, dir, template.Subject.CommonName+template.SerialNumber.String(), der, key)
+}
+
+// setup - CA, сертификат сервера на 127.0.0.1 и клиентский сертификат агента agent-1
+func setup(t *testing.T) (*config.Config, *tls.Config) {
+	require.NoError(t, logger.Initialize())
+
+	dir := t.TempDir()
+	ca := newCA(t, dir)
+
+	serverCert, serverKey := ca.issue(t, dir, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	clientCert, clientKey := ca.issue(t, dir, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "agent-1"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	cfg := &config.Config{TLSCert: serverCert, TLSKey: serverKey, TLSClientCA: ca.path}
+
+	pool, err := LoadCertPool(ca.path)
+	require.NoError(t, err)
+
+	cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+
+	return cfg, &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+}
+
+func TestLoad(t *testing.T) {
+	tlsConfig, err := Load(&config.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, tlsConfig)
+
+	_, err = Load(&config.Config{TLSClientCA: "ca.crt"})
+	assert.Error(t, err)
+
+	_, err = Load(&config.Config{TLSCert: "not-exists.crt", TLSKey: "not-exists.key"})
+	assert.Error(t, err)
+
+	cfg, _ := setup(t)
+
+	tlsConfig, err = Load(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+
+	cfg.TLSClientCA = ""
+	tlsConfig, err = Load(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+}
+
+func TestIdentity(t *testing.T) {
+	assert.Equal(t, "agent-1", Identity(&x509.Certificate{Subject: pkix.Name{CommonName: "agent-1"}, DNSNames: []string{"agent.local"}}))
+	assert.Equal(t, "agent.local", Identity(&x509.Certificate{DNSNames: []string{"agent.local"}}))
+	assert.Equal(t, "", Identity(&x509.Certificate{}))
+}
+
+func TestHTTPIdentity(t *testing.T) {
+	cfg, clientTLS := setup(t)
+
+	serverTLS, err := Load(cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(HTTPIdentity(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		agent, _ := auth.Agent(request.Context())
+		_, _ = writer.Write([]byte(agent))
+	})))
+	server.TLS = serverTLS
+	server.StartTLS()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+
+	res, err := client.Get(server.URL)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", string(body))
+
+	// без клиентского сертификата сервер не принимает соединение
+	noCertClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: clientTLS.RootCAs, MinVersion: tls.VersionTLS12}}}
+	_, err = noCertClient.Get(server.URL)
+	assert.Error(t, err)
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	_, clientTLS := setup(t)
+
+	leaf, err := x509.ParseCertificate(clientTLS.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+
+	handler := func(ctx context.Context, req any) (any, error) {
+		agent, _ := auth.Agent(ctx)
+		return agent, nil
+	}
+
+	withTLS := peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf}}}},
+	})
+
+	agent, err := UnaryInterceptor(withTLS, nil, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", agent)
+
+	agent, err = UnaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "", agent)
+}
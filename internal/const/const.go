@@ -4,6 +4,13 @@ const (
 	MetricTypeGauge   = "gauge"   // тип метрики gauge
 	MetricTypeCounter = "counter" // тип метрики counter
 
+	AlertStatePending  = "pending"  // условие правила выполняется, но еще не дольше for
+	AlertStateFiring   = "firing"   // условие правила выполняется дольше for
+	AlertStateResolved = "resolved" // условие сработавшего правила перестало выполняться
+
+	BatchIDHeader      = "X-Batch-ID" // HTTP заголовок с идентификатором пачки метрик агента
+	BatchIDMetadataKey = "x-batch-id" // ключ метаданных gRPC с идентификатором пачки, аналог заголовка X-Batch-ID
+
 	// почему то используя в Exec получаю ошибку: syntax error at or near "$1" (SQLSTATE 42601)
 	// pgDB.Exec("CREATE TABLE IF NOT EXISTS $1 (id VARCHAR(250) PRIMARY KEY, type VARCHAR(250) NOT NULL, value DOUBLE PRECISION, delta INTEGER)", constants.TableName)
 	TableName = "metrics"
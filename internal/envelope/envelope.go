@@ -0,0 +1,128 @@
+/*
+Пакет envelope - гибридное шифрование тела запроса агента.
+
+Формат конверта:
+
+	magic "MENV" | версия (1 байт) | длина ключа (2 байта, big endian) | AES ключ, зашифрованный RSA-OAEP SHA-256 | nonce (12 байт) | AES-256-GCM шифротекст
+
+Заголовок (magic и версия) участвует в GCM как дополнительные данные, поэтому подменить версию незаметно нельзя
+*/
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Version - текущая версия формата конверта
+const Version byte = 1
+
+// KeyIDHeader - HTTP заголовок с идентификатором ключа сервера, которым зашифрован конверт
+const KeyIDHeader = "X-Key-ID"
+
+// keySize - размер ключа AES-256
+const keySize = 32
+
+var magic = []byte("MENV")
+
+// headerSize - magic, версия и длина зашифрованного ключа
+var headerSize = len(magic) + 1 + 2
+
+// ErrNotEnvelope - данные не начинаются с заголовка конверта
+var ErrNotEnvelope = errors.New("data is not an envelope")
+
+// IsEnvelope - начинаются ли данные с заголовка конверта
+func IsEnvelope(data []byte) bool {
+	return len(data) >= headerSize && bytes.Equal(data[:len(magic)], magic)
+}
+
+// Seal - шифрует данные случайным AES-256-GCM ключом, а сам ключ - публичным RSA ключом получателя
+func Seal(publicKey *rsa.PublicKey, plaintext []byte) ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while wrapping key: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, headerSize+len(wrappedKey)+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, magic...)
+	out = append(out, Version)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(wrappedKey)))
+	header := out[:len(magic)+1]
+
+	out = append(out, wrappedKey...)
+	out = append(out, nonce...)
+
+	return gcm.Seal(out, nonce, plaintext, header), nil
+}
+
+// Open - расшифровывает конверт приватным RSA ключом и проверяет целостность данных
+func Open(privateKey *rsa.PrivateKey, data []byte) ([]byte, error) {
+	if !IsEnvelope(data) {
+		return nil, ErrNotEnvelope
+	}
+
+	if version := data[len(magic)]; version != Version {
+		return nil, fmt.Errorf("unsupported envelope version %d", version)
+	}
+
+	header := data[:len(magic)+1]
+	keyLen := int(binary.BigEndian.Uint16(data[len(magic)+1 : headerSize]))
+	rest := data[headerSize:]
+
+	if len(rest) < keyLen {
+		return nil, errors.New("envelope is too short")
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, rest[:keyLen], nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while unwrapping key: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rest = rest[keyLen:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, errors.New("envelope is too short")
+	}
+
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf("error while decrypting envelope: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
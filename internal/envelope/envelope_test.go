@@ -0,0 +1,67 @@
+package envelope
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	// больше одного RSA блока - старый формат потребовал бы несколько RSA операций
+	plaintext := make([]byte, 10000)
+	_, err = rand.Read(plaintext)
+	require.NoError(t, err)
+
+	sealed, err := Seal(&privateKey.PublicKey, plaintext)
+	require.NoError(t, err)
+	assert.True(t, IsEnvelope(sealed))
+
+	opened, err := Open(privateKey, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+}
+
+func TestOpen_Errors(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	sealed, err := Seal(&privateKey.PublicKey, []byte(`[{"id":"PollCount","type":"counter","delta":1}]`))
+	require.NoError(t, err)
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+
+	wrongVersion := append([]byte(nil), sealed...)
+	wrongVersion[len(magic)] = Version + 1
+
+	tests := []struct {
+		name string
+		key  *rsa.PrivateKey
+		data []byte
+	}{
+		{name: "not envelope", key: privateKey, data: []byte("plain body")},
+		{name: "tampered ciphertext", key: privateKey, data: tampered},
+		{name: "wrong version", key: privateKey, data: wrongVersion},
+		{name: "truncated", key: privateKey, data: sealed[:headerSize+10]},
+		{name: "wrong key", key: otherKey, data: sealed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Open(tt.key, tt.data)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err = Open(privateKey, []byte("plain body"))
+	assert.ErrorIs(t, err, ErrNotEnvelope)
+}
@@ -0,0 +1,39 @@
+package logger
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryInterceptor - логирует unary gRPC вызов так же, как Request логирует HTTP запрос
+func (log *Logger) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+
+	resp, err := handler(ctx, req)
+
+	log.Infoln(
+		"method", info.FullMethod,
+		"code", status.Code(err),
+		"duration", time.Since(start),
+	)
+
+	return resp, err
+}
+
+// StreamInterceptor - логирует stream gRPC вызов после его завершения
+func (log *Logger) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+
+	err := handler(srv, ss)
+
+	log.Infoln(
+		"method", info.FullMethod,
+		"code", status.Code(err),
+		"duration", time.Since(start),
+	)
+
+	return err
+}
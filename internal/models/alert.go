@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Alert - активный алерт: правило, сработавшее для конкретной метрики
+type Alert struct {
+	Rule       string            `json:"rule"`                  // имя правила
+	Expr       string            `json:"expr"`                  // условие правила
+	State      string            `json:"state"`                 // pending, firing или resolved
+	Metric     string            `json:"metric"`                // имя метрики вместе с метками
+	Labels     map[string]string `json:"labels,omitempty"`      // метки метрики
+	Value      float64           `json:"value"`                 // значение выражения при последней проверке
+	ActiveAt   time.Time         `json:"active_at"`             // когда условие начало выполняться
+	FiredAt    *time.Time        `json:"fired_at,omitempty"`    // когда алерт перешел в firing
+	ResolvedAt *time.Time        `json:"resolved_at,omitempty"` // когда алерт перешел в resolved
+}
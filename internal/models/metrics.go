@@ -1,9 +1,85 @@
 package models
 
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
 // Metrics - структура для хранения данных метрики
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID         string            `json:"id"`                    // имя метрики
+	MType      string            `json:"type"`                  // параметр, принимающий значение gauge или counter
+	Delta      *int64            `json:"delta,omitempty"`       // значение метрики в случае передачи counter
+	Value      *float64          `json:"value,omitempty"`       // значение метрики в случае передачи gauge
+	Labels     map[string]string `json:"labels,omitempty"`      // метки метрики, например host или instance агента
+	LastWriter string            `json:"last_writer,omitempty"` // аутентифицированный агент, последним обновивший метрику, заполняет сервер
+}
+
+// MetricPoint - значение метрики в момент времени, для counter хранится накопленное значение
+type MetricPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Delta     *int64    `json:"delta,omitempty"`
+	Value     *float64  `json:"value,omitempty"`
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidLabelName - имя метки в формате Prometheus: [a-zA-Z_][a-zA-Z0-9_]*
+func ValidLabelName(name string) bool {
+	return labelNameRegexp.MatchString(name)
+}
+
+/*
+LabelsKey - каноническое строковое представление набора меток: key="value" через запятую, ключи отсортированы.
+Метрика идентифицируется типом, именем и LabelsKey, пустой набор меток дает пустую строку.
+Экранируются только значения: имена меток должны проходить ValidLabelName, иначе разные наборы меток могут дать один ключ
+*/
+func LabelsKey(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteString(",")
+		}
+
+		b.WriteString(key + `="` + labelValueReplacer.Replace(labels[key]) + `"`)
+	}
+
+	return b.String()
+}
+
+// CopyLabels - возвращает копию набора меток, nil для пустого набора
+func CopyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(labels))
+	for key, value := range labels {
+		result[key] = value
+	}
+
+	return result
+}
+
+// FullName - имя метрики вместе с метками, например HeapAlloc{host="agent-1"}. Используется как ключ ряда метрики одного типа
+func (m Metrics) FullName() string {
+	if len(m.Labels) == 0 {
+		return m.ID
+	}
+
+	return m.ID + "{" + LabelsKey(m.Labels) + "}"
 }
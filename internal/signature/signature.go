@@ -0,0 +1,74 @@
+/*
+Пакет signature - подпись запросов агента HMAC-SHA256 ключом из cfg.SecretKey.
+
+Подписываются время отправки и одноразовый nonce вместе с телом:
+
+	timestamp "\n" nonce "\n" body
+
+поэтому перехваченный запрос нельзя повторить - сервер отклоняет устаревшее время и уже виденный nonce.
+Без времени и nonce подписывается только тело, как у агентов до появления защиты от повторов
+*/
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// HTTP заголовки и ключи метаданных gRPC с временем отправки запроса и nonce
+const (
+	TimestampHeader      = "X-Timestamp"
+	NonceHeader          = "X-Nonce"
+	TimestampMetadataKey = "x-timestamp"
+	NonceMetadataKey     = "x-nonce"
+)
+
+// nonceSize - размер nonce в байтах
+const nonceSize = 16
+
+// Sign - подпись body вместе с timestamp и nonce, без них - подпись только body
+func Sign(secretKey, timestamp, nonce string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secretKey))
+
+	if timestamp != "" || nonce != "" {
+		h.Write([]byte(timestamp))
+		h.Write([]byte("\n"))
+		h.Write([]byte(nonce))
+		h.Write([]byte("\n"))
+	}
+
+	h.Write(body)
+
+	return h.Sum(nil)
+}
+
+// Timestamp - время t в формате заголовка X-Timestamp, секунды unix time
+func Timestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// ParseTimestamp - время из заголовка X-Timestamp
+func ParseTimestamp(value string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(seconds, 0), nil
+}
+
+// NewNonce - случайный nonce в hex
+func NewNonce() (string, error) {
+	nonce := make([]byte, nonceSize)
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(nonce), nil
+}
@@ -0,0 +1,48 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+
+	// без времени и nonce подпись совпадает с подписью только тела
+	h := hmac.New(sha256.New, []byte("secret"))
+	h.Write(body)
+	assert.Equal(t, h.Sum(nil), Sign("secret", "", "", body))
+
+	signed := Sign("secret", "1700000000", "nonce", body)
+	assert.NotEqual(t, h.Sum(nil), signed)
+	assert.NotEqual(t, signed, Sign("secret", "1700000001", "nonce", body))
+	assert.NotEqual(t, signed, Sign("secret", "1700000000", "other", body))
+	assert.Equal(t, signed, Sign("secret", "1700000000", "nonce", body))
+}
+
+func TestTimestamp(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	assert.Equal(t, "1700000000", Timestamp(now))
+
+	parsed, err := ParseTimestamp(Timestamp(now))
+	require.NoError(t, err)
+	assert.True(t, now.Equal(parsed))
+
+	_, err = ParseTimestamp("yesterday")
+	assert.Error(t, err)
+}
+
+func TestNewNonce(t *testing.T) {
+	first, err := NewNonce()
+	require.NoError(t, err)
+	assert.Len(t, first, 2*nonceSize)
+
+	second, err := NewNonce()
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+}